@@ -0,0 +1,74 @@
+package dealer
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AgingBuckets are the usual aged-inventory report columns, in days on lot: 0-30, 31-60, 61-90 and 91 and up
+var AgingBuckets = []int{0, 31, 61, 91}
+
+// DaysOnLot is how long the vehicle has been in stock as of asOf.  The feed's in-stock date is believed
+// when there is one; otherwise the best we can do is the day we first saw it
+func (vehicle Vehicle) DaysOnLot(asOf time.Time) int {
+	if !vehicle.InStock.IsZero() {
+		return vehicle.InStock.DaysUntil(asOf)
+	}
+	return DateOf(vehicle.Created).DaysUntil(asOf)
+}
+
+// AgingBucket is the index into buckets of the bucket days falls in--buckets being the ascending
+// lower bounds of each, like AgingBuckets
+func AgingBucket(buckets []int, days int) int {
+	bucket := 0
+	for i, lowerBound := range buckets {
+		if days >= lowerBound {
+			bucket = i
+		}
+	}
+	return bucket
+}
+
+// AgedVehicle is a vehicle in an aged-inventory report
+type AgedVehicle struct {
+	Vehicle
+	DaysOnLot int
+}
+
+// AgedInventory lists vehicles on a dealer's lots (every dealer, given a dealerID of 0) that have been in
+// stock at least minDays as of asOf, oldest first.  The Lot on each vehicle is filled in from the master tables
+func AgedInventory(db *gorm.DB, dealerID int, asOf time.Time, minDays int) ([]AgedVehicle, error) {
+	lots, err := Lots(db)
+	if err != nil {
+		return nil, err
+	}
+
+	query := db
+	if dealerID != 0 {
+		query = query.Where("lot_id IN (?)", db.Model(&DealerLot{}).Select("lot_id").Where("d_id = ?", dealerID).SubQuery())
+	}
+	var vehicles []Vehicle
+	if err = query.Find(&vehicles).Error; err != nil {
+		return nil, fmt.Errorf("Finding aged inventory: %w", err)
+	}
+
+	aged := []AgedVehicle{}
+	for _, vehicle := range vehicles {
+		days := vehicle.DaysOnLot(asOf)
+		if days < minDays {
+			continue
+		}
+		vehicle.Lot = lots[vehicle.LotID]
+		aged = append(aged, AgedVehicle{Vehicle: vehicle, DaysOnLot: days})
+	}
+	sort.SliceStable(aged, func(i, j int) bool {
+		if aged[i].DaysOnLot != aged[j].DaysOnLot {
+			return aged[i].DaysOnLot > aged[j].DaysOnLot
+		}
+		return aged[i].ID < aged[j].ID
+	})
+	return aged, nil
+}
@@ -0,0 +1,63 @@
+package dealer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of Anomaly
+const (
+	// AnomalyOdometerRollback is an odometer that went down.  Odometers don't
+	AnomalyOdometerRollback = "odometer_rollback"
+	// AnomalyYearChanged is a vehicle that's suddenly a different model year
+	AnomalyYearChanged = "year_changed"
+	// AnomalyMakeChanged is a Ford that's suddenly a Honda
+	AnomalyMakeChanged = "make_changed"
+)
+
+// Anomaly is a change to a vehicle that shouldn't happen to the same vehicle.  When one turns up, it's usually because
+// a feed mixed up two vehicles' VINs or stock numbers, and matching found the wrong one
+type Anomaly struct {
+	Kind   string      `json:"kind"`
+	Field  string      `json:"field"`
+	Column string      `json:"column"`
+	Old    interface{} `json:"old"`
+	New    interface{} `json:"new"`
+}
+
+// String describes the anomaly for logs
+func (anomaly Anomaly) String() string {
+	return fmt.Sprintf("%s went from %v to %v", anomaly.Field, anomaly.Old, anomaly.New)
+}
+
+// Anomalies compares a vehicle as it was with what a feed says it is now, and lists anything about the change that
+// says it's probably not the same vehicle.  A Make that only changed case or spacing is the same make
+func (vehicle FeedVehicle) Anomalies(now FeedVehicle) []Anomaly {
+	anomalies := []Anomaly{}
+	for _, change := range vehicle.Diff(now) {
+		switch change.Field {
+		case "Odometer":
+			if vehicle.OdometerRolledBack(now) {
+				anomalies = append(anomalies, anomalyOf(AnomalyOdometerRollback, change))
+			}
+		case "Year":
+			anomalies = append(anomalies, anomalyOf(AnomalyYearChanged, change))
+		case "Make":
+			if !strings.EqualFold(strings.Join(strings.Fields(vehicle.Make), " "), strings.Join(strings.Fields(now.Make), " ")) {
+				anomalies = append(anomalies, anomalyOf(AnomalyMakeChanged, change))
+			}
+		}
+	}
+	return anomalies
+}
+
+// OdometerRolledBack is whether now reads less on the odometer than vehicle did.  It's the one comparison behind both
+// AnomalyOdometerRollback and the importer's odometer_decrease rule, so they can't disagree about what a rollback is
+func (vehicle FeedVehicle) OdometerRolledBack(now FeedVehicle) bool {
+	return now.Odometer < vehicle.Odometer
+}
+
+// anomalyOf is the Anomaly a FieldChange amounts to
+func anomalyOf(kind string, change FieldChange) Anomaly {
+	return Anomaly{Kind: kind, Field: change.Field, Column: change.Column, Old: change.Old, New: change.New}
+}
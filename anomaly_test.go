@@ -0,0 +1,43 @@
+package dealer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnomalies(t *testing.T) {
+	was := FeedVehicle{VehicleKey: VehicleKey{VIN: "1GCEP22T1G3329139", Stock: "A124"}, Year: 2018, Make: "Ford", Odometer: 14575, Price: 29999}
+	for _, test := range []struct {
+		name  string
+		now   func(*FeedVehicle)
+		kinds []string
+	}{
+		{"nothing changed", func(now *FeedVehicle) {}, nil},
+		{"price and mileage went up", func(now *FeedVehicle) { now.Odometer, now.Price = 15020, 28999 }, nil},
+		{"odometer rolled back", func(now *FeedVehicle) { now.Odometer = 1457 }, []string{AnomalyOdometerRollback}},
+		{"different year", func(now *FeedVehicle) { now.Year = 2019 }, []string{AnomalyYearChanged}},
+		{"different make", func(now *FeedVehicle) { now.Make = "Honda" }, []string{AnomalyMakeChanged}},
+		// A feed shouting, or padding, isn't a different vehicle
+		{"make in capitals", func(now *FeedVehicle) { now.Make = " FORD " }, nil},
+		{"all of it, in struct order", func(now *FeedVehicle) { now.Odometer, now.Year, now.Make = 0, 2011, "Dodge" },
+			[]string{AnomalyYearChanged, AnomalyMakeChanged, AnomalyOdometerRollback}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			now := was
+			test.now(&now)
+			var kinds []string
+			for _, anomaly := range was.Anomalies(now) {
+				kinds = append(kinds, anomaly.Kind)
+			}
+			if !reflect.DeepEqual(kinds, test.kinds) {
+				t.Errorf("Anomalies are %v, want %v", kinds, test.kinds)
+			}
+		})
+	}
+
+	anomalies := was.Anomalies(FeedVehicle{VehicleKey: was.VehicleKey, Year: 2018, Make: "Ford", Odometer: 1457, Price: 29999})
+	want := []Anomaly{{Kind: AnomalyOdometerRollback, Field: "Odometer", Column: "odometer", Old: 14575, New: 1457}}
+	if !reflect.DeepEqual(anomalies, want) {
+		t.Errorf("Anomalies are %+v, want %+v", anomalies, want)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/seamuncle/dealer"
+)
+
+// dealers handles `import [flags] dealers list` and `import [flags] dealers rename <id> <name>`
+// Renaming is how a dealer name mismatch warning gets resolved in the feed's favour
+func dealers(db *gorm.DB, args []string) error {
+	// The ORM debugging goes to stdout, which is also where the listing goes
+	db.LogMode(false)
+
+	if len(args) == 0 {
+		return fmt.Errorf("Expected dealers list or dealers rename <id> <name>")
+	}
+
+	switch args[0] {
+	case "list":
+		all, err := dealer.ListDealers(db)
+		if err != nil {
+			return err
+		}
+		for _, d := range all {
+			lots, err := dealer.ListLots(db, d.ID)
+			if err != nil {
+				return err
+			}
+			types := []string{}
+			for _, lot := range lots {
+				types = append(types, fmt.Sprintf("%s(%d)", lot.LotType, lot.ID))
+			}
+			fmt.Printf("%d\t%s\t%s\n", d.ID, d.Name, strings.Join(types, " "))
+		}
+		return nil
+
+	case "rename":
+		if len(args) != 3 {
+			return fmt.Errorf("Expected dealers rename <id> <name>")
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("Parsing dealer id (%s): %w", args[1], err)
+		}
+		return dealer.RenameDealer(db, id, args[2])
+	}
+	return fmt.Errorf("Unknown dealers command %s", args[0])
+}
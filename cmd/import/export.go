@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jinzhu/gorm"
+	"github.com/seamuncle/dealer"
+)
+
+// exportRecord is a single vehicle flattened into demoHeadings order--every format writes one of these per vehicle
+type exportRecord []string
+
+// MarshalJSON writes an exportRecord as an object keyed by heading, keeping the heading order
+// which a map[string]string would throw away
+func (record exportRecord) MarshalJSON() ([]byte, error) {
+	b := []byte{'{'}
+	for i, heading := range demoHeadings {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		key, _ := json.Marshal(heading)
+		value, err := json.Marshal(record[i])
+		if err != nil {
+			return nil, err
+		}
+		b = append(append(append(b, key...), ':'), value...)
+	}
+	return append(b, '}'), nil
+}
+
+// MarshalXML writes an exportRecord as a <vehicle> element with a child element per heading
+func (record exportRecord) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name.Local = "vehicle"
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for i, heading := range demoHeadings {
+		if err := e.EncodeElement(record[i], xml.StartElement{Name: xml.Name{Local: heading}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// exportWriter is what each output format has to provide
+type exportWriter interface {
+	Write(record exportRecord) error
+	Close() error
+}
+
+// newExportWriter picks an exportWriter by format name
+func newExportWriter(format string, w io.Writer) (exportWriter, error) {
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(w)
+		return csvExportWriter{writer}, writer.Write(demoHeadings)
+	case "json":
+		_, err := io.WriteString(w, "[")
+		return &jsonExportWriter{w: w}, err
+	case "ndjson":
+		return ndjsonExportWriter{json.NewEncoder(w)}, nil
+	case "xml":
+		encoder := xml.NewEncoder(w)
+		encoder.Indent("", "  ")
+		start := xml.StartElement{Name: xml.Name{Local: "inventory"}}
+		return xmlExportWriter{encoder, start}, encoder.EncodeToken(start)
+	}
+	return nil, fmt.Errorf("Exporting unknown format %s", format)
+}
+
+type csvExportWriter struct{ *csv.Writer }
+
+func (writer csvExportWriter) Write(record exportRecord) error {
+	return writer.Writer.Write(record)
+}
+
+func (writer csvExportWriter) Close() error {
+	writer.Flush()
+	return writer.Error()
+}
+
+// jsonExportWriter writes one big array, which is what most marketplaces seem to want
+type jsonExportWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (writer *jsonExportWriter) Write(record exportRecord) error {
+	b, err := record.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if writer.count > 0 {
+		b = append([]byte{','}, b...)
+	}
+	writer.count++
+	_, err = writer.w.Write(append(b, '\n'))
+	return err
+}
+
+func (writer *jsonExportWriter) Close() error {
+	_, err := io.WriteString(writer.w, "]\n")
+	return err
+}
+
+// ndjsonExportWriter writes one object per line, which is what anything streaming seems to want
+type ndjsonExportWriter struct{ *json.Encoder }
+
+func (writer ndjsonExportWriter) Write(record exportRecord) error {
+	return writer.Encode(record)
+}
+
+func (writer ndjsonExportWriter) Close() error {
+	return nil
+}
+
+type xmlExportWriter struct {
+	*xml.Encoder
+	start xml.StartElement
+}
+
+func (writer xmlExportWriter) Write(record exportRecord) error {
+	return writer.Encode(record)
+}
+
+func (writer xmlExportWriter) Close() error {
+	if err := writer.EncodeToken(writer.start.End()); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// export handles `import [flags] export [-dealer id] [-type NEW|USED|CPO...] [-format csv|json|ndjson|xml] [-out file]`
+// Vehicles come out grouped by lot, same as a feed has to give them to us, so a CSV export
+// can be fed straight back through DemoImporter
+func export(db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dealerID := flags.Int("dealer", 0, "only export vehicles belonging to this dealer id--0 exports every dealer")
+	lotType := flags.String("type", "", "only export vehicles from this lot type (NEW, USED, CPO...)--empty exports every type")
+	format := flags.String("format", "csv", "one of csv, json, ndjson or xml")
+	out := flags.String("out", "", "file to export to--empty writes to stdout")
+	flags.Parse(args)
+
+	// The ORM debugging goes to stdout, which is also where the export goes by default
+	db.LogMode(false)
+
+	query := db.Select("inventory.*").
+		Joins("JOIN lots ON lots.lot_id = inventory.lot_id").
+		Order("lots.d_id, lots.stock_type, inventory.v_id")
+	if *dealerID != 0 {
+		query = query.Where("lots.d_id = ?", *dealerID)
+	}
+	// Anything a feed could call the lot type will do--"used" and "Pre-Owned" are both USED
+	if *lotType != "" {
+		stockType, err := dealer.ParseLotType(*lotType)
+		if err != nil {
+			return fmt.Errorf("Exporting vehicles: %w", err)
+		}
+		query = query.Where("lots.stock_type = ?", stockType)
+	}
+
+	var vehicles []dealer.Vehicle
+	if err := query.Find(&vehicles).Error; err != nil {
+		return fmt.Errorf("Finding vehicles to export: %w", err)
+	}
+	lots, err := dealer.Lots(db)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return exportVehicles(os.Stdout, *format, vehicles, lots)
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		return fmt.Errorf("Creating export file %s: %w", *out, err)
+	}
+	err = exportVehicles(file, *format, vehicles, lots)
+	// A file that didn't close didn't necessarily get written, and an export that's cut short is worse than none
+	if closeErr := file.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("Closing export file %s: %w", *out, closeErr)
+	}
+	return err
+}
+
+// exportVehicles writes vehicles to w in format
+func exportVehicles(w io.Writer, format string, vehicles []dealer.Vehicle, lots map[int]dealer.Lot) error {
+	writer, err := newExportWriter(format, w)
+	if err != nil {
+		return err
+	}
+	for _, vehicle := range vehicles {
+		vehicle.Lot = lots[vehicle.LotID]
+		record := make(exportRecord, len(demoHeadings))
+		for i, heading := range demoHeadings {
+			if record[i], err = exportValue(heading, vehicle); err != nil {
+				return err
+			}
+		}
+		if err = writer.Write(record); err != nil {
+			return fmt.Errorf("Exporting vehicle %d: %w", vehicle.ID, err)
+		}
+	}
+	return writer.Close()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/seamuncle/dealer"
+	"github.com/seamuncle/dealer/importer/importertest"
+)
+
+// readDemo is every vehicle DemoImporter makes of a CSV feed, in feed order
+func readDemo(t *testing.T, filename string, feed []byte) []dealer.Vehicle {
+	t.Helper()
+	records, _, err := demoFormat.ReadCSV(filename, bytes.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vehicles := make([]dealer.Vehicle, len(records))
+	for i, record := range records {
+		if vehicles[i], err = (DemoImporter{}).ProcessRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return vehicles
+}
+
+// exportDemo is vehicles exported in format, each given a lot ID of its own
+func exportDemo(t *testing.T, format string, vehicles []dealer.Vehicle) []byte {
+	t.Helper()
+	lots := map[int]dealer.Lot{}
+	for i := range vehicles {
+		vehicles[i].LotID = i + 1
+		lots[i+1] = vehicles[i].Lot
+	}
+	var out bytes.Buffer
+	if err := exportVehicles(&out, format, vehicles, lots); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	fixture, err := ioutil.ReadFile(filepath.Join("testdata", "roundtrip.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fixture has every column DemoImporter knows, so nothing goes untested
+	headings, _, err := demoFormat.ReadCSV("roundtrip.csv", bytes.NewReader(fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := headings[0].Fields(); !reflect.DeepEqual(got, demoHeadings) {
+		t.Fatalf("Fixture headings are %v, want %v", got, demoHeadings)
+	}
+
+	imported := readDemo(t, "roundtrip.csv", fixture)
+	exported := exportDemo(t, "csv", imported)
+	again := readDemo(t, "export.csv", exported)
+	if len(again) != len(imported) {
+		t.Fatalf("Exported %d vehicles, read back %d", len(imported), len(again))
+	}
+	for i := range imported {
+		if again[i].Lot != imported[i].Lot {
+			t.Errorf("Vehicle %d came back on lot %v, want %v", i+1, again[i].Lot, imported[i].Lot)
+		}
+		if changes := imported[i].FeedVehicle.Diff(again[i].FeedVehicle); len(changes) > 0 {
+			t.Errorf("Vehicle %d came back different: %+v", i+1, changes)
+		}
+	}
+	if twice := exportDemo(t, "csv", again); !bytes.Equal(twice, exported) {
+		t.Errorf("Exporting again gave\n%s\nwant\n%s", twice, exported)
+	}
+
+	// What the feed didn't say for sure is left as it said it, rather than guessed at
+	truck := imported[1].FeedVehicle
+	if truck.Drive != "2WD" || truck.TransmissionType != "" || truck.TransmissionSpeeds != 0 || truck.TransmissionDesc != "6-Speed" {
+		t.Errorf("Truck drives %q, shifts %q %d (%q), want 2WD and an unknown 6-Speed", truck.Drive,
+			truck.TransmissionType, truck.TransmissionSpeeds, truck.TransmissionDesc)
+	}
+	for i, want := range []string{"", "Certified", "Ford Blue Advantage Gold", "", "CPO"} {
+		if program := imported[i].CertificationProgram; program != want {
+			t.Errorf("Vehicle %d certified by %q, want %q", i+1, program, want)
+		}
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	vehicles := []dealer.Vehicle{importertest.Vehicle(importertest.Lot(1001, dealer.TypeUsed), "A124", "1GCEP22T1G3329139").Build()}
+	for _, test := range []struct {
+		format string
+		want   []string
+	}{
+		{"csv", []string{"DealerID,DealerName,Type,Stock,VIN,", "\n1001,Dealer 1001,Used,A124,1GCEP22T1G3329139,"}},
+		{"json", []string{`[{"DealerID":"1001","DealerName":"Dealer 1001","Type":"Used","Stock":"A124",`, "}\n]\n"}},
+		{"ndjson", []string{`{"DealerID":"1001","DealerName":"Dealer 1001","Type":"Used","Stock":"A124",`}},
+		{"xml", []string{"<inventory>\n  <vehicle>\n    <DealerID>1001</DealerID>", "</vehicle>\n</inventory>"}},
+	} {
+		t.Run(test.format, func(t *testing.T) {
+			out := string(exportDemo(t, test.format, vehicles))
+			for _, want := range test.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("Export is\n%s\nwant it to have %q", out, want)
+				}
+			}
+		})
+	}
+	var out bytes.Buffer
+	if err := exportVehicles(&out, "yaml", vehicles, nil); err == nil {
+		t.Errorf("Exported yaml, want an error")
+	}
+}
+
+func TestExportLotType(t *testing.T) {
+	db := importertest.OpenDB(t)
+	defer db.Close()
+	importertest.Seed(t, db,
+		importertest.Vehicle(importertest.Lot(1001, dealer.TypeNew), "A124", "1GCEP22T1G3329139").Build(),
+		importertest.Vehicle(importertest.Lot(1001, dealer.TypeUsed), "B105", "KM8SB12B02U162029").Build(),
+	)
+	dir, err := ioutil.TempDir("", "export-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Whatever a feed would call the lot will do
+	for _, lotType := range []string{"USED", "used", "Pre-Owned"} {
+		out := filepath.Join(dir, "export.csv")
+		if err = export(db, []string{"-type", lotType, "-out", out}); err != nil {
+			t.Fatal(err)
+		}
+		feed, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vehicles := readDemo(t, "export.csv", feed)
+		if len(vehicles) != 1 || vehicles[0].Stock != "B105" {
+			t.Errorf("-type %s exported %v, want B105 alone", lotType, vehicles)
+		}
+	}
+	if err = export(db, []string{"-type", "Sold"}); !errors.Is(err, dealer.ErrUnknownLotType) {
+		t.Errorf("-type Sold gave %v, want %v", err, dealer.ErrUnknownLotType)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/seamuncle/dealer"
+)
+
+// A greedy match here will xxtract the float portion of a string
+var floatRegex = regexp.MustCompile(`[\.\d]+`)
+
+// minConfidence is how sure dealer's powertrain parsers have to be before we take their word over the feed's
+const minConfidence = 0.5
+
+// demoField is one column of the demo feed: how DemoImporter.ProcessRecord puts its value on a vehicle, and how
+// export gets it back out again.  Keeping both halves side by side is what keeps them agreeing with each other
+type demoField struct {
+	heading string
+	// set puts a single value from a feed where it goes on a vehicle
+	set func(vehicle *dealer.Vehicle, value string) error
+	// get is the value back out of a vehicle, in a form set will turn back into the same value
+	get func(vehicle dealer.Vehicle) string
+}
+
+// demoFields are every heading DemoImporter.ProcessRecord understands, in the order the feed gives them to us.
+// TransmissionType and TransmissionSpeeds have no heading of their own; they're derived from the Transmission
+// description, so they survive an export only if they were derived that way to begin with
+var demoFields = []demoField{
+	{"DealerID", func(vehicle *dealer.Vehicle, value string) (err error) {
+		vehicle.DealerID, err = parseInt("DealerID", value)
+		return err
+	}, func(vehicle dealer.Vehicle) string {
+		return strconv.Itoa(vehicle.DealerID)
+	}},
+	{"DealerName", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.DealerName = value
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.DealerName
+	}},
+	// Anything we don't recognise is an error--calling a Demo or a Loaner USED puts it on the wrong lot
+	{"Type", func(vehicle *dealer.Vehicle, value string) (err error) {
+		vehicle.LotType, err = dealer.ParseLotType(value)
+		return err
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.LotType.FeedValue()
+	}},
+	{"Stock", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.Stock = value
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.Stock
+	}},
+	{"VIN", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.VIN = value
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.VIN
+	}},
+	{"Year", func(vehicle *dealer.Vehicle, value string) (err error) {
+		vehicle.Year, err = parseInt("Year", value)
+		return err
+	}, func(vehicle dealer.Vehicle) string {
+		return strconv.Itoa(vehicle.Year)
+	}},
+	{"Make", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.Make = value
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.Make
+	}},
+	{"Model", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.Model = value
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.Model
+	}},
+	{"Trim", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.Trim = value
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.Trim
+	}},
+	{"Body", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.Body = value
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.Body
+	}},
+	{"Doors", func(vehicle *dealer.Vehicle, value string) (err error) {
+		vehicle.Doors, err = optionalInt("Doors", value)
+		return err
+	}, func(vehicle dealer.Vehicle) string {
+		return strconv.Itoa(vehicle.Doors)
+	}},
+	{"ExtColor", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.ExteriorColour = value
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.ExteriorColour
+	}},
+	{"IntColor", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.InteriorColour = value
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.InteriorColour
+	}},
+	{"EngCylinders", func(vehicle *dealer.Vehicle, value string) (err error) {
+		vehicle.Cylinders, err = optionalInt("EngCylinders", value)
+		return err
+	}, func(vehicle dealer.Vehicle) string {
+		return strconv.Itoa(vehicle.Cylinders)
+	}},
+	{"EngDisplacement", func(vehicle *dealer.Vehicle, value string) (err error) {
+		vehicle.Displacement, err = optionalFloat("EngDisplacement", value)
+		return err
+	}, func(vehicle dealer.Vehicle) string {
+		return strconv.FormatFloat(vehicle.Displacement, 'f', -1, 64)
+	}},
+	{"Transmission", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.TransmissionDesc = value
+		transmission := dealer.ParseTransmission(value)
+		// A number of speeds on its own isn't enough to go on either--"6-Speed" doesn't say 6 of what
+		if transmission.Confidence >= minConfidence {
+			vehicle.TransmissionType = transmission.Type
+			vehicle.TransmissionSpeeds = transmission.Speeds
+		}
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.TransmissionDesc
+	}},
+	{"Odometer", func(vehicle *dealer.Vehicle, value string) (err error) {
+		vehicle.Odometer, err = optionalInt("Odometer", value)
+		return err
+	}, func(vehicle dealer.Vehicle) string {
+		return strconv.Itoa(vehicle.Odometer)
+	}},
+	{"Price", func(vehicle *dealer.Vehicle, value string) (err error) {
+		vehicle.Price, err = optionalFloat("Price", value)
+		return err
+	}, func(vehicle dealer.Vehicle) string {
+		return strconv.FormatFloat(vehicle.Price, 'f', -1, 64)
+	}},
+	{"MSRP", func(vehicle *dealer.Vehicle, value string) (err error) {
+		vehicle.MSRP, err = optionalFloat("MSRP", value)
+		return err
+	}, func(vehicle dealer.Vehicle) string {
+		return strconv.FormatFloat(vehicle.MSRP, 'f', -1, 64)
+	}},
+	{"Certified", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.Certified, vehicle.CertificationProgram = dealer.ParseCertified(value)
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		if vehicle.CertificationProgram != "" {
+			return vehicle.CertificationProgram
+		}
+		if vehicle.Certified {
+			return "Yes"
+		}
+		return "No"
+	}},
+	// There's a solid case for this to be the vehicle.Created field; but that sounds like a discussion
+	// so it gets a field of its own, and Created stays the day we first saw the vehicle
+	{"DateInStock", func(vehicle *dealer.Vehicle, value string) error {
+		inStock, err := dealer.ParseDate(value)
+		if err != nil {
+			return fmt.Errorf("Parsing DateInStock: %w", err)
+		}
+		vehicle.InStock = inStock
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.InStock.String()
+	}},
+	// A special mention by any other name, will still drive you insane
+	{"Description", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.Description = value
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.Description
+	}},
+	// Configuration is a single letter, so anything we can't make sense of is left out rather than stored
+	// as is.  Whatever else the description says fills in for columns the feed left empty
+	{"EngType", func(vehicle *dealer.Vehicle, value string) error {
+		engine := dealer.ParseEngine(value)
+		if engine.Confidence >= minConfidence {
+			vehicle.Configuration = engine.Configuration
+		}
+		if vehicle.Cylinders == 0 {
+			vehicle.Cylinders = engine.Cylinders
+		}
+		if vehicle.Displacement == 0 {
+			vehicle.Displacement = engine.Displacement
+		}
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.Configuration
+	}},
+	// A fuel we don't recognise is still better than none
+	{"EngFuel", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.Fuel = value
+		if fuel, confidence := dealer.ParseFuel(value); confidence >= minConfidence {
+			vehicle.Fuel = fuel
+		}
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.Fuel
+	}},
+	{"Drivetrain", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.Drive = value
+		if drive, confidence := dealer.ParseDrivetrain(value); confidence >= minConfidence {
+			vehicle.Drive = drive
+		}
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.Drive
+	}},
+	{"ExtColorGeneric", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.ExtColourGeneric = value
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.ExtColourGeneric
+	}},
+	{"IntColorGeneric", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.IntColourGeneric = value
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.IntColourGeneric
+	}},
+	{"PassengerCount", func(vehicle *dealer.Vehicle, value string) (err error) {
+		vehicle.Passengers, err = parseInt("PassengerCount", value)
+		return err
+	}, func(vehicle dealer.Vehicle) string {
+		return strconv.Itoa(vehicle.Passengers)
+	}},
+	// Nothing but an electric vehicle or a plug-in hybrid has these, so they're empty more often than not--and
+	// empty is none
+	{"BatteryCapacity", func(vehicle *dealer.Vehicle, value string) error {
+		if f := floatRegex.FindString(value); f != "" {
+			capacity, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				return fmt.Errorf("Parsing BatteryCapacity (%s): %w", value, err)
+			}
+			vehicle.BatteryCapacity = capacity
+		}
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return strconv.FormatFloat(vehicle.BatteryCapacity, 'f', -1, 64)
+	}},
+	{"ElectricRange", func(vehicle *dealer.Vehicle, value string) error {
+		if f := floatRegex.FindString(value); f != "" {
+			electricRange, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				return fmt.Errorf("Parsing ElectricRange (%s): %w", value, err)
+			}
+			vehicle.Range = int(electricRange + 0.5)
+		}
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return strconv.Itoa(vehicle.Range)
+	}},
+	// A port we don't recognise is still better than none
+	{"ChargePort", func(vehicle *dealer.Vehicle, value string) error {
+		vehicle.ChargePort = value
+		if port, confidence := dealer.ParseChargePort(value); confidence >= minConfidence {
+			vehicle.ChargePort = port
+		}
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return vehicle.ChargePort
+	}},
+	{"MotorCount", func(vehicle *dealer.Vehicle, value string) error {
+		if strings.TrimSpace(value) == "" {
+			return nil
+		}
+		motors, confidence := dealer.ParseMotors(value)
+		if confidence < minConfidence {
+			return fmt.Errorf("Parsing MotorCount (%s): not a number of motors", value)
+		}
+		vehicle.Motors = motors
+		return nil
+	}, func(vehicle dealer.Vehicle) string {
+		return strconv.Itoa(vehicle.Motors)
+	}},
+}
+
+// demoHeadings are the headings of demoFields, in the same order.  Exporting under the same names means anything
+// we push out can come straight back in
+var demoHeadings = func() []string {
+	headings := make([]string, len(demoFields))
+	for i, field := range demoFields {
+		headings[i] = field.heading
+	}
+	return headings
+}()
+
+// demoFieldsByHeading is demoFields for looking up, since a feed can give its headings in any order it likes
+var demoFieldsByHeading = func() map[string]demoField {
+	fields := map[string]demoField{}
+	for _, field := range demoFields {
+		fields[field.heading] = field
+	}
+	return fields
+}()
+
+// setField puts a single value from a feed where it goes on a vehicle
+func setField(vehicle *dealer.Vehicle, heading, value string) error {
+	field, ok := demoFieldsByHeading[heading]
+	if !ok {
+		return fmt.Errorf("Processing unknown heading '%s'", heading)
+	}
+	return field.set(vehicle, value)
+}
+
+// exportValue is the mirror image of setField--given a heading it gets the value for that heading back out of
+// a vehicle, in a form setField will turn back into the same value
+func exportValue(heading string, vehicle dealer.Vehicle) (string, error) {
+	field, ok := demoFieldsByHeading[heading]
+	if !ok {
+		return "", fmt.Errorf("Exporting unknown heading %s", heading)
+	}
+	return field.get(vehicle), nil
+}
+
+// parseInt is a whole number column of the feed
+func parseInt(heading, value string) (int, error) {
+	number, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Parsing %s (%s): %w", heading, value, err)
+	}
+	return int(number), nil
+}
+
+// optionalInt is a whole number column of the feed that can be left blank, which is 0--an EV has no cylinders,
+// and a feed that doesn't know the doors or the odometer shouldn't cost the rest of the row
+func optionalInt(heading, value string) (int, error) {
+	if strings.TrimSpace(value) == "" {
+		return 0, nil
+	}
+	return parseInt(heading, value)
+}
+
+// optionalFloat is parseFloat for a column that can be left blank, which is 0
+func optionalFloat(heading, value string) (float64, error) {
+	if strings.TrimSpace(value) == "" {
+		return 0, nil
+	}
+	return parseFloat(heading, value)
+}
+
+// parseFloat is a column of the feed with a number in it somewhere, like "2.7 L"
+func parseFloat(heading, value string) (float64, error) {
+	number, err := strconv.ParseFloat(floatRegex.FindString(value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("Parsing %s (%s): %w", heading, value, err)
+	}
+	return number, nil
+}
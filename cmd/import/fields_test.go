@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+// The baseline put the odometer reading in Cylinders, so the mileage never got stored and every car had
+// a six-figure engine
+func TestOdometer(t *testing.T) {
+	feed := "DealerID,DealerName,Type,Stock,VIN,EngCylinders,Odometer\n" +
+		"1001,Strathcom Motors,Used,B105,KM8SB12B02U162029,8,145754\n" +
+		"1001,Strathcom Motors,Used,B106,1GCEP22T1G3329139,,14575\n"
+	vehicles := readDemo(t, "odometer.csv", []byte(feed))
+	for i, want := range []struct{ odometer, cylinders int }{{145754, 8}, {14575, 0}} {
+		vehicle := vehicles[i]
+		if vehicle.Odometer != want.odometer || vehicle.Cylinders != want.cylinders {
+			t.Errorf("Row %d has odometer %d and %d cylinders, want %d and %d",
+				i+1, vehicle.Odometer, vehicle.Cylinders, want.odometer, want.cylinders)
+		}
+	}
+}
@@ -5,15 +5,19 @@ package main
 // installed programming environment on my gaming PC, and I was feeling
 // unmotivated to install compilers and IDEs there.
 import (
-	"encoding/csv"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"regexp"
-	"strconv"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
@@ -23,6 +27,27 @@ import (
 
 var config = importer.Config{}
 
+// archive is where aquired feeds are kept for replays--see importer.Archive
+var archive = importer.Archive{}
+
+// webhook and eventLog are where inventory change events go--see importer.Emitter
+var webhook = importer.WebhookSink{}
+var eventLog string
+
+// entries picks the inventory files out of an archived feed--see importer.Unpacker
+var entries string
+
+// xlsx is where the inventory is in a spreadsheet feed--see importer.XLSXOptions
+var xlsx importer.XLSXOptions
+
+// rulesFile is where the business rules are, for anything more than importer.DefaultRules
+var rulesFile string
+
+// logSQL, metricsTextfile and metricsPush are how much we get told about what an import did
+var logSQL bool
+var metricsTextfile string
+var metricsPush string
+
 // main is responisble for that really high-level stuff.
 // On errors it does log.Fatal,
 // It parses CLI flags and gets them where they need to go
@@ -30,20 +55,167 @@ var config = importer.Config{}
 func main() {
 	flag.StringVar(&config.Filename, "file", "dealer_import.csv", "name of file this import is concerned with--with no prefix")
 	flag.BoolVar(&config.DoProcessing, "process", true, "tells import to continue processing file once its been aquired")
+	flag.BoolVar(&config.DryRun, "dry-run", false, "process the file but roll back every change it makes to the database")
+	flag.StringVar(&archive.Dir, "archive", "/tmp/dealer_archive", "directory to archive every aquired file in--empty disables archiving")
+	flag.DurationVar(&archive.Retention.MaxAge, "retain", 90*24*time.Hour, "how long to keep archived files for--0 keeps them forever")
+	flag.IntVar(&archive.Retention.KeepLast, "retain-last", 10, "how many archived files to keep per feed regardless of their age")
+	flag.StringVar(&webhook.URL, "webhook", "", "URL to POST inventory change events to--empty disables the webhook")
+	flag.StringVar(&webhook.Secret, "webhook-secret", os.Getenv("DEALER_WEBHOOK_SECRET"), "secret to sign webhook bodies with--defaults to $DEALER_WEBHOOK_SECRET")
+	flag.IntVar(&webhook.Retries, "webhook-retries", 3, "how many times to retry a webhook that failed in a way worth retrying")
+	flag.DurationVar(&webhook.Backoff, "webhook-backoff", time.Second, "how long to wait before the first webhook retry--doubling for each after")
+	flag.StringVar(&eventLog, "event-log", "", "file to append inventory change events to as NDJSON--empty disables the log")
+	flag.BoolVar(&config.Outbox, "outbox", false, "write events to the inventory_outbox table alongside the changes they describe, and deliver them from there--on by default when -webhook or -event-log is set")
+	flag.BoolVar(&logSQL, "log-sql", true, "log every SQL statement the ORM runs to stdout")
+	flag.StringVar(&metricsTextfile, "metrics-textfile", "", "file to write Prometheus metrics to for node_exporter's textfile collector")
+	flag.StringVar(&metricsPush, "metrics-push", "", "Pushgateway URL to push Prometheus metrics to")
+	flag.DurationVar(&config.AquireTimeout, "aquire-timeout", time.Minute, "how long aquiring a file gets before giving up--0 waits as long as it takes")
+	flag.Var(&config.DuplicatePolicy, "duplicates", "which row wins when a feed lists a vehicle twice in a lot: last, first, or reject to believe neither")
+	flag.StringVar(&entries, "entries", "", "comma separated patterns picking the inventory files out of a zip or tar.gz feed, like *.csv--empty takes every file")
+	flag.StringVar(&xlsx.Sheet, "sheet", "", "name or number of the sheet the inventory is on, for an .xlsx feed or workbooks in an archived one--empty is the first")
+	flag.IntVar(&xlsx.HeaderRow, "header-row", 0, "row the headings are on, for an .xlsx feed or workbooks in an archived one--0 looks for them")
+	flag.IntVar(&config.BatchSize, "batch-size", importer.DefaultBatchSize, "how many vehicles to write per insert, update or delete statement--1 writes them one at a time")
+	flag.BoolVar(&config.HoldAnomalies, "hold-anomalies", false, "leave a vehicle as it is when the feed rolls its odometer back or changes its year or make, instead of just warning")
+	flag.BoolVar(&config.Review, "review", false, "park whatever -hold-anomalies holds, and rows and lots the rules reject or hold, for the review command to approve or reject")
+	flag.Float64Var(&config.MaxDeletePercent, "max-delete-percent", 0, "leave every vehicle a feed left out on its lot when it left out more than this percentage of them--0 deletes however many it leaves out")
+	flag.StringVar(&rulesFile, "rules", "", "JSON file of business rules every vehicle is checked against--empty warns about importer.DefaultRules")
 	flag.Parse()
 
+	// Structured logs go to stderr, leaving stdout to the ORM and anything a subcommand prints
+	config.Logger = importer.NewLogger(os.Stderr)
+	config.Metrics = importer.NewMetrics()
+
+	if archive.Dir != "" {
+		config.Archive = &archive
+	}
+
+	config.Rules = importer.DefaultRules()
+	if rulesFile != "" {
+		file, err := os.Open(rulesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.Rules, err = importer.LoadRules(file)
+		file.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	emitter := importer.Emitter{}
+	if webhook.URL != "" {
+		emitter.Sinks = append(emitter.Sinks, webhook)
+	}
+	if eventLog != "" {
+		file, err := os.OpenFile(eventLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		emitter.Sinks = append(emitter.Sinks, &importer.NDJSONSink{Writer: file})
+	}
+	config.Emitter = &emitter
+	// An outbox nothing delivers from only fills up, and floods whatever sink turns up first with its whole history--
+	// so it's on when there's somewhere to deliver to, unless we've been told otherwise
+	if !flagSet("outbox") {
+		config.Outbox = len(emitter.Sinks) > 0
+	}
+
 	// There's other approaches to DB initilization, but "things that fatal" belong in main
 	db, err := gorm.Open("sqlite3", "file:dealer_import.db?cache=shared")
 	if err != nil {
 		log.Fatal(err)
 	}
-	db.LogMode(true)
-	demo := DemoImporter{}
+	db.LogMode(logSQL)
+	if err = dealer.Migrate(db); err != nil {
+		log.Fatal(err)
+	}
+	if config.Outbox {
+		if err = db.AutoMigrate(&importer.OutboxMessage{}).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = db.AutoMigrate(&importer.PendingChange{}).Error; err != nil {
+		log.Fatal(err)
+	}
+	demo := DemoImporter{XLSX: xlsx}
+	if entries != "" {
+		demo.Unpacker.Patterns = strings.Split(entries, ",")
+	}
+	// Spreadsheets get read by a spreadsheet importer, which only differs in how it loads records
+	var feed importer.Importer = demo
+	if strings.EqualFold(filepath.Ext(config.Filename), ".xlsx") {
+		feed = XLSXImporter{DemoImporter: demo}
+	}
 	runner := importer.FullReplaceRunner{
 		Config: config,
 	}
+	dispatcher := importer.Dispatcher{
+		Emitter:     emitter,
+		MaxAttempts: 10,
+		Metrics:     config.Metrics,
+	}
 
-	if err := runner.Run(demo, db); err != nil {
+	// SIGINT or SIGTERM stops the run after whatever lot it's in the middle of replacing--
+	// a second one doesn't wait for that
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, stopping after the current lot", sig)
+		cancel()
+		sig = <-signals
+		log.Fatalf("Received %s, stopping now", sig)
+	}()
+
+	// Anything left on the command line after the flags is a subcommand
+	switch flag.Arg(0) {
+	case "":
+		err = runner.RunContext(ctx, feed, db)
+		if err == nil && config.Outbox && !config.DryRun {
+			dispatchAfter(ctx, dispatcher, db)
+		}
+	case "dispatch":
+		// Delivers whatever a previous run left in the outbox
+		err = dispatch(ctx, dispatcher, db, flag.Args()[1:])
+	case "replay":
+		err = replay(ctx, runner, feed, db, flag.Args()[1:])
+	case "export":
+		err = export(db, flag.Args()[1:])
+	case "dealers":
+		err = dealers(db, flag.Args()[1:])
+	case "report":
+		err = report(db, flag.Args()[1:])
+	case "review":
+		err = review(db, config, flag.Args()[1:])
+		if err == nil && config.Outbox && !config.DryRun {
+			dispatchAfter(ctx, dispatcher, db)
+		}
+	default:
+		err = fmt.Errorf("Unknown command %s", flag.Arg(0))
+	}
+	signal.Stop(signals)
+	cancel()
+
+	// Metrics go out whether or not that worked--a failed run is the one monitoring most wants to hear about.
+	// Not getting them out is worth failing over too, but not worth hiding why the run failed
+	if metricsTextfile != "" {
+		if metricsErr := config.Metrics.WriteTextfile(metricsTextfile); metricsErr != nil {
+			log.Print(metricsErr)
+			if err == nil {
+				err = metricsErr
+			}
+		}
+	}
+	if metricsPush != "" {
+		if metricsErr := config.Metrics.Push(metricsPush, "dealer_import"); metricsErr != nil {
+			log.Print(metricsErr)
+			if err == nil {
+				err = metricsErr
+			}
+		}
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 
@@ -54,27 +226,19 @@ func main() {
 	}
 }
 
-// DemoRecord would be the specific implementation for a record returned by LoadRecords
-// and passed to ProcessRecord
-// Its a set of specific CSV headers and CSV values--other imports could use other
-// Records--the nitty-gritty of the representation is only a problem for a specific
-// this could just as easily be a struct populated from JSON/XML or a map if that was
-// in any way more simple or efficient
-type DemoRecord struct {
-	Headings []string
-	Values   []string
-}
-
-// A greedy match here will xxtract the float portion of a string
-var floatRegex = regexp.MustCompile(`[\.\d]+`)
-
-// There's some goodness to extract about transmissions
-var transmissionRegex = regexp.MustCompile(`(\d)-Spe*d (Automatic|Manual)`)
+// httpClient is what DemoImporter aquires with--the default client will wait forever, which we won't.
+// A Config.AquireTimeout shorter than this wins, since it's applied to the request's context
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
 
 // DemoImporter is a concrete implementation of importer.Importer which knows to aquire data from
 // gist.githubusercontent.com, and that said data will be a csv, and the specifics of the csv encoding,
-// headers and how its values map into a dealer.Vehicle
-type DemoImporter struct{}
+// headers and how its values map into a dealer.Vehicle.  What it aquires can be gzipped, or a zip or tar.gz of
+// several files--they're unpacked on the way in
+type DemoImporter struct {
+	Unpacker importer.Unpacker
+	// XLSX is where the inventory is in a workbook, whether it's the whole feed or a file in an archived one
+	XLSX importer.XLSXOptions
+}
 
 // AquireRecords does an HTTP get to gist.githubusercontent.com and captures the passed filename as a local file.
 // This is nothing like the real world--but we'll pretend naievely the only complexity is it might be desirable to
@@ -82,11 +246,24 @@ type DemoImporter struct{}
 // not every lot is supposed to have their own file, addressed by a remote id; except dealer Bob, who for historical
 // reasons has 3 files describing 1 lot...
 func (i DemoImporter) AquireRecords(filename string) error {
+	return i.AquireRecordsContext(context.Background(), filename)
+}
+
+// AquireRecordsContext is AquireRecords, giving up on the HTTP get when ctx is done
+func (i DemoImporter) AquireRecordsContext(ctx context.Context, filename string) error {
 	uri := "https://gist.githubusercontent.com/mm53bar/26bd794c9245191f7407a5c7441c4969/raw/87df2a61b650a43001c875cb203df7929580ba90/" + filename
-	resp, err := http.Get(uri)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return fmt.Errorf("Creating request for %s: %w", uri, err)
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("Getting records at %s: %w", uri, err)
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Getting records at %s: %s", uri, resp.Status)
+	}
 
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -121,215 +298,127 @@ func (i DemoImporter) HasAquired(filename string) bool {
 	return true
 }
 
-// LoadRecords looks in the place AquireRecords dropped its file, opens it and uses the default
-// golang CSV parser to make sense of it.  The classes in the returned interface are of type DemoRecord
-func (i DemoImporter) LoadRecords(filename string) ([]interface{}, error) {
+// OpenAquired opens the file AquireRecords dropped, so it can be archived
+func (i DemoImporter) OpenAquired(filename string) (io.ReadCloser, error) {
+	return os.Open(workingFileName(filename))
+}
 
-	reader, err := os.Open(workingFileName(filename))
+// RestoreAquired puts back a file AquireRecords dropped at some point in the past, so it can be replayed
+func (i DemoImporter) RestoreAquired(filename string, reader io.Reader) error {
+	file, err := os.Create(workingFileName(filename))
 	if err != nil {
-		return nil, fmt.Errorf("Opening saved file for reading %s: %w", workingFileName(filename), err)
+		return fmt.Errorf("Creating file %s: %w", workingFileName(filename), err)
 	}
+	if _, err = io.Copy(file, reader); err != nil {
+		file.Close()
+		return fmt.Errorf("Writing file %s: %w", workingFileName(filename), err)
+	}
+	return file.Close()
+}
 
-	csvReader := csv.NewReader(reader)
-	// read the title line
-	headings, err := csvReader.Read()
-	if err != nil {
-		return nil, fmt.Errorf("Reading csv headings: %w", err)
+// RemoveAquired gets rid of a file RestoreAquired put back, once it's been replayed
+func (i DemoImporter) RemoveAquired(filename string) error {
+	if err := os.Remove(workingFileName(filename)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Removing file %s: %w", workingFileName(filename), err)
 	}
+	return nil
+}
 
-	values, err := csvReader.ReadAll()
-	if err != nil {
-		return nil, fmt.Errorf("Reading csv values: %w", err)
+// LoadRecordsContext is LoadRecords, unless ctx is already done--the file is local and
+// small enough that it isn't worth stopping halfway through
+func (i DemoImporter) LoadRecordsContext(ctx context.Context, filename string) ([]importer.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	return i.LoadRecords(filename)
+}
 
-	err = reader.Close()
+// demoFormat is the headings DemoImporter.ProcessRecord understands--a feed that doesn't say which dealer and
+// lot a vehicle is on, and which vehicle it is, isn't worth reading past the headings
+var demoFormat = importer.CSVFormat{Required: demoHeadings[:5], Optional: demoHeadings[5:]}
+
+// LoadRecords looks in the place AquireRecords dropped its file, opens it, unpacks it if it's compressed or archived
+// and lets importer.ReadCSV make sense of each file in it--whatever it's delimited with and however it's encoded.  The records it returns are importer.FieldRecords:
+// a set of specific CSV headers and CSV values--other imports could use other Records, a struct populated from
+// JSON/XML or whatever was in any way more simple or efficient
+func (i DemoImporter) LoadRecords(filename string) ([]importer.Record, error) {
+	reader, err := os.Open(workingFileName(filename))
 	if err != nil {
-		return nil, fmt.Errorf("Closing saved file for reading %s: %w", workingFileName(filename), err)
+		return nil, fmt.Errorf("Opening saved file for reading %s: %w", workingFileName(filename), err)
 	}
+	defer reader.Close()
 
-	// Turn into values for ProcessRecord
-	records := make([]interface{}, len(values))
-	for i, value := range values {
-		records[i] = DemoRecord{
-			Headings: headings,
-			Values:   value,
-		}
+	records, err := demoFormat.ReadFeed(filename, reader, i.Unpacker, i.XLSX)
+	if err != nil {
+		return nil, fmt.Errorf("Reading csv: %w", err)
 	}
-
 	return records, nil
 }
 
-// ProcessRecord takes a DemoRecord as returned by LoadRecords and
-// after casting it appropriately, iterates across all the headers in the record
+// ProcessRecord takes a record as returned by LoadRecords and
+// iterates across all the headers in the record
 // --mapping each to the corresponding value and determining what it goes on a
 // dealer.Vehcile via a switch statement.  I don't even know where to start with
 // real world complexities here, but our example data is naievely quite similar and a
 // simple switch with trivial error handling seemed best.
-func (i DemoImporter) ProcessRecord(record interface{}) (dealer.Vehicle, error) {
+func (i DemoImporter) ProcessRecord(record importer.Record) (dealer.Vehicle, error) {
 	// This version of match is build around redord looking like
 	// a string slice representing keys and a string slice representing values
 	// the keys will all be mapped to a function provided by Matcher which in turn
 	// will update the appropriate structs
 
-	// We could do a graceful typecast here, but I'd just as soon explode given the context
-	demoRecord := record.(DemoRecord)
-
 	// this is going to hold all the processed record values
 	vehicle := dealer.Vehicle{}
 
-	for i, heading := range demoRecord.Headings {
-		value := demoRecord.Values[i]
-
-		// also explode rather than gracefully checking the map--there's no elegant solution better than this
-		switch heading {
-		case "DealerID":
-			dealerID, err := strconv.ParseInt(value, 10, 32)
-			if err != nil {
-				return vehicle, fmt.Errorf("Parsing DealerID (%s): %w", value, err)
-			}
-			vehicle.DealerID = int(dealerID)
-
-		case "DealerName":
-			vehicle.DealerName = value
-
-		case "Type":
-			if value == "New" {
-				vehicle.LotType = dealer.TypeNew
-			} else {
-				vehicle.LotType = dealer.TypeUsed
-			}
-
-		case "Stock":
-			vehicle.Stock = value
-
-		case "VIN":
-			vehicle.VIN = value
-
-		case "Year":
-			year, err := strconv.ParseInt(value, 10, 32)
-			if err != nil {
-				return vehicle, fmt.Errorf("Parsing Year (%s): %w", value, err)
-			}
-			vehicle.Year = int(year)
-
-		case "Make":
-			vehicle.Make = value
-
-		case "Model":
-			vehicle.Model = value
-
-		case "Trim":
-			vehicle.Trim = value
-
-		case "Body":
-			vehicle.Body = value
-
-		case "Doors":
-			doors, err := strconv.ParseInt(value, 10, 32)
-			if err != nil {
-				return vehicle, fmt.Errorf("Parsing Doors (%s): %w", value, err)
-			}
-			vehicle.Doors = int(doors)
-
-		case "ExtColor":
-			vehicle.ExteriorColour = value
-
-		case "IntColor":
-			vehicle.InteriorColour = value
-
-		case "EngCylinders":
-			cylinders, err := strconv.ParseInt(value, 10, 32)
-			if err != nil {
-				return vehicle, fmt.Errorf("Parsing EngCylinders (%s): %w", value, err)
-			}
-			vehicle.Cylinders = int(cylinders)
-
-		case "EngDisplacement":
-			f := floatRegex.FindString(value)
-			displacement, err := strconv.ParseFloat(f, 64)
-			if err != nil {
-				return vehicle, fmt.Errorf("Parsing EngCylinders (%s): %w", value, err)
-			}
-			vehicle.Displacement = displacement
-
-		case "Transmission":
-			vehicle.TransmissionDesc = value
-			if value == "CVT" {
-				vehicle.TransmissionType = "CVT"
-				continue
-			}
-			// This regex shoud return 3 submatches if we're going to trust it
-			bits := transmissionRegex.FindStringSubmatch(value)
-			if len(bits) == 3 {
-				speeds, err := strconv.ParseInt(bits[1], 10, 32)
-				if err != nil {
-					return vehicle, fmt.Errorf("Extracting Transmission speeds (%s): %w", value, err)
-				}
-				vehicle.TransmissionSpeeds = int(speeds)
-				vehicle.TransmissionType = bits[2]
-			}
-
-		case "Odometer":
-			odometer, err := strconv.ParseInt(value, 10, 32)
-			if err != nil {
-				return vehicle, fmt.Errorf("Parsing Odometer (%s): %w", value, err)
-			}
-			vehicle.Cylinders = int(odometer)
-
-		case "Price":
-			f := floatRegex.FindString(value)
-			price, err := strconv.ParseFloat(f, 64)
-			if err != nil {
-				return vehicle, fmt.Errorf("Parsing Price (%s): %w", value, err)
-			}
-			vehicle.Price = price
-
-		case "MSRP":
-			f := floatRegex.FindString(value)
-			msrp, err := strconv.ParseFloat(f, 64)
-			if err != nil {
-				return vehicle, fmt.Errorf("Parsing MSRP (%s): %w", value, err)
-			}
-			vehicle.MSRP = msrp
-
-		case "Certified":
-			// Mark the heading as understood and No-op as vehcile has no correlating field
-
-		case "DateInStock":
-			// There's a solid case for this to be the vehicle.Created field; but that sounds like a discussion
-
-		case "Description":
-			// A special mention by any other name, will still drive you insane
-			vehicle.Description = value
-
-		case "EngType":
-			vehicle.Configuration = value
-
-		case "EngFuel":
-			vehicle.Fuel = value
+	for _, heading := range record.Fields() {
+		value, _ := record.Field(heading)
+		if err := setField(&vehicle, heading, value); err != nil {
+			return vehicle, importer.FieldError(record, heading, err)
+		}
+	}
 
-		case "Drivetrain":
-			vehicle.Drive = value
+	return vehicle, nil
+}
 
-		case "ExtColorGeneric":
-			vehicle.ExtColourGeneric = value
+// flagSet reports whether a flag was given on the command line, rather than left at its default
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
 
-		case "IntColorGeneric":
-			vehicle.IntColourGeneric = value
+// dispatchAfter delivers the outbox once what filled it has been committed.  Not getting it delivered doesn't undo
+// any of that, so it's no reason for the run to fail--it's logged, and left for `import dispatch`
+func dispatchAfter(ctx context.Context, dispatcher importer.Dispatcher, db *gorm.DB) {
+	delivered, err := dispatcher.DispatchContext(ctx, db)
+	if err != nil {
+		config.Logger.Error("Dispatch failed, leaving the rest of the outbox for import dispatch", err, "delivered", delivered)
+	}
+}
 
-		case "PassengerCount":
-			passengers, err := strconv.ParseInt(value, 10, 32)
-			if err != nil {
-				return vehicle, fmt.Errorf("Parsing PassengerCount (%s): %w", value, err)
-			}
-			vehicle.Passengers = int(passengers)
+// dispatch handles `import [flags] dispatch [-retry]`
+func dispatch(ctx context.Context, dispatcher importer.Dispatcher, db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("dispatch", flag.ExitOnError)
+	retry := flags.Bool("retry", false, "try again with messages that have used up their attempts, once whatever they failed on is fixed")
+	flags.Parse(args)
 
-		default:
-			return vehicle, fmt.Errorf("Processing unknown heading %s' in column %d", heading, i)
+	if len(dispatcher.Emitter.Sinks) == 0 {
+		return fmt.Errorf("Dispatching outbox: nowhere to deliver to--set -webhook or -event-log")
+	}
+	if *retry {
+		reset, err := dispatcher.Retry(db)
+		if err != nil {
+			return err
 		}
+		log.Printf("Retrying %d outbox messages", reset)
 	}
-
-	return vehicle, nil
+	delivered, err := dispatcher.DispatchContext(ctx, db)
+	log.Printf("Delivered %d outbox messages", delivered)
+	return err
 }
 
 // utility method used by DemoImporter so all methods have a consistent means of globally addressing
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/seamuncle/dealer/importer"
+)
+
+// replay handles `import [flags] replay [-list] [-dry-run] <ref>`
+// where ref is a digest prefix, an RFC3339 timestamp or "latest" as understood by importer.Archive.Find
+// It's what we reach for when a dealer swears a vehicle was in last Tuesday's feed
+func replay(ctx context.Context, runner importer.FullReplaceRunner, feed importer.Importer, db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	list := flags.Bool("list", false, "list the archived files available for replay instead of replaying one")
+	flags.BoolVar(&runner.Config.DryRun, "dry-run", runner.Config.DryRun, "replay the file but roll back every change it makes to the database")
+	flags.Parse(args)
+
+	if runner.Config.Archive == nil {
+		return fmt.Errorf("Replaying %s: no archive configured", runner.Config.Filename)
+	}
+	archive := *runner.Config.Archive
+
+	if *list {
+		entries, err := archive.Entries(runner.Config.Filename)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			fmt.Println(entry)
+		}
+		return nil
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("Replaying %s: expected exactly one archive reference, got %d", runner.Config.Filename, flags.NArg())
+	}
+	entry, err := archive.Find(runner.Config.Filename, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return runner.ReplayContext(ctx, feed, entry, db)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/seamuncle/dealer"
+)
+
+// report handles `import [flags] report aging [report flags]`, `import [flags] report claims` and
+// `import [flags] report quality [report flags]`
+func report(db *gorm.DB, args []string) error {
+	// The ORM debugging goes to stdout, which is also where the report goes
+	db.LogMode(false)
+
+	if len(args) == 0 {
+		return fmt.Errorf("Expected report aging, report claims or report quality")
+	}
+	switch args[0] {
+	case "aging":
+		return reportAging(db, args[1:])
+	case "claims":
+		return reportClaims(db)
+	case "quality":
+		return reportQuality(db, args[1:])
+	}
+	return fmt.Errorf("Unknown report %s", args[0])
+}
+
+// reportAging prints every vehicle that's been on its lot a while, oldest first, followed by
+// how many vehicles each lot has in each aging bucket
+func reportAging(db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("report aging", flag.ExitOnError)
+	dealerID := flags.Int("dealer", 0, "only report on this dealer id--0 reports on every dealer")
+	minDays := flags.Int("min-days", 60, "only list vehicles on their lot at least this many days")
+	asOfFlag := flags.String("as-of", "", "the day to age inventory to--empty is today")
+	flags.Parse(args)
+
+	asOf := time.Now()
+	if *asOfFlag != "" {
+		date, err := dealer.ParseDate(*asOfFlag)
+		if err != nil {
+			return err
+		}
+		asOf = date.Time()
+	}
+
+	// Everything is needed for the buckets; only the old stuff gets listed
+	aged, err := dealer.AgedInventory(db, *dealerID, asOf, 0)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "DAYS\tIN STOCK\tDEALER\tLOT\tSTOCK\tVIN\tYEAR\tMAKE\tMODEL\tPRICE")
+	for _, vehicle := range aged {
+		if vehicle.DaysOnLot < *minDays {
+			continue
+		}
+		inStock := vehicle.InStock.String()
+		if inStock == "" {
+			inStock = "~" + dealer.DateOf(vehicle.Created).String()
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\t%d\t%s\t%s\t%.2f\n", vehicle.DaysOnLot, inStock, vehicle.DealerID,
+			vehicle.LotType, vehicle.Stock, vehicle.VIN, vehicle.Year, vehicle.Make, vehicle.Model, vehicle.Price)
+	}
+	fmt.Fprintln(w)
+
+	type lotKey struct {
+		dealerID int
+		lotType  dealer.LotType
+	}
+	counts := map[lotKey][]int{}
+	keys := []lotKey{}
+	for _, vehicle := range aged {
+		key := lotKey{vehicle.DealerID, vehicle.LotType}
+		if _, ok := counts[key]; !ok {
+			counts[key] = make([]int, len(dealer.AgingBuckets))
+			keys = append(keys, key)
+		}
+		counts[key][dealer.AgingBucket(dealer.AgingBuckets, vehicle.DaysOnLot)]++
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].dealerID != keys[j].dealerID {
+			return keys[i].dealerID < keys[j].dealerID
+		}
+		return keys[i].lotType < keys[j].lotType
+	})
+
+	headings := []string{"DEALER", "LOT"}
+	for i, lowerBound := range dealer.AgingBuckets {
+		if i+1 < len(dealer.AgingBuckets) {
+			headings = append(headings, fmt.Sprintf("%d-%d", lowerBound, dealer.AgingBuckets[i+1]-1))
+		} else {
+			headings = append(headings, fmt.Sprintf("%d+", lowerBound))
+		}
+	}
+	fmt.Fprintln(w, strings.Join(headings, "\t"))
+	for _, key := range keys {
+		row := []string{fmt.Sprint(key.dealerID), string(key.lotType)}
+		for _, count := range counts[key] {
+			row = append(row, fmt.Sprint(count))
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// reportClaims prints every VIN more than one dealer has in inventory, and who has it--
+// a vehicle can only be on one lot, so somebody's feed is wrong
+func reportClaims(db *gorm.DB) error {
+	claims, err := dealer.MultiDealerClaims(db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VIN\tV_ID\tDEALER\tNAME\tLOT\tSTOCK\tLAST MODIFIED")
+	for _, claim := range claims {
+		for _, vehicle := range claim.Vehicles {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n", claim.VIN, vehicle.ID, vehicle.DealerID, vehicle.DealerName,
+				vehicle.LotType, vehicle.Stock, vehicle.LastModified.Format(time.RFC3339))
+		}
+	}
+	return w.Flush()
+}
+
+// reportQuality prints every lot's score from the last import run that replaced it, and how much that's moved
+// since the run before, then how many of its vehicles had each issue.  With -vehicles, it goes on to score every
+// vehicle in inventory as it is now and list the ones that fall short, worst first
+func reportQuality(db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("report quality", flag.ExitOnError)
+	dealerID := flags.Int("dealer", 0, "only report on this dealer id--0 reports on every dealer")
+	vehicles := flags.Bool("vehicles", false, "list every vehicle in inventory scoring under -below, as well as the lots")
+	below := flags.Int("below", 100, "with -vehicles, only list vehicles scoring less than this")
+	flags.Parse(args)
+
+	trends, err := dealer.LatestLotQuality(db, *dealerID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	headings := []string{"DEALER", "NAME", "LOT", "VEHICLES", "SCORE", "CHANGE"}
+	for _, issue := range dealer.QualityIssues {
+		headings = append(headings, strings.ToUpper(string(issue)))
+	}
+	fmt.Fprintln(w, strings.Join(append(headings, "SCORED"), "\t"))
+	for _, trend := range trends {
+		change := "-"
+		if trend.Previous != nil {
+			change = fmt.Sprintf("%+.1f", trend.Score-trend.Previous.Score)
+		}
+		row := []string{fmt.Sprint(trend.DealerID), trend.DealerName, string(trend.LotType), fmt.Sprint(trend.Vehicles),
+			fmt.Sprintf("%.1f", trend.Score), change}
+		for _, issue := range dealer.QualityIssues {
+			row = append(row, fmt.Sprint(trend.Count(issue)))
+		}
+		fmt.Fprintln(w, strings.Join(append(row, trend.Scored.Format(time.RFC3339)), "\t"))
+	}
+	if !*vehicles {
+		return w.Flush()
+	}
+
+	// Aging already knows how to find a dealer's inventory with its lots filled in
+	inventory, err := dealer.AgedInventory(db, *dealerID, time.Now(), 0)
+	if err != nil {
+		return err
+	}
+	type scored struct {
+		dealer.Vehicle
+		quality dealer.VehicleQuality
+	}
+	short := []scored{}
+	for _, vehicle := range inventory {
+		quality := dealer.ScoreVehicle(vehicle.FeedVehicle, vehicle.LotType, time.Now())
+		if quality.Score < *below {
+			short = append(short, scored{vehicle.Vehicle, quality})
+		}
+	}
+	sort.SliceStable(short, func(i, j int) bool {
+		if short[i].quality.Score != short[j].quality.Score {
+			return short[i].quality.Score < short[j].quality.Score
+		}
+		return short[i].ID < short[j].ID
+	})
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "SCORE\tDEALER\tLOT\tV_ID\tSTOCK\tVIN\tYEAR\tMAKE\tMODEL\tISSUES")
+	for _, vehicle := range short {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%s\t%s\t%d\t%s\t%s\t%s\n", vehicle.quality.Score, vehicle.DealerID, vehicle.LotType,
+			vehicle.ID, vehicle.Stock, vehicle.VIN, vehicle.Year, vehicle.Make, vehicle.Model, vehicle.quality)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/jinzhu/gorm"
+	"github.com/seamuncle/dealer"
+	"github.com/seamuncle/dealer/importer"
+)
+
+// review handles `import [flags] review list [-all]`, `import [flags] review approve [-by name] <id>...` and
+// `import [flags] review reject [-by name] <id>...`--deciding on whatever a run with -review parked
+func review(db *gorm.DB, config importer.Config, args []string) error {
+	// The ORM debugging goes to stdout, which is also where the listing goes
+	db.LogMode(false)
+
+	if len(args) == 0 {
+		return fmt.Errorf("Expected review list, review approve <id>... or review reject <id>...")
+	}
+	switch args[0] {
+	case "list":
+		return reviewList(db, args[1:])
+	case "approve", "reject":
+		return reviewDecide(db, config, args[0], args[1:])
+	}
+	return fmt.Errorf("Unknown review command %s", args[0])
+}
+
+// reviewList prints the changes waiting on a decision, oldest first--or every change ever parked, with -all
+func reviewList(db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("review list", flag.ExitOnError)
+	all := flags.Bool("all", false, "list changes that have been decided on too")
+	flags.Parse(args)
+
+	status := importer.StatusPending
+	if *all {
+		status = ""
+	}
+	changes, err := importer.PendingChanges(db, status)
+	if err != nil {
+		return err
+	}
+	lots, err := dealer.Lots(db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPARKED\tKIND\tDEALER\tLOT\tV_ID\tVIN\tSTOCK\tSTATUS\tREASON")
+	for _, change := range changes {
+		lot := lots[change.LotID]
+		vehicleID := "-"
+		if change.VehicleID != 0 {
+			vehicleID = strconv.Itoa(change.VehicleID)
+		}
+		status := change.Status
+		if change.DecidedBy != "" {
+			status = fmt.Sprintf("%s by %s", change.Status, change.DecidedBy)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			change.ID, change.Created.Format("2006-01-02 15:04"), change.Kind, lot.DealerID, lot.LotType,
+			vehicleID, change.VIN, change.Stock, status, change.Reason)
+	}
+	w.Flush()
+	fmt.Printf("%d changes\n", len(changes))
+	return nil
+}
+
+// reviewDecide approves or rejects each change in turn.  One that can't be decided on doesn't stop the rest--they're
+// separate decisions--but it does make the whole command fail
+func reviewDecide(db *gorm.DB, config importer.Config, decision string, args []string) error {
+	flags := flag.NewFlagSet("review "+decision, flag.ExitOnError)
+	by := flags.String("by", os.Getenv("USER"), "who's deciding, for the record--defaults to $USER")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("Expected review %s <id>...", decision)
+	}
+	// Every change would fail the same way, so there's no point trying them one at a time
+	if config.DryRun {
+		return fmt.Errorf("Deciding on pending changes with -dry-run: %w", importer.ErrDryRun)
+	}
+	reviewer := importer.Reviewer{Config: config, By: *by}
+
+	var failed error
+	for _, arg := range flags.Args() {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("Parsing pending change id (%s): %w", arg, err)
+		}
+		decided := importer.StatusApproved
+		if decision == "approve" {
+			err = reviewer.Approve(db, id)
+		} else {
+			decided = importer.StatusRejected
+			err = reviewer.Reject(db, id)
+		}
+		if err != nil {
+			fmt.Printf("%d\tnot %s: %v\n", id, decided, err)
+			if failed == nil {
+				failed = err
+			}
+			continue
+		}
+		fmt.Printf("%d\t%s\n", id, decided)
+	}
+	return failed
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/seamuncle/dealer/importer"
+)
+
+// XLSXImporter is DemoImporter for the dealers who email us a spreadsheet instead of a CSV.  It's aquired, archived
+// and processed the same--the headings are the same, they're just in cells--so all it does differently is load
+type XLSXImporter struct {
+	DemoImporter
+}
+
+// LoadRecordsContext is LoadRecords, unless ctx is already done
+func (i XLSXImporter) LoadRecordsContext(ctx context.Context, filename string) ([]importer.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return i.LoadRecords(filename)
+}
+
+// LoadRecords reads the inventory off whichever sheet XLSX says, with importer.ReadXLSX
+func (i XLSXImporter) LoadRecords(filename string) ([]importer.Record, error) {
+	reader, err := os.Open(workingFileName(filename))
+	if err != nil {
+		return nil, fmt.Errorf("Opening saved file for reading %s: %w", workingFileName(filename), err)
+	}
+	defer reader.Close()
+
+	records, err := demoFormat.ReadXLSX(filename, reader, i.XLSX)
+	if err != nil {
+		return nil, fmt.Errorf("Reading xlsx: %w", err)
+	}
+	return records, nil
+}
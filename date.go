@@ -0,0 +1,168 @@
+package dealer
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Date is a calendar day--what a feed means by "in stock since".  A time.Time drags a clock and a zone
+// along with it, and two of them for the same day don't always compare equal once they've been through
+// the database; a Date does, which keeps FeedVehicles comparable with ==
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// dateLayouts are the layouts feeds have been seen using, tried in order.  Slashed dates are handled
+// separately, since 03/04/2018 could be either of two days
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-1-2",
+	"2006/01/02",
+	"2006.01.02",
+	"20060102",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"Jan 2, 2006",
+	"Jan 2 2006",
+	"January 2, 2006",
+	"January 2 2006",
+	"2 Jan 2006",
+	"2 January 2006",
+	"02-Jan-2006",
+	"02-Jan-06",
+}
+
+// ParseDate makes a Date of whatever a feed put in a date column.  Blank is the zero Date, not an error.
+// Slashed dates are read month first, as North American feeds write them--unless the first number can't be
+// a month, in which case it's day first
+func ParseDate(value string) (Date, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Date{}, nil
+	}
+
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return DateOf(t), nil
+		}
+	}
+
+	if parts := strings.Split(value, "/"); len(parts) == 3 {
+		numbers := [3]int{}
+		for i, part := range parts {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return Date{}, fmt.Errorf("Parsing date %q: %w", value, err)
+			}
+			numbers[i] = n
+		}
+		month, day, year := numbers[0], numbers[1], numbers[2]
+		if month > 12 {
+			month, day = day, month
+		}
+		if year < 100 {
+			year += 2000
+		}
+		date := Date{Year: year, Month: time.Month(month), Day: day}
+		// time.Date happily normalizes February 30th into March; we don't
+		if month < 1 || month > 12 || day < 1 || DateOf(date.Time()) != date {
+			return Date{}, fmt.Errorf("Parsing date %q: no such day", value)
+		}
+		return date, nil
+	}
+
+	return Date{}, fmt.Errorf("Parsing date %q: unrecognized format", value)
+}
+
+// DateOf is the day t falls on, in t's own location
+func DateOf(t time.Time) Date {
+	year, month, day := t.Date()
+	return Date{Year: year, Month: month, Day: day}
+}
+
+// IsZero reports whether the date was never set
+func (date Date) IsZero() bool {
+	return date == Date{}
+}
+
+// Time is midnight UTC at the start of the day
+func (date Date) Time() time.Time {
+	return time.Date(date.Year, date.Month, date.Day, 0, 0, 0, 0, time.UTC)
+}
+
+// DaysUntil counts the days from date to the day asOf falls on--negative if asOf is earlier
+func (date Date) DaysUntil(asOf time.Time) int {
+	return int(DateOf(asOf).Time().Sub(date.Time()).Hours() / 24)
+}
+
+// String formats the date as 2006-01-02, or nothing at all for the zero Date
+func (date Date) String() string {
+	if date.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", date.Year, date.Month, date.Day)
+}
+
+// MarshalJSON writes the date as "2006-01-02", or null for the zero Date
+func (date Date) MarshalJSON() ([]byte, error) {
+	if date.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(date.String())
+}
+
+// UnmarshalJSON reads back what MarshalJSON wrote
+func (date *Date) UnmarshalJSON(b []byte) error {
+	var value *string
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	if value == nil {
+		*date = Date{}
+		return nil
+	}
+	parsed, err := ParseDate(*value)
+	if err != nil {
+		return err
+	}
+	*date = parsed
+	return nil
+}
+
+// Value stores the date as 2006-01-02 text, which sorts and compares properly in SQLite--or NULL for the zero Date
+func (date Date) Value() (driver.Value, error) {
+	if date.IsZero() {
+		return nil, nil
+	}
+	return date.String(), nil
+}
+
+// Scan reads a date back out of the database.  The SQLite driver hands columns declared as dates
+// back as a time.Time; anything else arrives as text
+func (date *Date) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case nil:
+		*date = Date{}
+	case time.Time:
+		*date = DateOf(value)
+	case string:
+		parsed, err := ParseDate(value)
+		if err != nil {
+			return err
+		}
+		*date = parsed
+	case []byte:
+		return date.Scan(string(value))
+	default:
+		return fmt.Errorf("Scanning date from %T", src)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package dealer
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Charge ports--the connector an electric vehicle plugs in with.  A vehicle with more than one has them comma separated
+const (
+	PortJ1772   = "J1772"
+	PortCCS1    = "CCS1"
+	PortCCS2    = "CCS2"
+	PortCHAdeMO = "CHAdeMO"
+	PortNACS    = "NACS"
+	PortType2   = "Type 2"
+	PortGBT     = "GB/T"
+)
+
+// chargePorts are the ways feeds name each port.  CCS on its own is CCS1, since that's the one on this side of the Atlantic
+var chargePorts = []struct {
+	port    string
+	pattern *regexp.Regexp
+}{
+	{PortCCS2, regexp.MustCompile(`ccs ?2|ccs combo ?2|combo 2`)},
+	{PortCCS1, regexp.MustCompile(`\bccs ?1\b|\bccs\b|ccs combo|combo 1|\bsae combo`)},
+	{PortCHAdeMO, regexp.MustCompile(`chademo`)},
+	{PortNACS, regexp.MustCompile(`\bnacs\b|tesla|north american charging`)},
+	{PortJ1772, regexp.MustCompile(`j ?1772|\bsae\b|type ?1\b|level ?2`)},
+	{PortType2, regexp.MustCompile(`type ?2\b|mennekes|iec 62196`)},
+	{PortGBT, regexp.MustCompile(`gb ?/ ?t|\bgbt\b`)},
+}
+
+// ccs1 is a feed naming CCS1 outright, rather than CCS1 matching the CCS in CCS2
+var ccs1 = regexp.MustCompile(`ccs ?1`)
+
+// ParseChargePort understands the usual names for charge ports, and a list of them--"J1772/CCS", "CHAdeMO + J1772",
+// "Tesla".  Every port it finds is in the result, sorted and comma separated, so the same ports always read the same
+func ParseChargePort(value string) (string, float64) {
+	text := strings.ToLower(strings.TrimSpace(value))
+	if text == "" {
+		return "", 0
+	}
+
+	found := map[string]bool{}
+	for _, candidate := range chargePorts {
+		if candidate.pattern.MatchString(text) {
+			found[candidate.port] = true
+		}
+	}
+	// CCS is J1772 with two more pins, and a feed listing CCS2 doesn't mean CCS1 too
+	if found[PortCCS2] && !ccs1.MatchString(text) {
+		delete(found, PortCCS1)
+	}
+	if len(found) == 0 {
+		return "", 0
+	}
+	ports := make([]string, 0, len(found))
+	for port := range found {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+	return strings.Join(ports, ","), 1
+}
+
+// motorWords are how feeds count motors when they don't use a number
+var motorWords = map[string]int{
+	"single": 1, "one": 1, "dual": 2, "twin": 2, "two": 2, "tri": 3, "triple": 3, "three": 3, "quad": 4, "four": 4,
+}
+
+var (
+	motorCount  = regexp.MustCompile(`^\d{1,2}$`)
+	motorNumber = regexp.MustCompile(`\b(\d{1,2})[ -]?motors?\b`)
+	motorWord   = regexp.MustCompile(`\b([a-z]+)[ -]?motors?\b`)
+)
+
+// ParseMotors understands how many motors a feed says an electric vehicle has: "2", "Dual Motor", "tri-motor"
+func ParseMotors(value string) (int, float64) {
+	text := strings.ToLower(strings.TrimSpace(value))
+	if motorCount.MatchString(text) {
+		motors, _ := strconv.Atoi(text)
+		return motors, 1
+	}
+	if match := motorNumber.FindStringSubmatch(text); match != nil {
+		motors, _ := strconv.Atoi(match[1])
+		return motors, 1
+	}
+	if match := motorWord.FindStringSubmatch(text); match != nil {
+		if motors, ok := motorWords[match[1]]; ok {
+			return motors, 1
+		}
+	}
+	if motors, ok := motorWords[text]; ok {
+		return motors, 0.8
+	}
+	return 0, 0
+}
+
+// HasEngine is whether a vehicle burns something--hybrids included, electric and fuel cell vehicles not.  A vehicle
+// without a fuel type we know is given the benefit of the doubt and reckoned not to, whatever it has
+func (vehicle FeedVehicle) HasEngine() bool {
+	switch vehicle.Fuel {
+	case FuelGasoline, FuelDiesel, FuelHybrid, FuelPHEV, FuelFlex:
+		return true
+	}
+	return false
+}
+
+// PlugsIn is whether a vehicle has a battery that gets charged from the wall
+func (vehicle FeedVehicle) PlugsIn() bool {
+	return vehicle.Fuel == FuelElectric || vehicle.Fuel == FuelPHEV
+}
+
+// CheckPowertrain describes anything missing or out of place for what makes the vehicle go: cylinders and
+// displacement for anything with an engine, battery and range for anything that plugs in, and neither for
+// what doesn't have one.  Like LotType.Check none of it's fatal, since a feed leaving a column empty is a feed
+// leaving a column empty--but a gasoline car with no cylinders is worth a look, where an electric one isn't
+func (vehicle FeedVehicle) CheckPowertrain() []error {
+	problems := []error{}
+	if vehicle.Fuel == "" {
+		problems = append(problems, errors.New("No fuel type"))
+	}
+
+	if vehicle.HasEngine() {
+		// A rotary engine doesn't have cylinders to count
+		if vehicle.Cylinders == 0 && vehicle.Configuration != EngineRotary {
+			problems = append(problems, fmt.Errorf("No cylinders, and fuel type is %s", vehicle.Fuel))
+		}
+		if vehicle.Displacement == 0 {
+			problems = append(problems, fmt.Errorf("No displacement, and fuel type is %s", vehicle.Fuel))
+		}
+	} else if vehicle.Fuel == FuelElectric || vehicle.Fuel == FuelHydrogen {
+		if vehicle.Cylinders > 0 || vehicle.Displacement > 0 {
+			problems = append(problems, fmt.Errorf("%d cylinders and %gL displacement, and fuel type is %s", vehicle.Cylinders, vehicle.Displacement, vehicle.Fuel))
+		}
+	}
+
+	if vehicle.PlugsIn() {
+		if vehicle.BatteryCapacity == 0 {
+			problems = append(problems, fmt.Errorf("No battery capacity, and fuel type is %s", vehicle.Fuel))
+		}
+		if vehicle.Range == 0 {
+			problems = append(problems, fmt.Errorf("No electric range, and fuel type is %s", vehicle.Fuel))
+		}
+		if vehicle.ChargePort == "" {
+			problems = append(problems, fmt.Errorf("No charge port, and fuel type is %s", vehicle.Fuel))
+		}
+	} else if vehicle.HasEngine() && vehicle.Fuel != FuelHybrid && (vehicle.BatteryCapacity > 0 || vehicle.ChargePort != "") {
+		problems = append(problems, fmt.Errorf("A battery or charge port, and fuel type is %s", vehicle.Fuel))
+	}
+	if vehicle.Fuel == FuelElectric && vehicle.Motors == 0 {
+		problems = append(problems, fmt.Errorf("No motor count, and fuel type is %s", vehicle.Fuel))
+	}
+	return problems
+}
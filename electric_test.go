@@ -0,0 +1,105 @@
+package dealer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseChargePort(t *testing.T) {
+	for _, test := range []struct {
+		value      string
+		port       string
+		confidence float64
+	}{
+		{"J1772", PortJ1772, 1},
+		{"Level 2", PortJ1772, 1},
+		{"Tesla", PortNACS, 1},
+		{"GB/T", PortGBT, 1},
+		{"Mennekes", PortType2, 1},
+		// CCS on its own is the one on this side of the Atlantic, and CCS2 isn't CCS1 as well
+		{"CCS", PortCCS1, 1},
+		{"CCS2", PortCCS2, 1},
+		{"CCS1 and CCS2", "CCS1,CCS2", 1},
+		// Any order, any separator, always sorted
+		{"J1772/CCS", "CCS1,J1772", 1},
+		{"CHAdeMO + J1772", "CHAdeMO,J1772", 1},
+		{"Type 2 / CCS Combo 2", "CCS2,Type 2", 1},
+		{"", "", 0},
+		{"Yes", "", 0},
+	} {
+		if port, confidence := ParseChargePort(test.value); port != test.port || confidence != test.confidence {
+			t.Errorf("ParseChargePort(%q) = %q, %g, want %q, %g", test.value, port, confidence, test.port, test.confidence)
+		}
+	}
+}
+
+func TestParseMotors(t *testing.T) {
+	for _, test := range []struct {
+		value      string
+		motors     int
+		confidence float64
+	}{
+		{"2", 2, 1},
+		{"Dual Motor", 2, 1},
+		{"tri-motor", 3, 1},
+		{"single-motor", 1, 1},
+		{"4 Motors", 4, 1},
+		// A word on its own could be counting something else
+		{"Dual", 2, 0.8},
+		{"AWD", 0, 0},
+		{"", 0, 0},
+	} {
+		if motors, confidence := ParseMotors(test.value); motors != test.motors || confidence != test.confidence {
+			t.Errorf("ParseMotors(%q) = %d, %g, want %d, %g", test.value, motors, confidence, test.motors, test.confidence)
+		}
+	}
+}
+
+func TestCheckPowertrain(t *testing.T) {
+	gasoline := FeedVehicle{Fuel: FuelGasoline, Cylinders: 4, Displacement: 2.0}
+	electric := FeedVehicle{Fuel: FuelElectric, BatteryCapacity: 75, Range: 500, ChargePort: PortNACS, Motors: 2}
+	phev := FeedVehicle{Fuel: FuelPHEV, Cylinders: 4, Displacement: 2.0, BatteryCapacity: 13.8, Range: 50, ChargePort: PortJ1772}
+	for _, test := range []struct {
+		name    string
+		vehicle FeedVehicle
+		change  func(*FeedVehicle)
+		want    []string
+	}{
+		{"gasoline", gasoline, func(*FeedVehicle) {}, nil},
+		{"electric", electric, func(*FeedVehicle) {}, nil},
+		{"plug-in hybrid", phev, func(*FeedVehicle) {}, nil},
+		{"hybrid with a battery", gasoline, func(vehicle *FeedVehicle) { vehicle.Fuel, vehicle.BatteryCapacity = FuelHybrid, 1.6 }, nil},
+		{"rotary", gasoline, func(vehicle *FeedVehicle) { vehicle.Cylinders, vehicle.Configuration = 0, EngineRotary }, nil},
+		{"no fuel type", FeedVehicle{}, func(*FeedVehicle) {}, []string{"No fuel type"}},
+		{"gasoline without an engine", gasoline, func(vehicle *FeedVehicle) { vehicle.Cylinders, vehicle.Displacement = 0, 0 }, []string{
+			"No cylinders, and fuel type is Gasoline",
+			"No displacement, and fuel type is Gasoline",
+		}},
+		{"gasoline with a charge port", gasoline, func(vehicle *FeedVehicle) { vehicle.ChargePort = PortJ1772 }, []string{
+			"A battery or charge port, and fuel type is Gasoline",
+		}},
+		{"electric with an engine", electric, func(vehicle *FeedVehicle) { vehicle.Cylinders, vehicle.Displacement = 4, 2.0 }, []string{
+			"4 cylinders and 2L displacement, and fuel type is Electric",
+		}},
+		{"electric and nothing else", FeedVehicle{Fuel: FuelElectric}, func(*FeedVehicle) {}, []string{
+			"No battery capacity, and fuel type is Electric",
+			"No electric range, and fuel type is Electric",
+			"No charge port, and fuel type is Electric",
+			"No motor count, and fuel type is Electric",
+		}},
+		// Fuel cell vehicles don't plug in, so they only get told off for having an engine
+		{"hydrogen", FeedVehicle{Fuel: FuelHydrogen, Motors: 1}, func(*FeedVehicle) {}, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			vehicle := test.vehicle
+			test.change(&vehicle)
+			var got []string
+			for _, problem := range vehicle.CheckPowertrain() {
+				got = append(got, problem.Error())
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Problems are %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package importer
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveTimeFormat is used to name the per-aquisition index files--it sorts lexically
+// in the same order it sorts chronologically, which saves us parsing anything to list them
+const archiveTimeFormat = "20060102T150405.000000000Z"
+
+// Archiver is implemented by Importers that can hand over the raw bytes they aquired
+// and take them back again.  A FullReplaceRunner with an Archive configured uses it to
+// keep a copy of every aquisition, and to put a historical one back in place for a replay
+type Archiver interface {
+	// OpenAquired opens the file AquireRecords dropped in the Importer's working directory
+	OpenAquired(filename string) (io.ReadCloser, error)
+	// RestoreAquired replaces the file in the Importer's working directory with the contents
+	// of reader, such that HasAquired reports true and LoadRecords reads the restored content
+	RestoreAquired(filename string, reader io.Reader) error
+	// RemoveAquired removes a file RestoreAquired put back, once a replay is done with it
+	RemoveAquired(filename string) error
+}
+
+// RetentionPolicy decides which archived aquisitions Prune gets rid of.
+// An entry is only removed if it is older than MaxAge AND there are at least KeepLast newer
+// entries for the same filename--so a feed that goes quiet for a month doesn't lose its history
+// A zero MaxAge keeps everything
+type RetentionPolicy struct {
+	MaxAge   time.Duration
+	KeepLast int
+}
+
+// Archive is a directory of every file ever aquired, gzipped and stored by the sha256 of its
+// uncompressed content, so aquiring the same file 30 days running costs us one copy of it.
+// Each aquisition is recorded as a timestamped index file naming the content it aquired:
+//
+//	<Dir>/objects/ab/abcdef...gz
+//	<Dir>/feeds/<filename>/20191205T180655.000000000Z
+type Archive struct {
+	Dir       string
+	Retention RetentionPolicy
+}
+
+// ArchiveEntry describes a single aquisition recorded in an Archive
+type ArchiveEntry struct {
+	Filename string
+	Aquired  time.Time
+	Digest   string
+}
+
+// shortDigest is how much of a digest it takes to tell aquisitions apart, the way git abbreviates commits
+const shortDigest = 12
+
+// String makes an ArchiveEntry look like something you'd copy and paste into a replay command
+func (entry ArchiveEntry) String() string {
+	digest := entry.Digest
+	if len(digest) > shortDigest {
+		digest = digest[:shortDigest]
+	}
+	return fmt.Sprintf("%s %s %s", entry.Aquired.Format(time.RFC3339), digest, entry.Filename)
+}
+
+// Store compresses the content of reader into the archive, if it isn't there already,
+// and records it as an aquisition of filename at the time given
+func (archive Archive) Store(filename string, aquired time.Time, reader io.Reader) (ArchiveEntry, error) {
+	entry := ArchiveEntry{Filename: filename, Aquired: aquired.UTC()}
+
+	objects := filepath.Join(archive.Dir, "objects")
+	if err := os.MkdirAll(objects, 0755); err != nil {
+		return entry, fmt.Errorf("Creating archive directory %s: %w", objects, err)
+	}
+
+	// We don't know the digest till we've read everything, so compress to a temp file
+	// and move it into place after
+	tmp, err := ioutil.TempFile(objects, "incoming-")
+	if err != nil {
+		return entry, fmt.Errorf("Creating archive temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	zipper := gzip.NewWriter(tmp)
+	if _, err = io.Copy(io.MultiWriter(hash, zipper), reader); err != nil {
+		tmp.Close()
+		return entry, fmt.Errorf("Compressing %s into archive: %w", filename, err)
+	}
+	if err = zipper.Close(); err != nil {
+		tmp.Close()
+		return entry, fmt.Errorf("Compressing %s into archive: %w", filename, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return entry, fmt.Errorf("Closing archive temp file: %w", err)
+	}
+
+	entry.Digest = hex.EncodeToString(hash.Sum(nil))
+	object := archive.objectPath(entry.Digest)
+	if _, err = os.Stat(object); os.IsNotExist(err) {
+		if err = os.MkdirAll(filepath.Dir(object), 0755); err != nil {
+			return entry, fmt.Errorf("Creating archive directory %s: %w", filepath.Dir(object), err)
+		}
+		if err = os.Rename(tmp.Name(), object); err != nil {
+			return entry, fmt.Errorf("Moving %s into archive: %w", filename, err)
+		}
+	}
+
+	feed := archive.feedPath(filename)
+	if err = os.MkdirAll(feed, 0755); err != nil {
+		return entry, fmt.Errorf("Creating archive directory %s: %w", feed, err)
+	}
+	index := filepath.Join(feed, entry.Aquired.Format(archiveTimeFormat))
+	if err = ioutil.WriteFile(index, []byte(entry.Digest+"\n"), 0644); err != nil {
+		return entry, fmt.Errorf("Recording aquisition of %s: %w", filename, err)
+	}
+
+	return entry, nil
+}
+
+// Entries lists every recorded aquisition of filename, oldest first
+func (archive Archive) Entries(filename string) ([]ArchiveEntry, error) {
+	feed := archive.feedPath(filename)
+	infos, err := ioutil.ReadDir(feed)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Listing archive %s: %w", feed, err)
+	}
+
+	entries := []ArchiveEntry{}
+	for _, info := range infos {
+		aquired, err := time.Parse(archiveTimeFormat, info.Name())
+		if err != nil || info.IsDir() {
+			// Not one of ours; leave it be
+			continue
+		}
+		digest, err := ioutil.ReadFile(filepath.Join(feed, info.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("Reading archive index %s: %w", info.Name(), err)
+		}
+		entry := ArchiveEntry{Filename: filename, Aquired: aquired, Digest: strings.TrimSpace(string(digest))}
+		if _, err := hex.DecodeString(entry.Digest); err != nil || len(entry.Digest) != sha256.Size*2 {
+			// Truncated or scribbled on--there's no telling what content it meant
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Aquired.Before(entries[j].Aquired) })
+	return entries, nil
+}
+
+// Find looks up a single aquisition of filename by ref, which is either a digest prefix
+// or an RFC3339 timestamp--in which case the last aquisition at or before it is the one we want.
+// "latest" is understood to mean what it says.
+func (archive Archive) Find(filename, ref string) (ArchiveEntry, error) {
+	// Every digest starts with nothing, so an empty ref would find whatever came last
+	if strings.TrimSpace(ref) == "" {
+		return ArchiveEntry{}, fmt.Errorf("Finding %s in archive: no reference given", filename)
+	}
+	entries, err := archive.Entries(filename)
+	if err != nil {
+		return ArchiveEntry{}, err
+	}
+	if len(entries) == 0 {
+		return ArchiveEntry{}, fmt.Errorf("Finding %s in archive: nothing archived", filename)
+	}
+
+	if ref == "latest" {
+		return entries[len(entries)-1], nil
+	}
+
+	if when, err := time.Parse(time.RFC3339, ref); err == nil {
+		for i := len(entries) - 1; i >= 0; i-- {
+			// RFC3339 has no fractions of a second, so neither should the comparison
+			if !entries[i].Aquired.Truncate(time.Second).After(when) {
+				return entries[i], nil
+			}
+		}
+		return ArchiveEntry{}, fmt.Errorf("Finding %s in archive: nothing aquired at or before %s", filename, ref)
+	}
+
+	// Same content may well have been aquired many times; the most recent aquisition is as good as any
+	for i := len(entries) - 1; i >= 0; i-- {
+		if strings.HasPrefix(entries[i].Digest, ref) {
+			return entries[i], nil
+		}
+	}
+	return ArchiveEntry{}, fmt.Errorf("Finding %s in archive: no aquisition matches %s", filename, ref)
+}
+
+// Open returns the uncompressed content of an archived aquisition--the caller should Close it
+func (archive Archive) Open(entry ArchiveEntry) (io.ReadCloser, error) {
+	file, err := os.Open(archive.objectPath(entry.Digest))
+	if err != nil {
+		return nil, fmt.Errorf("Opening archived %s: %w", entry, err)
+	}
+	unzipper, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Decompressing archived %s: %w", entry, err)
+	}
+	return archiveReader{Reader: unzipper, file: file}, nil
+}
+
+// Prune applies the RetentionPolicy to the aquisitions of filename as of now, then
+// removes any content no longer referred to by any aquisition of any feed.
+// It returns the entries it removed.
+func (archive Archive) Prune(filename string, now time.Time) ([]ArchiveEntry, error) {
+	policy := archive.Retention
+	if policy.MaxAge == 0 {
+		return nil, nil
+	}
+
+	entries, err := archive.Entries(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := []ArchiveEntry{}
+	cutoff := now.Add(-policy.MaxAge)
+	for i, entry := range entries {
+		newer := len(entries) - i - 1
+		if newer < policy.KeepLast || !entry.Aquired.Before(cutoff) {
+			// Entries are sorted oldest first, so nothing after this is going either
+			break
+		}
+		index := filepath.Join(archive.feedPath(filename), entry.Aquired.Format(archiveTimeFormat))
+		if err := os.Remove(index); err != nil {
+			return pruned, fmt.Errorf("Pruning %s: %w", entry, err)
+		}
+		pruned = append(pruned, entry)
+	}
+
+	if len(pruned) == 0 {
+		return pruned, nil
+	}
+	return pruned, archive.collectGarbage()
+}
+
+// collectGarbage removes content no index file refers to any longer
+func (archive Archive) collectGarbage() error {
+	referenced := map[string]bool{}
+	feeds, err := ioutil.ReadDir(filepath.Join(archive.Dir, "feeds"))
+	if err != nil {
+		return fmt.Errorf("Listing archived feeds: %w", err)
+	}
+	for _, feed := range feeds {
+		entries, err := archive.Entries(feed.Name())
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			referenced[entry.Digest] = true
+		}
+	}
+
+	objects := filepath.Join(archive.Dir, "objects")
+	return filepath.Walk(objects, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".gz") {
+			return err
+		}
+		if !referenced[strings.TrimSuffix(info.Name(), ".gz")] {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("Removing unreferenced archive content %s: %w", path, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (archive Archive) objectPath(digest string) string {
+	return filepath.Join(archive.Dir, "objects", digest[:2], digest+".gz")
+}
+
+func (archive Archive) feedPath(filename string) string {
+	return filepath.Join(archive.Dir, "feeds", filepath.Base(filename))
+}
+
+// archiveReader closes both the gzip reader and the file underneath it
+type archiveReader struct {
+	io.Reader
+	file *os.File
+}
+
+func (reader archiveReader) Close() error {
+	if closer, ok := reader.Reader.(io.Closer); ok {
+		closer.Close()
+	}
+	return reader.file.Close()
+}
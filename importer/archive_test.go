@@ -0,0 +1,184 @@
+package importer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testArchive is an Archive in a directory of its own, and a func to clean it up after
+func testArchive(t *testing.T, retention RetentionPolicy) (Archive, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "archive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Archive{Dir: dir, Retention: retention}, func() { os.RemoveAll(dir) }
+}
+
+// store archives content as an aquisition of filename, failing the test if it can't
+func store(t *testing.T, archive Archive, filename string, aquired time.Time, content string) ArchiveEntry {
+	t.Helper()
+	entry, err := archive.Store(filename, aquired, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+// contentOf is what an archived aquisition holds
+func contentOf(t *testing.T, archive Archive, entry ArchiveEntry) string {
+	t.Helper()
+	reader, err := archive.Open(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// objects counts the content files in the archive
+func objects(t *testing.T, archive Archive) int {
+	t.Helper()
+	count := 0
+	err := filepath.Walk(filepath.Join(archive.Dir, "objects"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestArchiveStore(t *testing.T) {
+	archive, cleanup := testArchive(t, RetentionPolicy{})
+	defer cleanup()
+	day := time.Date(2019, 12, 5, 18, 6, 55, 0, time.UTC)
+
+	first := store(t, archive, "dealer_import.csv", day, "DealerID,Stock\n1001,A124\n")
+	again := store(t, archive, "/tmp/dealer_import.csv", day.Add(24*time.Hour), "DealerID,Stock\n1001,A124\n")
+	changed := store(t, archive, "dealer_import.csv", day.Add(48*time.Hour), "DealerID,Stock\n1001,A130\n")
+
+	if first.Digest != again.Digest || first.Digest == changed.Digest {
+		t.Errorf("Digests are %s, %s and %s, want the first two the same", first.Digest, again.Digest, changed.Digest)
+	}
+	if count := objects(t, archive); count != 2 {
+		t.Errorf("Archive holds %d files, want the same content stored once", count)
+	}
+	if content := contentOf(t, archive, again); content != "DealerID,Stock\n1001,A124\n" {
+		t.Errorf("Archived content is %q", content)
+	}
+
+	entries, err := archive.Entries("dealer_import.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0] != first || entries[1].Aquired != again.Aquired || entries[2] != changed {
+		t.Errorf("Entries are %v, want every aquisition, oldest first", entries)
+	}
+
+	// An index file somebody scribbled on doesn't mean anything, so it isn't listed
+	index := filepath.Join(archive.feedPath("dealer_import.csv"), day.Add(72*time.Hour).Format(archiveTimeFormat))
+	if err = ioutil.WriteFile(index, []byte("abc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err = archive.Entries("dealer_import.csv"); err != nil || len(entries) != 3 {
+		t.Errorf("Entries are %v, %v, want the scribbled on one left out", entries, err)
+	}
+}
+
+func TestArchiveFind(t *testing.T) {
+	archive, cleanup := testArchive(t, RetentionPolicy{})
+	defer cleanup()
+	day := time.Date(2019, 12, 5, 18, 6, 55, 0, time.UTC)
+	monday := store(t, archive, "dealer_import.csv", day, "monday")
+	tuesday := store(t, archive, "dealer_import.csv", day.Add(24*time.Hour), "tuesday")
+	// The same content again, the way a feed nobody touched comes in
+	wednesday := store(t, archive, "dealer_import.csv", day.Add(48*time.Hour), "monday")
+
+	for _, test := range []struct {
+		name string
+		ref  string
+		want ArchiveEntry
+	}{
+		{"latest", "latest", wednesday},
+		{"whole digest", tuesday.Digest, tuesday},
+		{"short digest", tuesday.Digest[:shortDigest], tuesday},
+		{"digest aquired more than once", monday.Digest[:7], wednesday},
+		{"exact time", day.Add(24 * time.Hour).Format(time.RFC3339), tuesday},
+		{"time in between", day.Add(36 * time.Hour).Format(time.RFC3339), tuesday},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := archive.Find("dealer_import.csv", test.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("Found %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		name     string
+		filename string
+		ref      string
+	}{
+		{"nothing given", "dealer_import.csv", " "},
+		{"nothing archived", "other.csv", "latest"},
+		{"before the first aquisition", "dealer_import.csv", day.Add(-time.Hour).Format(time.RFC3339)},
+		{"no such digest", "dealer_import.csv", "0000000"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got, err := archive.Find(test.filename, test.ref); err == nil {
+				t.Errorf("Found %v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestArchivePrune(t *testing.T) {
+	archive, cleanup := testArchive(t, RetentionPolicy{MaxAge: 7 * 24 * time.Hour, KeepLast: 2})
+	defer cleanup()
+	day := time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC)
+	old := store(t, archive, "dealer_import.csv", day, "old")
+	shared := store(t, archive, "dealer_import.csv", day.Add(24*time.Hour), "shared")
+	store(t, archive, "dealer_import.csv", day.Add(2*24*time.Hour), "recent")
+	store(t, archive, "dealer_import.csv", day.Add(20*24*time.Hour), "newest")
+	// Another feed still refers to the content of one of the entries pruned
+	store(t, archive, "other.csv", day, "shared")
+
+	pruned, err := archive.Prune("dealer_import.csv", day.Add(21*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The third is older than MaxAge too, but it's one of the last two
+	if len(pruned) != 2 || pruned[0] != old || pruned[1] != shared {
+		t.Errorf("Pruned %v, want the first two", pruned)
+	}
+	if count := objects(t, archive); count != 3 {
+		t.Errorf("Archive holds %d files, want only the unreferenced one collected", count)
+	}
+	if _, err = archive.Open(old); err == nil {
+		t.Errorf("Opened %v after it was pruned", old)
+	}
+	if content := contentOf(t, archive, shared); content != "shared" {
+		t.Errorf("Content other.csv refers to is %q", content)
+	}
+
+	// No MaxAge keeps everything
+	archive.Retention = RetentionPolicy{}
+	if pruned, err = archive.Prune("other.csv", day.Add(365*24*time.Hour)); err != nil || len(pruned) != 0 {
+		t.Errorf("Pruned %v, %v, want nothing", pruned, err)
+	}
+}
@@ -0,0 +1,237 @@
+package importer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/seamuncle/dealer"
+)
+
+// DefaultBatchSize is how many rows an InventorySet writes per statement unless it's told otherwise
+const DefaultBatchSize = 500
+
+// maxVariables is the most bound parameters SQLite takes in one statement--older builds stop at 999,
+// and there's no telling which one we've been linked against
+const maxVariables = 999
+
+// rowsPerStatement is how many rows of width columns fit in one statement, given a batch size
+func rowsPerStatement(batchSize, width int) int {
+	rows := batchSize
+	if rows <= 0 {
+		rows = DefaultBatchSize
+	}
+	if width > 0 && rows*width > maxVariables {
+		rows = maxVariables / width
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	return rows
+}
+
+// columnsOf lists the columns gorm would store value in, and the values it would store there
+func columnsOf(db *gorm.DB, value interface{}) ([]string, []interface{}) {
+	columns := []string{}
+	values := []interface{}{}
+	for _, field := range db.NewScope(value).Fields() {
+		if !field.IsNormal || field.IsIgnored {
+			continue
+		}
+		columns = append(columns, field.DBName)
+		values = append(values, field.Field.Interface())
+	}
+	return columns, values
+}
+
+// insertRows writes rows into table as multi-row INSERTs of up to batchSize rows each
+func insertRows(db *gorm.DB, table string, columns []string, rows [][]interface{}, batchSize int) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = `"` + column + `"`
+	}
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",") + ")"
+	perStatement := rowsPerStatement(batchSize, len(columns))
+
+	for start := 0; start < len(rows); start += perStatement {
+		end := start + perStatement
+		if end > len(rows) {
+			end = len(rows)
+		}
+		values := make([]interface{}, 0, (end-start)*len(columns))
+		tuples := make([]string, 0, end-start)
+		for _, row := range rows[start:end] {
+			values = append(values, row...)
+			tuples = append(tuples, placeholders)
+		}
+		statement := fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES %s`, table, strings.Join(quoted, ","), strings.Join(tuples, ","))
+		if err := db.Exec(statement, values...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextVehicleID is the first v_id SQLite's autoincrement would hand out next.  Inserting in batches means
+// choosing IDs ourselves, so every vehicle still knows its own afterwards--done in the lot's transaction,
+// nobody else gets in between
+func nextVehicleID(db *gorm.DB) (int, error) {
+	var next struct{ ID int }
+	err := db.Raw(`SELECT MAX(id) AS id FROM (
+		SELECT COALESCE(MAX(v_id), 0) AS id FROM inventory
+		UNION ALL SELECT COALESCE(MAX(seq), 0) FROM sqlite_sequence WHERE name = 'inventory'
+	)`).Scan(&next).Error
+	if err != nil {
+		return 0, fmt.Errorf("Finding next vehicle id: %w", err)
+	}
+	return next.ID + 1, nil
+}
+
+// insertVehicles inserts vehicles in batches, filling in the ID each one was given
+func insertVehicles(db *gorm.DB, vehicles []dealer.Vehicle, batchSize int) error {
+	if len(vehicles) == 0 {
+		return nil
+	}
+	next, err := nextVehicleID(db)
+	if err != nil {
+		return err
+	}
+
+	var columns []string
+	rows := make([][]interface{}, len(vehicles))
+	for i := range vehicles {
+		vehicles[i].ID = next + i
+		columns, rows[i] = columnsOf(db, &vehicles[i])
+	}
+	if err = insertRows(db, dealer.Vehicle{}.TableName(), columns, rows, batchSize); err != nil {
+		return fmt.Errorf("Inserting %d vehicles: %w", len(vehicles), err)
+	}
+	return nil
+}
+
+// columnUpdate is a vehicle's row in inventory, and the columns of it that need writing
+type columnUpdate struct {
+	id      int
+	columns map[string]interface{}
+}
+
+// updateColumnsTable is where updateColumns puts each group of updates on the way into inventory
+const updateColumnsTable = "inventory_updates"
+
+// updateColumns writes just the columns each update names over its row in inventory.  Updates changing the same
+// columns are batched together: into a temporary table keyed on v_id, then over inventory in one UPDATE.
+// Every one of them is meant to have come out of inventory in the first place--one that didn't, or whose row has
+// gone since, is an error rather than a row written from scratch
+func updateColumns(db *gorm.DB, updates []columnUpdate, batchSize int) error {
+	// Grouped by which columns they change, in the order each group first turns up
+	groups := map[string][]columnUpdate{}
+	order := []string{}
+	for _, update := range updates {
+		if update.id == 0 {
+			return fmt.Errorf("Updating vehicle that was never inserted: %v", update.columns)
+		}
+		names := make([]string, 0, len(update.columns))
+		for column := range update.columns {
+			names = append(names, column)
+		}
+		sort.Strings(names)
+		signature := strings.Join(names, ",")
+		if _, ok := groups[signature]; !ok {
+			order = append(order, signature)
+		}
+		groups[signature] = append(groups[signature], update)
+	}
+
+	for _, signature := range order {
+		group := groups[signature]
+		// A temporary table is only there for the connection that made it, so it all has to happen in one transaction
+		err := inTransaction(db, func(tx *gorm.DB) error {
+			return updateGroup(tx, strings.Split(signature, ","), group, batchSize)
+		})
+		if err != nil {
+			return fmt.Errorf("Updating %s of %d vehicles: %w", signature, len(group), err)
+		}
+	}
+	return nil
+}
+
+// updateGroup writes the same columns of every update in group over inventory
+func updateGroup(db *gorm.DB, names []string, group []columnUpdate, batchSize int) error {
+	quoted := make([]string, len(names))
+	assignments := make([]string, len(names))
+	for i, column := range names {
+		quoted[i] = `"` + column + `"`
+		assignments[i] = fmt.Sprintf(`"%s" = (SELECT "%s" FROM "%s" AS u WHERE u."v_id" = "%s"."v_id")`,
+			column, column, updateColumnsTable, dealer.Vehicle{}.TableName())
+	}
+
+	rows := make([][]interface{}, len(group))
+	for i, update := range group {
+		rows[i] = append(make([]interface{}, 0, len(names)+1), update.id)
+		for _, column := range names {
+			rows[i] = append(rows[i], update.columns[column])
+		}
+	}
+
+	// Left untyped, the columns take whatever they're given, and inventory's own columns decide what it becomes
+	create := fmt.Sprintf(`CREATE TEMP TABLE "%s" ("v_id" integer PRIMARY KEY, %s)`, updateColumnsTable, strings.Join(quoted, ","))
+	if err := db.Exec(create).Error; err != nil {
+		return err
+	}
+	defer db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, updateColumnsTable))
+
+	if err := insertRows(db, updateColumnsTable, append([]string{"v_id"}, names...), rows, batchSize); err != nil {
+		return err
+	}
+	update := db.Exec(fmt.Sprintf(`UPDATE "%s" SET %s WHERE "v_id" IN (SELECT "v_id" FROM "%s")`,
+		dealer.Vehicle{}.TableName(), strings.Join(assignments, ", "), updateColumnsTable))
+	if update.Error != nil {
+		return update.Error
+	}
+	if update.RowsAffected != int64(len(group)) {
+		return fmt.Errorf("%d of them aren't in inventory", int64(len(group))-update.RowsAffected)
+	}
+	return nil
+}
+
+// deleteVehicles deletes vehicles by ID, as many at a time as SQLite will take
+func deleteVehicles(db *gorm.DB, vehicles []dealer.Vehicle, batchSize int) error {
+	perStatement := rowsPerStatement(batchSize, 1)
+	for start := 0; start < len(vehicles); start += perStatement {
+		end := start + perStatement
+		if end > len(vehicles) {
+			end = len(vehicles)
+		}
+		ids := make([]int, 0, end-start)
+		for _, vehicle := range vehicles[start:end] {
+			ids = append(ids, vehicle.ID)
+		}
+		if err := db.Where("v_id IN (?)", ids).Delete(&dealer.Vehicle{}).Error; err != nil {
+			return fmt.Errorf("Deleting %d vehicles: %w", len(ids), err)
+		}
+	}
+	return nil
+}
+
+// insertTransfers records transfers in batches
+func insertTransfers(db *gorm.DB, transfers []dealer.Transfer, batchSize int) error {
+	if len(transfers) == 0 {
+		return nil
+	}
+	var columns []string
+	rows := make([][]interface{}, len(transfers))
+	for i := range transfers {
+		var values []interface{}
+		columns, values = columnsOf(db, &transfers[i])
+		// transfer_id is left to SQLite
+		columns, rows[i] = columns[1:], values[1:]
+	}
+	if err := insertRows(db, dealer.Transfer{}.TableName(), columns, rows, batchSize); err != nil {
+		return fmt.Errorf("Recording %d transfers: %w", len(transfers), err)
+	}
+	return nil
+}
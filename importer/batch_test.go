@@ -0,0 +1,165 @@
+package importer_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/seamuncle/dealer"
+	"github.com/seamuncle/dealer/importer"
+	"github.com/seamuncle/dealer/importer/importertest"
+)
+
+// benchmarkVehicles is how many vehicles the benchmarks insert, update, delete and load
+const benchmarkVehicles = 2000
+
+// seedLot fills a lot with count vehicles, each with a VIN and stock number of its own, and returns its ID
+func seedLot(tb testing.TB, db *gorm.DB, lot dealer.Lot, count int) int {
+	tb.Helper()
+	var seeded []dealer.Vehicle
+	for i := 0; i < count; i++ {
+		vehicle := importertest.Vehicle(lot, fmt.Sprintf("S%d-%d", lot.DealerID, i), fmt.Sprintf("SBENCH%05d%06d", lot.DealerID, i))
+		seeded = importertest.Seed(tb, db, vehicle.Build())
+	}
+	if len(seeded) == 0 {
+		tb.Fatalf("Seeding lot %v: nothing to seed", lot)
+	}
+	return seeded[0].LotID
+}
+
+// BenchmarkFullReplace compares writing a lot's inserts, updates and deletes one vehicle at a time
+// with writing them in batches.  Each replacement is rolled back, so every iteration starts from the same lot
+func BenchmarkFullReplace(b *testing.B) {
+	db := importertest.OpenDB(b)
+	defer db.Close()
+	lot := importertest.Lot(90000, dealer.TypeUsed)
+	lotID := seedLot(b, db, lot, benchmarkVehicles)
+	lots, err := dealer.Lots(db)
+	if err != nil {
+		b.Fatal(err)
+	}
+	lot = lots[lotID]
+
+	for _, write := range []struct {
+		name    string
+		prepare func(set importer.InventorySet)
+	}{
+		{"insert", func(set importer.InventorySet) {
+			// Leave what's there alone, and add a lot's worth more
+			for _, vehicle := range set.Vehicles() {
+				vehicle.State = dealer.StateUnaltered
+				set.SetVehicle(vehicle)
+			}
+			for i := 0; i < benchmarkVehicles; i++ {
+				vehicle := importertest.Vehicle(lot, fmt.Sprintf("N%d", i), fmt.Sprintf("NBENCH%011d", i)).Build()
+				vehicle.LotID = lotID
+				set.SetVehicle(vehicle)
+			}
+		}},
+		{"update", func(set importer.InventorySet) {
+			for _, vehicle := range set.Vehicles() {
+				vehicle.Price++
+				vehicle.LastModified = time.Now()
+				vehicle.State = dealer.StateAltered
+				set.SetVehicle(vehicle)
+			}
+		}},
+		// Nobody listed any of them
+		{"delete", func(importer.InventorySet) {}},
+	} {
+		for _, batchSize := range []int{1, importer.DefaultBatchSize} {
+			b.Run(fmt.Sprintf("%s/batch=%d", write.name, batchSize), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					set := importer.NewInventorySet(lot, lotID, db).WithBatchSize(batchSize)
+					write.prepare(set)
+					tx := db.Begin()
+					b.StartTimer()
+
+					if _, err := set.FullReplace(tx); err != nil {
+						b.Fatal(err)
+					}
+
+					b.StopTimer()
+					tx.Rollback()
+					b.StartTimer()
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkLoadInventorySets compares loading lots one at a time with loading them all together
+func BenchmarkLoadInventorySets(b *testing.B) {
+	db := importertest.OpenDB(b)
+	defer db.Close()
+	const count = 200
+	for i := 0; i < count; i++ {
+		seedLot(b, db, importertest.Lot(90000+i, dealer.TypeUsed), benchmarkVehicles/count)
+	}
+	lots, err := dealer.Lots(db)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("one at a time", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for lotID, lot := range lots {
+				importer.NewInventorySet(lot, lotID, db)
+			}
+		}
+	})
+	b.Run("together", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := importer.LoadInventorySets(db, lots); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// TestFullReplaceOnlyUpdatesInventory makes sure an altered vehicle that isn't in inventory is an error,
+// rather than a row written from scratch with no lot and no creation time
+func TestFullReplaceOnlyUpdatesInventory(t *testing.T) {
+	db := importertest.OpenDB(t)
+	defer db.Close()
+	lot := importertest.Lot(1001, dealer.TypeNew)
+	seeded := importertest.Seed(t, db, importertest.Vehicle(lot, "A124", "1GCEP22T1G3329139").Build())
+	lots, err := dealer.Lots(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		id   int
+	}{
+		{"never inserted", 0},
+		{"gone since", seeded[0].ID + 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			set := importer.NewInventorySet(lots[seeded[0].LotID], seeded[0].LotID, db)
+			// The feed listed what's there as it is, so the only write is the update
+			unaltered := set.Vehicles()[0]
+			unaltered.State = dealer.StateUnaltered
+			set.SetVehicle(unaltered)
+			vehicle := importertest.Vehicle(lot, "B105", "KM8SB12B02U162029").Priced(100).Build()
+			vehicle.ID = test.id
+			vehicle.LotID = seeded[0].LotID
+			vehicle.State = dealer.StateAltered
+			set.SetVehicle(vehicle)
+
+			if _, err := set.FullReplace(db); err == nil {
+				t.Fatal("Replacing lot with an altered vehicle that isn't in inventory: expected an error")
+			}
+			var count int
+			if err := db.Model(&dealer.Vehicle{}).Count(&count).Error; err != nil {
+				t.Fatal(err)
+			}
+			if count != 1 {
+				t.Errorf("Inventory has %d vehicles, expected 1", count)
+			}
+		})
+	}
+}
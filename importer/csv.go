@@ -0,0 +1,300 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+)
+
+// CSVFormat is what a CSV feed's headings ought to be.  Headings are matched ignoring case and whatever space
+// is around them, and the records ReadCSV returns know their fields by the names here--so ProcessRecord only
+// ever has to deal with one spelling.  Headings that are in neither list are kept as the feed has them, trimmed
+type CSVFormat struct {
+	// Required are the headings a feed can't do without; a feed missing any is rejected before a row is read
+	Required []string
+	// Optional are the headings a feed may or may not have
+	Optional []string
+}
+
+// CSVDialect is what ReadCSV worked out about how a feed was written
+type CSVDialect struct {
+	Delimiter rune
+	// Encoding is UTF-8, ISO-8859-1 or Windows-1252
+	Encoding string
+	// BOM is whether the feed started with a UTF-8 byte order mark
+	BOM bool
+}
+
+// String describes a dialect for logs
+func (dialect CSVDialect) String() string {
+	delimiter := string(dialect.Delimiter)
+	if dialect.Delimiter == '\t' {
+		delimiter = `\t`
+	}
+	bom := ""
+	if dialect.BOM {
+		bom = " with BOM"
+	}
+	return fmt.Sprintf("%s%s, delimited by %q", dialect.Encoding, bom, delimiter)
+}
+
+// Encodings ReadCSV knows
+const (
+	UTF8        = "UTF-8"
+	Latin1      = "ISO-8859-1"
+	Windows1252 = "Windows-1252"
+)
+
+// csvDelimiters are the delimiters ReadCSV looks for, in the order ties go
+var csvDelimiters = []rune{',', '\t', '|', ';'}
+
+// RowErrors is every row of a feed that couldn't be read, reported all at once so they can all be fixed at once
+type RowErrors []*RecordError
+
+// Error lists them, a line each
+func (errs RowErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	return fmt.Sprintf("%d bad rows:\n%s", len(errs), strings.Join(lines, "\n"))
+}
+
+// ReadCSV reads a CSV feed called filename, working out its encoding and delimiter for itself, and checks its
+// headings against format.  Every row gets a FieldRecord that knows the line it starts on; rows with more or
+// fewer fields than there are headings are all reported together, as RowErrors, instead of any records
+func (format CSVFormat) ReadCSV(filename string, reader io.Reader) ([]Record, CSVDialect, error) {
+	raw, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, CSVDialect{}, fmt.Errorf("Reading %s: %w", filename, err)
+	}
+	text, dialect := decodeCSV(raw)
+	dialect.Delimiter = detectDelimiter(text)
+
+	rows, err := parseCSV(filename, text, dialect.Delimiter)
+	if err != nil {
+		return nil, dialect, err
+	}
+	if len(rows) == 0 {
+		return nil, dialect, fmt.Errorf("Reading %s: no headings", filename)
+	}
+
+	headings, err := format.headings(filename, rows[0])
+	if err != nil {
+		return nil, dialect, err
+	}
+
+	records := make([]Record, 0, len(rows)-1)
+	var ragged RowErrors
+	for i, row := range rows[1:] {
+		record := FieldRecord{
+			Number: i + 1,
+			Source: Location{File: filename, Line: row.line},
+			Names:  headings,
+			Values: row.fields,
+		}
+		if len(row.fields) != len(headings) {
+			ragged = append(ragged, &RecordError{
+				Location: record.Source,
+				Row:      record.Number,
+				Err:      fmt.Errorf("%d fields, and %d headings", len(row.fields), len(headings)),
+			})
+			continue
+		}
+		records = append(records, record)
+	}
+	if len(ragged) > 0 {
+		return nil, dialect, ragged
+	}
+	return records, dialect, nil
+}
+
+// headings matches the headings a feed has with the ones format knows, making sure none of the required ones are
+// missing and none of them turn up twice
+func (format CSVFormat) headings(filename string, row csvRow) ([]string, error) {
+	known := map[string]string{}
+	for _, heading := range append(append([]string{}, format.Required...), format.Optional...) {
+		known[strings.ToLower(heading)] = heading
+	}
+
+	headings := make([]string, len(row.fields))
+	seen := map[string]bool{}
+	for i, field := range row.fields {
+		heading := strings.TrimSpace(field)
+		if canonical, ok := known[strings.ToLower(heading)]; ok {
+			heading = canonical
+		}
+		if seen[strings.ToLower(heading)] {
+			return nil, &RecordError{Location: Location{File: filename, Line: row.line}, Field: heading, Column: i + 1, Err: fmt.Errorf("Heading %s turns up more than once", heading)}
+		}
+		seen[strings.ToLower(heading)] = true
+		headings[i] = heading
+	}
+
+	missing := []string{}
+	for _, heading := range format.Required {
+		if !seen[strings.ToLower(heading)] {
+			missing = append(missing, heading)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%s: missing required headings %s", Location{File: filename, Line: row.line}, strings.Join(missing, ", "))
+	}
+	return headings, nil
+}
+
+// decodeCSV turns a feed into text, whatever it was encoded as.  A feed that isn't valid UTF-8 is Windows-1252 if it uses
+// anything Windows-1252 puts where ISO-8859-1 has control characters, and ISO-8859-1 otherwise--which is also what
+// Windows-1252 looks like when it keeps to the characters the two of them share
+func decodeCSV(raw []byte) (string, CSVDialect) {
+	dialect := CSVDialect{Encoding: UTF8}
+	if bytes.HasPrefix(raw, []byte("\xef\xbb\xbf")) {
+		dialect.BOM = true
+		return string(raw[3:]), dialect
+	}
+	if utf8.Valid(raw) {
+		return string(raw), dialect
+	}
+
+	dialect.Encoding = Latin1
+	for _, b := range raw {
+		if b >= 0x80 && b <= 0x9f {
+			dialect.Encoding = Windows1252
+			break
+		}
+	}
+	var text strings.Builder
+	text.Grow(len(raw))
+	for _, b := range raw {
+		if dialect.Encoding == Windows1252 && b >= 0x80 && b <= 0x9f {
+			text.WriteRune(windows1252[b-0x80])
+			continue
+		}
+		// ISO-8859-1 is the first 256 code points of Unicode, byte for byte
+		text.WriteRune(rune(b))
+	}
+	return text.String(), dialect
+}
+
+// windows1252 is what Windows-1252 has from 0x80 to 0x9f, where ISO-8859-1 has control characters.
+// The five it leaves undefined are kept as the control characters
+var windows1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+// detectDelimiter picks whichever delimiter the heading line uses most, outside of quotes.  Headings are the one
+// line where nobody puts commas in a value, so it's the best place to look
+func detectDelimiter(text string) rune {
+	counts := map[rune]int{}
+	quoted := false
+	for _, r := range text {
+		if r == '"' {
+			quoted = !quoted
+			continue
+		}
+		if quoted {
+			continue
+		}
+		if r == '\n' || r == '\r' {
+			break
+		}
+		counts[r]++
+	}
+
+	best := csvDelimiters[0]
+	for _, delimiter := range csvDelimiters[1:] {
+		if counts[delimiter] > counts[best] {
+			best = delimiter
+		}
+	}
+	return best
+}
+
+// csvRow is a row of a CSV file, and the line it starts on
+type csvRow struct {
+	line   int
+	fields []string
+}
+
+// parseCSV splits text into rows and fields.  It's RFC 4180 give or take: a quoted field can have delimiters,
+// doubled quotes and line breaks in it, blank lines are skipped, and a stray quote in an unquoted field is just a
+// quote--which is more forgiving than encoding/csv, and unlike encoding/csv keeps track of which line each row
+// starts on.  A quote that's never closed is the only thing it won't put up with
+func parseCSV(filename string, text string, delimiter rune) ([]csvRow, error) {
+	rows := []csvRow{}
+	line := 1
+	row := csvRow{line: line}
+	var field strings.Builder
+	// fieldStarted is whether anything at all has been seen of the current row, to tell a blank line from
+	// a row with one empty field
+	fieldStarted := false
+	quoted := false
+	quoteLine := 0
+
+	endRow := func() {
+		if fieldStarted || len(row.fields) > 0 {
+			row.fields = append(row.fields, field.String())
+			rows = append(rows, row)
+		}
+		field.Reset()
+		fieldStarted = false
+		row = csvRow{line: line}
+	}
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if quoted {
+			switch {
+			case r == '"' && i+1 < len(runes) && runes[i+1] == '"':
+				field.WriteRune('"')
+				i++
+			case r == '"':
+				quoted = false
+			default:
+				if r == '\n' {
+					line++
+				}
+				field.WriteRune(r)
+			}
+			continue
+		}
+
+		switch r {
+		case delimiter:
+			row.fields = append(row.fields, field.String())
+			field.Reset()
+			fieldStarted = true
+		case '"':
+			if field.Len() == 0 {
+				quoted = true
+				quoteLine = line
+			} else {
+				field.WriteRune(r)
+			}
+			fieldStarted = true
+		case '\r':
+			// \r\n is one line break, and so is a \r on its own
+			if i+1 < len(runes) && runes[i+1] == '\n' {
+				i++
+			}
+			line++
+			endRow()
+		case '\n':
+			line++
+			endRow()
+		default:
+			field.WriteRune(r)
+			fieldStarted = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("%s: quote opened here is never closed", Location{File: filename, Line: quoteLine})
+	}
+	endRow()
+	return rows, nil
+}
@@ -0,0 +1,142 @@
+package importer
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testFormat is enough of a feed format to tell required headings from optional ones
+var testFormat = CSVFormat{Required: []string{"DealerID", "Stock", "VIN"}, Optional: []string{"Price"}}
+
+// fieldsOf is every record's fields, by heading
+func fieldsOf(t *testing.T, records []Record) []map[string]string {
+	t.Helper()
+	all := []map[string]string{}
+	for _, record := range records {
+		fields := map[string]string{}
+		for _, name := range record.Fields() {
+			value, ok := record.Field(name)
+			if !ok {
+				t.Fatalf("Record %d lists %s, but doesn't have it", record.Row(), name)
+			}
+			fields[name] = value
+		}
+		all = append(all, fields)
+	}
+	return all
+}
+
+func TestReadCSV(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		feed    string
+		dialect CSVDialect
+		want    []map[string]string
+	}{{
+		name:    "plain",
+		feed:    "DealerID,Stock,VIN,Price\n1001,A124,1GCEP22T1G3329139,31509\n",
+		dialect: CSVDialect{Delimiter: ',', Encoding: UTF8},
+		want:    []map[string]string{{"DealerID": "1001", "Stock": "A124", "VIN": "1GCEP22T1G3329139", "Price": "31509"}},
+	}, {
+		name:    "headings in any case, with a byte order mark",
+		feed:    "\ufeff dealerid ,STOCK,Vin\r\n1001,A124,1GCEP22T1G3329139\r\n",
+		dialect: CSVDialect{Delimiter: ',', Encoding: UTF8, BOM: true},
+		want:    []map[string]string{{"DealerID": "1001", "Stock": "A124", "VIN": "1GCEP22T1G3329139"}},
+	}, {
+		name:    "semicolons, and commas in the prices",
+		feed:    "DealerID;Stock;VIN;Price\n1001;A124;1GCEP22T1G3329139;\"$31,509\"\n1001;A130;KM8SB12B02U162029;20,999\n",
+		dialect: CSVDialect{Delimiter: ';', Encoding: UTF8},
+		want: []map[string]string{
+			{"DealerID": "1001", "Stock": "A124", "VIN": "1GCEP22T1G3329139", "Price": "$31,509"},
+			{"DealerID": "1001", "Stock": "A130", "VIN": "KM8SB12B02U162029", "Price": "20,999"},
+		},
+	}, {
+		name:    "tabs, and a heading nobody asked for",
+		feed:    "DealerID\tStock\tVIN\tColour\n1001\tA124\t1GCEP22T1G3329139\tMagnetic\n",
+		dialect: CSVDialect{Delimiter: '\t', Encoding: UTF8},
+		want:    []map[string]string{{"DealerID": "1001", "Stock": "A124", "VIN": "1GCEP22T1G3329139", "Colour": "Magnetic"}},
+	}, {
+		name:    "Latin-1",
+		feed:    "DealerID,Stock,VIN,Price\n1001,A124,1GCEP22T1G3329139,\xa331509\n",
+		dialect: CSVDialect{Delimiter: ',', Encoding: Latin1},
+		want:    []map[string]string{{"DealerID": "1001", "Stock": "A124", "VIN": "1GCEP22T1G3329139", "Price": "£31509"}},
+	}, {
+		name:    "Windows-1252",
+		feed:    "DealerID,Stock,VIN,Price\n1001,A124,1GCEP22T1G3329139,\x8031509\n",
+		dialect: CSVDialect{Delimiter: ',', Encoding: Windows1252},
+		want:    []map[string]string{{"DealerID": "1001", "Stock": "A124", "VIN": "1GCEP22T1G3329139", "Price": "€31509"}},
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			records, dialect, err := testFormat.ReadCSV("feed.csv", strings.NewReader(test.feed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dialect != test.dialect {
+				t.Errorf("Dialect is %v, want %v", dialect, test.dialect)
+			}
+			if got := fieldsOf(t, records); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Records are %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadCSVLocations(t *testing.T) {
+	feed := "DealerID,Stock,VIN\n1001,A124,\"1GCEP22T1G3329139\nstill the VIN\"\n1001,A130,KM8SB12B02U162029\n"
+	records, _, err := testFormat.ReadCSV("feed.csv", strings.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("%d records, want 2", len(records))
+	}
+	// The second record starts on the line after the first one's quoted newline
+	for i, want := range []Location{{File: "feed.csv", Line: 2}, {File: "feed.csv", Line: 4}} {
+		if got := records[i].Location(); got != want {
+			t.Errorf("Record %d is at %v, want %v", i+1, got, want)
+		}
+		if got := records[i].Row(); got != i+1 {
+			t.Errorf("Record %d is row %d", i+1, got)
+		}
+	}
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		feed string
+		// rows, when there are any, are the rows every one of which should be in RowErrors
+		rows []int
+	}{
+		{name: "empty", feed: ""},
+		{name: "missing a required heading", feed: "DealerID,Stock\n1001,A124\n"},
+		{name: "heading twice", feed: "DealerID,Stock,VIN,stock\n1001,A124,1GCEP22T1G3329139,A124\n"},
+		{name: "ragged rows", feed: "DealerID,Stock,VIN\n1001,A124\n1001,A130,KM8SB12B02U162029\n1001,A131,x,y\n", rows: []int{1, 3}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			records, _, err := testFormat.ReadCSV("feed.csv", strings.NewReader(test.feed))
+			if err == nil {
+				t.Fatalf("Read %d records, want an error", len(records))
+			}
+			if records != nil {
+				t.Errorf("Read %d records as well as failing", len(records))
+			}
+			if test.rows == nil {
+				return
+			}
+			var rowErrors RowErrors
+			if !errors.As(err, &rowErrors) {
+				t.Fatalf("Error is %v, want RowErrors", err)
+			}
+			rows := []int{}
+			for _, rowError := range rowErrors {
+				rows = append(rows, rowError.Row)
+			}
+			if !reflect.DeepEqual(rows, test.rows) {
+				t.Errorf("Rows %v are in error, want %v", rows, test.rows)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package importer
+
+import (
+	"fmt"
+
+	"github.com/seamuncle/dealer"
+)
+
+// DuplicatePolicy decides what happens when a feed lists the same vehicle more than once in a lot
+type DuplicatePolicy int
+
+const (
+	// LastWins lets each duplicate row overwrite the one before it--what always used to happen, silently
+	LastWins DuplicatePolicy = iota
+	// FirstWins keeps the first row and ignores the rest
+	FirstWins
+	// RejectBoth believes none of them--the vehicle is left as inventory had it
+	RejectBoth
+)
+
+// ParseDuplicatePolicy turns "last", "first" or "reject" into a DuplicatePolicy
+func ParseDuplicatePolicy(value string) (DuplicatePolicy, error) {
+	for _, policy := range []DuplicatePolicy{LastWins, FirstWins, RejectBoth} {
+		if policy.String() == value {
+			return policy, nil
+		}
+	}
+	return LastWins, fmt.Errorf("Parsing duplicate policy %q: expected last, first or reject", value)
+}
+
+// String is the name ParseDuplicatePolicy knows a policy by
+func (policy DuplicatePolicy) String() string {
+	switch policy {
+	case FirstWins:
+		return "first"
+	case RejectBoth:
+		return "reject"
+	}
+	return "last"
+}
+
+// Set makes a *DuplicatePolicy a flag.Value
+func (policy *DuplicatePolicy) Set(value string) error {
+	parsed, err := ParseDuplicatePolicy(value)
+	if err != nil {
+		return err
+	}
+	*policy = parsed
+	return nil
+}
+
+// Kinds of Duplicate
+const (
+	// DuplicateVIN is a row with the same VIN as an earlier row in the lot
+	DuplicateVIN = "vin"
+	// DuplicateStock is a row with the same stock number as an earlier row in the lot, and a different VIN or none
+	DuplicateStock = "stock"
+	// ConflictingKeys is a row whose VIN belongs to a vehicle with a different stock number, or the other way around
+	ConflictingKeys = "conflict"
+)
+
+// Duplicate is a row in a feed that's either a repeat of an earlier row, or disagrees with inventory about
+// which VIN goes with which stock number
+type Duplicate struct {
+	Kind string
+	Row  int
+	// FirstRow is the earlier row a repeat repeats--or -1 for a conflict
+	FirstRow int
+	Vehicle  dealer.FeedVehicle
+	// Existing is the persisted vehicle a conflicting row disagrees with
+	Existing *dealer.Vehicle `json:",omitempty"`
+}
+
+// lotRows remembers the rows of the lot a run is working its way through, by VIN and by stock number,
+// along with what each row replaced in the InventorySet--so RejectBoth can put it back
+type lotRows struct {
+	byVIN   map[string]*lotRow
+	byStock map[string]*lotRow
+}
+
+// lotRow is a single row that made it into an InventorySet
+type lotRow struct {
+	row      int
+	vehicle  dealer.Vehicle
+	previous *dealer.Vehicle
+	rejected bool
+}
+
+func newLotRows() lotRows {
+	return lotRows{byVIN: map[string]*lotRow{}, byStock: map[string]*lotRow{}}
+}
+
+// duplicateOf finds the earlier row a feed vehicle repeats, if there is one.  The VIN is the better
+// evidence, so it's checked first
+func (rows lotRows) duplicateOf(row int, vehicle dealer.FeedVehicle) (Duplicate, *lotRow, bool) {
+	if vin := dealer.NormalizeVIN(vehicle.VIN); vin != "" {
+		if first, ok := rows.byVIN[vin]; ok {
+			return Duplicate{Kind: DuplicateVIN, Row: row, FirstRow: first.row, Vehicle: vehicle}, first, true
+		}
+	}
+	if stock := normalizeStock(vehicle.Stock); stock != "" {
+		if first, ok := rows.byStock[stock]; ok {
+			return Duplicate{Kind: DuplicateStock, Row: row, FirstRow: first.row, Vehicle: vehicle}, first, true
+		}
+	}
+	return Duplicate{}, nil, false
+}
+
+// accept remembers a row that was put in the set, and what it replaced there--nil if it was new
+func (rows lotRows) accept(row int, vehicle dealer.Vehicle, previous *dealer.Vehicle) {
+	rows.index(&lotRow{row: row, vehicle: vehicle, previous: previous})
+}
+
+// replace puts a row in place of an earlier one whose new vehicle it took the place of in the set.  Whatever
+// finds the earlier row, by its keys or the new ones, finds this one
+func (rows lotRows) replace(first *lotRow, row int, vehicle dealer.Vehicle) {
+	first.row, first.vehicle = row, vehicle
+	rows.index(first)
+}
+
+// index files a row under its VIN and stock number
+func (rows lotRows) index(accepted *lotRow) {
+	if vin := dealer.NormalizeVIN(accepted.vehicle.VIN); vin != "" {
+		rows.byVIN[vin] = accepted
+	}
+	if stock := normalizeStock(accepted.vehicle.Stock); stock != "" {
+		rows.byStock[stock] = accepted
+	}
+}
+
+// added is whether the row added a vehicle that's not in inventory yet, and it's still in the set
+func (first *lotRow) added() bool {
+	return first.previous == nil && !first.rejected && first.vehicle.State == dealer.StateUnknown
+}
+
+// reject takes an accepted row back out of the set: a vehicle it added goes away, and one it
+// matched goes back to how inventory had it, held so it isn't deleted for want of a row
+func (rows lotRows) reject(set InventorySet, first *lotRow) {
+	if first.rejected {
+		return
+	}
+	first.rejected = true
+	if first.previous == nil {
+		set.ClearVehicle(first.vehicle)
+		return
+	}
+	previous := *first.previous
+	if previous.State == dealer.StatePersisted {
+		previous.State = dealer.StateHeld
+	}
+	set.SetVehicle(previous)
+}
+
+// conflictWith is the conflict between a feed vehicle and the persisted vehicle it matched, if their
+// VIN and stock numbers don't line up the same way
+func conflictWith(row int, vehicle dealer.FeedVehicle, match Match) (Duplicate, bool) {
+	existing := match.Vehicle
+	if existing.ID == 0 || vehicle.VIN == "" || vehicle.Stock == "" || existing.VIN == "" || existing.Stock == "" {
+		return Duplicate{}, false
+	}
+	sameVIN := dealer.NormalizeVIN(vehicle.VIN) == dealer.NormalizeVIN(existing.VIN)
+	sameStock := normalizeStock(vehicle.Stock) == normalizeStock(existing.Stock)
+	if sameVIN == sameStock {
+		return Duplicate{}, false
+	}
+	return Duplicate{Kind: ConflictingKeys, Row: row, FirstRow: -1, Vehicle: vehicle, Existing: &existing}, true
+}
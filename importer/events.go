@@ -0,0 +1,330 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/seamuncle/dealer"
+)
+
+// Event is anything the runner tells downstream systems about.  Each kind of event is its own type
+// so a consumer in Go can type-switch on it; anything outside Go sees the name in the Envelope
+type Event interface {
+	EventType() string
+}
+
+// VehicleAdded is emitted when a vehicle in a feed is inserted into inventory
+type VehicleAdded struct {
+	Vehicle dealer.Vehicle `json:"vehicle"`
+}
+
+// VehicleChanged is emitted when a vehicle in a feed differs from its inventory counterpart and is updated
+type VehicleChanged struct {
+	Vehicle dealer.Vehicle       `json:"vehicle"`
+	Changes []dealer.FieldChange `json:"changes"`
+}
+
+// VehicleRemoved is emitted when a vehicle in inventory is missing from its lot's feed and is deleted
+type VehicleRemoved struct {
+	Vehicle dealer.Vehicle `json:"vehicle"`
+}
+
+// VehicleTransferred is emitted when a vehicle in a feed turns up on a different lot than inventory had it on,
+// and is moved there--keeping its ID, along with any other changes the feed made to it
+type VehicleTransferred struct {
+	Vehicle dealer.Vehicle       `json:"vehicle"`
+	From    dealer.Lot           `json:"from"`
+	Changes []dealer.FieldChange `json:"changes"`
+}
+
+// LotReplaced is emitted once a lot has been fully replaced, after all of its vehicle events
+type LotReplaced struct {
+	Lot         dealer.Lot `json:"lot"`
+	Added       int        `json:"added"`
+	Changed     int        `json:"changed"`
+	Removed     int        `json:"removed"`
+	Transferred int        `json:"transferred"`
+	Unaltered   int        `json:"unaltered"`
+}
+
+// LotHeld is emitted instead of LotReplaced when a rule held a lot, and none of its vehicles were touched
+type LotHeld struct {
+	Lot        dealer.Lot  `json:"lot"`
+	Violations []Violation `json:"violations"`
+}
+
+// RunFinished is emitted when a FullReplaceRunner is done with a feed, successfully or not.  Warnings and Rejected
+// count the rows that broke a rule, by severity, and HeldLots the lots one held
+type RunFinished struct {
+	Filename    string        `json:"filename"`
+	Lots        int           `json:"lots"`
+	Added       int           `json:"added"`
+	Changed     int           `json:"changed"`
+	Removed     int           `json:"removed"`
+	Transferred int           `json:"transferred"`
+	Warnings    int           `json:"warnings"`
+	Rejected    int           `json:"rejected"`
+	HeldLots    int           `json:"held_lots"`
+	Duration    time.Duration `json:"duration"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// EventType names the event in an Envelope
+func (VehicleAdded) EventType() string { return "VehicleAdded" }
+
+// EventType names the event in an Envelope
+func (VehicleChanged) EventType() string { return "VehicleChanged" }
+
+// EventType names the event in an Envelope
+func (VehicleRemoved) EventType() string { return "VehicleRemoved" }
+
+// EventType names the event in an Envelope
+func (VehicleTransferred) EventType() string { return "VehicleTransferred" }
+
+// EventType names the event in an Envelope
+func (LotReplaced) EventType() string { return "LotReplaced" }
+
+// EventType names the event in an Envelope
+func (LotHeld) EventType() string { return "LotHeld" }
+
+// EventType names the event in an Envelope
+func (RunFinished) EventType() string { return "RunFinished" }
+
+// lotEvents turns what FullReplace did to a lot into the events describing it, vehicles first--or says it was held
+func lotEvents(changes LotChanges) []Event {
+	if changes.Held {
+		return []Event{LotHeld{Lot: changes.Lot, Violations: changes.Violations}}
+	}
+	events := []Event{}
+	for _, vehicle := range changes.Added {
+		events = append(events, VehicleAdded{Vehicle: vehicle})
+	}
+	for _, change := range changes.Changed {
+		events = append(events, VehicleChanged{Vehicle: change.Vehicle, Changes: change.Changes})
+	}
+	for _, vehicle := range changes.Removed {
+		events = append(events, VehicleRemoved{Vehicle: vehicle})
+	}
+	for _, transfer := range changes.Transferred {
+		events = append(events, VehicleTransferred{Vehicle: transfer.Vehicle, From: transfer.From, Changes: transfer.Changes})
+	}
+	return append(events, LotReplaced{
+		Lot:         changes.Lot,
+		Added:       len(changes.Added),
+		Changed:     len(changes.Changed),
+		Removed:     len(changes.Removed),
+		Transferred: len(changes.Transferred),
+		Unaltered:   changes.Unaltered,
+	})
+}
+
+// Envelope is an Event as it goes over the wire, with enough around it for a consumer
+// to know what it is, which run it came from, and whether it has seen it before
+type Envelope struct {
+	ID    string    `json:"id"`
+	Type  string    `json:"type"`
+	RunID string    `json:"run_id"`
+	Time  time.Time `json:"time"`
+	Event Event     `json:"data"`
+}
+
+// Sink is somewhere Envelopes get delivered to
+type Sink interface {
+	Deliver(envelope Envelope) error
+}
+
+// ContextSink is a Sink that might keep us waiting--on the network, or between retries--and can be told to stop
+type ContextSink interface {
+	Sink
+	// DeliverContext is Deliver, giving up when ctx is done
+	DeliverContext(ctx context.Context, envelope Envelope) error
+}
+
+// deliver hands envelope to sink, with ctx if it'll take one.  A Sink that doesn't is quick enough not to need it
+func deliver(ctx context.Context, sink Sink, envelope Envelope) error {
+	if contextSink, ok := sink.(ContextSink); ok {
+		return contextSink.DeliverContext(ctx, envelope)
+	}
+	return sink.Deliver(envelope)
+}
+
+// Emitter hands every event it's given to each of its sinks
+type Emitter struct {
+	Sinks []Sink
+	RunID string
+}
+
+// Emit wraps event in an Envelope and delivers it to every sink.  A sink failing doesn't stop
+// the others getting it; the first failure is what gets returned
+func (emitter Emitter) Emit(event Event) error {
+	return emitter.EmitContext(context.Background(), event)
+}
+
+// EmitContext is Emit, with sinks that can be giving up when ctx is done
+func (emitter Emitter) EmitContext(ctx context.Context, event Event) error {
+	envelope := emitter.wrap(event)
+
+	var first error
+	for _, sink := range emitter.Sinks {
+		if err := deliver(ctx, sink, envelope); err != nil && first == nil {
+			first = fmt.Errorf("Delivering %s %s: %w", envelope.Type, envelope.ID, err)
+		}
+	}
+	return first
+}
+
+// wrap puts an event in a fresh Envelope from this emitter
+func (emitter Emitter) wrap(event Event) Envelope {
+	return Envelope{
+		ID:    newID(),
+		Type:  event.EventType(),
+		RunID: emitter.RunID,
+		Time:  time.Now().UTC(),
+		Event: event,
+	}
+}
+
+// SinkFunc lets a plain function be a Sink--mostly so tests can see what got emitted
+type SinkFunc func(envelope Envelope) error
+
+// Deliver calls the function
+func (sink SinkFunc) Deliver(envelope Envelope) error {
+	return sink(envelope)
+}
+
+// NDJSONSink writes each envelope as a line of JSON--point it at a file and you have an event log
+type NDJSONSink struct {
+	Writer io.Writer
+	mu     sync.Mutex
+}
+
+// Deliver writes the envelope on a line of its own
+func (sink *NDJSONSink) Deliver(envelope Envelope) error {
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("Encoding event: %w", err)
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	_, err = sink.Writer.Write(append(b, '\n'))
+	return err
+}
+
+// WebhookSink POSTs each envelope as JSON to a URL.  If there's a Secret, the body is signed with
+// HMAC-SHA256 in the X-Dealer-Signature header so the receiver can tell it came from us.
+// Network errors, 429s and 5xxs are retried up to Retries times, doubling Backoff each time;
+// anything else is the receiver telling us no, and retrying won't change its mind
+type WebhookSink struct {
+	URL     string
+	Secret  string
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+}
+
+// Deliver POSTs the envelope, retrying as described on WebhookSink
+func (sink WebhookSink) Deliver(envelope Envelope) error {
+	return sink.DeliverContext(context.Background(), envelope)
+}
+
+// DeliverContext is Deliver, giving up on the request or the wait before the next one when ctx is done
+func (sink WebhookSink) DeliverContext(ctx context.Context, envelope Envelope) error {
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("Encoding event: %w", err)
+	}
+	return sink.PostContext(ctx, envelope.ID, envelope.Type, body)
+}
+
+// Post sends an already encoded envelope, which is all a redelivery has to go on
+func (sink WebhookSink) Post(id, eventType string, body []byte) error {
+	return sink.PostContext(context.Background(), id, eventType, body)
+}
+
+// PostContext is Post, giving up when ctx is done--a webhook that's down could otherwise keep
+// a SIGINT waiting through every retry
+func (sink WebhookSink) PostContext(ctx context.Context, id, eventType string, body []byte) error {
+	client := sink.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	backoff := sink.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := sink.post(ctx, client, id, eventType, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= sink.Retries {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("Posting webhook to %s: %w, after %v", sink.URL, ctx.Err(), err)
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// post makes a single delivery attempt, reporting whether a failure is worth retrying
+func (sink WebhookSink) post(ctx context.Context, client *http.Client, id, eventType string, body []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, fmt.Errorf("Posting webhook to %s: %w", sink.URL, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("Creating webhook request to %s: %w", sink.URL, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Dealer-Event", eventType)
+	req.Header.Set("X-Dealer-Delivery", id)
+	if sink.Secret != "" {
+		req.Header.Set("X-Dealer-Signature", Sign(sink.Secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Stopped on purpose isn't a network error, and isn't worth retrying
+		return ctx.Err() == nil, fmt.Errorf("Posting webhook to %s: %w", sink.URL, err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5
+	return retry, fmt.Errorf("Posting webhook to %s: %s", sink.URL, resp.Status)
+}
+
+// Sign is the value of the X-Dealer-Signature header for body--receivers compute the same
+// with their copy of the secret and compare
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newID makes up a random identifier for runs and events; uniqueness is all we need out of it
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// Running out of randomness is not something we're going to recover from gracefully
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,141 @@
+package importer_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seamuncle/dealer"
+	"github.com/seamuncle/dealer/importer"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	emitter := importer.Emitter{RunID: "run", Sinks: []importer.Sink{importer.WebhookSink{URL: server.URL, Secret: "s3cret"}}}
+	if err := emitter.Emit(importer.LotReplaced{Lot: dealer.Lot{DealerID: 1001, LotType: dealer.TypeNew}, Added: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// What a receiver would work out for itself with its copy of the secret
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	if want, signature := "sha256="+hex.EncodeToString(mac.Sum(nil)), got.Header.Get("X-Dealer-Signature"); signature != want {
+		t.Errorf("Signature is %s, want %s", signature, want)
+	}
+	var envelope importer.Envelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]string{
+		"Content-Type":      "application/json",
+		"X-Dealer-Event":    "LotReplaced",
+		"X-Dealer-Delivery": envelope.ID,
+	} {
+		if value := got.Header.Get(header); value != want {
+			t.Errorf("%s is %q, want %q", header, value, want)
+		}
+	}
+	if replaced, ok := envelope.Event.(importer.LotReplaced); !ok || replaced.Added != 2 || envelope.RunID != "run" {
+		t.Errorf("Envelope is %+v", envelope)
+	}
+
+	// No secret, no signature
+	got = nil
+	if err := (importer.WebhookSink{URL: server.URL}).Post("id", "LotReplaced", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if signature, ok := got.Header["X-Dealer-Signature"]; ok {
+		t.Errorf("Signed with %v and no secret", signature)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		statuses []int
+		attempts int
+		fails    bool
+	}{
+		{"delivered", []int{200}, 1, false},
+		{"accepted", []int{202}, 1, false},
+		{"down for a moment", []int{503, 200}, 2, false},
+		{"too many requests", []int{429, 429, 204}, 3, false},
+		{"down for good", []int{500, 502, 503, 504, 200}, 4, true},
+		// The receiver saying no isn't going to change its mind
+		{"bad request", []int{400, 200}, 1, true},
+		{"gone", []int{410, 200}, 1, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				body, _ := ioutil.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				w.WriteHeader(test.statuses[len(bodies)-1])
+			}))
+			defer server.Close()
+
+			sink := importer.WebhookSink{URL: server.URL, Retries: 3, Backoff: time.Millisecond}
+			err := sink.Post("id", "VehicleAdded", []byte(`{"id":"id"}`))
+			if (err != nil) != test.fails {
+				t.Errorf("Post gave %v, want failure %t", err, test.fails)
+			}
+			if len(bodies) != test.attempts {
+				t.Errorf("Posted %d times, want %d", len(bodies), test.attempts)
+			}
+			for _, body := range bodies {
+				if body != `{"id":"id"}` {
+					t.Errorf("Retried with %q", body)
+				}
+			}
+		})
+	}
+
+	// Nobody listening is worth another go, same as a 5xx
+	sink := importer.WebhookSink{URL: "http://127.0.0.1:1", Retries: 1, Backoff: time.Millisecond}
+	if err := sink.Post("id", "VehicleAdded", []byte("{}")); err == nil || !strings.Contains(err.Error(), "127.0.0.1:1") {
+		t.Errorf("Post to nobody gave %v", err)
+	}
+}
+
+func TestNDJSONSink(t *testing.T) {
+	var log bytes.Buffer
+	emitter := importer.Emitter{RunID: "run", Sinks: []importer.Sink{&importer.NDJSONSink{Writer: &log}}}
+	for _, event := range testEvents {
+		if err := emitter.Emit(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(log.String(), "\n"), "\n")
+	if len(lines) != len(testEvents) {
+		t.Fatalf("Logged %d lines, want %d", len(lines), len(testEvents))
+	}
+	for i, line := range lines {
+		var envelope importer.Envelope
+		if err := json.Unmarshal([]byte(line), &envelope); err != nil {
+			t.Fatal(err)
+		}
+		if envelope.Event != testEvents[i] {
+			t.Errorf("Line %d is %+v, want %+v", i+1, envelope.Event, testEvents[i])
+		}
+	}
+}
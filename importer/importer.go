@@ -1,7 +1,10 @@
 package importer
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -22,24 +25,74 @@ type Importer interface {
 	// before further processing occurs
 	HasAquired(filename string) bool
 	// LoadRecords takes aquired records from the filename specifid and returns them as an
-	// array of *something* that the same implementation's ProcessRecord understands.
+	// array of Records that the same implementation's ProcessRecord understands.
 	// Note that its feasible AquireRecords was not run by the same process but may have been
 	// called by another process with saveAquisition set to true
-	LoadRecords(filename string) ([]interface{}, error)
-	// ProcessRecord takes a sungle element from the array of *something* generated by LoadRecords
-	// and turns it into a dealer.Vehicle for further processing by the FullReplaceRunner
-	ProcessRecord(record interface{}) (dealer.Vehicle, error)
+	LoadRecords(filename string) ([]Record, error)
+	// ProcessRecord takes a sungle element from the array of Records generated by LoadRecords
+	// and turns it into a dealer.Vehicle for further processing by the FullReplaceRunner.
+	// Errors about a particular field are best wrapped with FieldError, so they say where it is
+	ProcessRecord(record Record) (dealer.Vehicle, error)
+}
+
+// ContextImporter is an Importer that can be cancelled, or held to a deadline, while it's off
+// aquiring or loading records--the parts that go over a network or chew through big files.
+// ProcessRecord is only ever handed a record in memory so it doesn't get a context;
+// a FullReplaceRunner checks for cancellation between records instead
+type ContextImporter interface {
+	Importer
+	// AquireRecordsContext is AquireRecords, giving up when ctx is done
+	AquireRecordsContext(ctx context.Context, filename string) error
+	// LoadRecordsContext is LoadRecords, giving up when ctx is done
+	LoadRecordsContext(ctx context.Context, filename string) ([]Record, error)
 }
 
 // Config sets default behaviors when calling an Importor or FullReplaceRunner
 type Config struct {
 	DoProcessing bool
 	Filename     string
+	// DryRun does all the work of an import inside a transaction that is rolled back at the end
+	DryRun bool
+	// Archive, when set, keeps a copy of everything aquired by an Importer implementing Archiver
+	Archive *Archive
+	// Emitter, when set, is told about every change a run makes to inventory--except on a DryRun
+	Emitter *Emitter
+	// Outbox writes events to the inventory_outbox table in the same transaction as the changes they
+	// describe, instead of handing them straight to the Emitter.  A Dispatcher delivers them from there
+	Outbox bool
+	// Logger gets structured logs of everything a run does--the zero Logger keeps quiet
+	Logger Logger
+	// Metrics, when set, counts everything a run does
+	Metrics *Metrics
+	// AquireTimeout is how long AquireRecords gets before it's cancelled--0 waits as long as it takes
+	AquireTimeout time.Duration
+	// Matcher, when set, replaces DefaultMatcher for deciding which vehicle a feed vehicle is
+	Matcher *Matcher
+	// DuplicatePolicy decides which of the rows wins when a feed lists a vehicle twice in the same lot
+	DuplicatePolicy DuplicatePolicy
+	// BatchSize is how many vehicles go in each insert, update or delete statement--0 is DefaultBatchSize
+	BatchSize int
+	// Rules are checked against every vehicle that makes it past matching; none means no rules at all.
+	// DefaultRules are a place to start
+	Rules []Rule
+	// HoldAnomalies leaves a vehicle as inventory has it when the feed changes it in a way that says it's probably
+	// not the same vehicle--see dealer.FeedVehicle.Anomalies.  Either way, it's logged
+	HoldAnomalies bool
+	// Review parks whatever HoldAnomalies holds, a rule rejects, a rule holds the lot of or MaxDeletePercent keeps in
+	// pending_changes, for somebody to approve or reject later--see Reviewer.  Without it, they're only ever logged
+	Review bool
+	// MaxDeletePercent is the most of a lot's vehicles a feed can delete in one go, as a percentage of what inventory
+	// has on it.  A feed leaving out more than that is more likely cut short than a lot that sold out, so none of its
+	// deletes are done--the rest of the lot is replaced as usual.  0 lets a feed delete as many as it likes
+	MaxDeletePercent float64
 }
 
 // FullReplaceRunner applies the logic of a rull-replacement import, given a specific Importer implementation
 type FullReplaceRunner struct {
 	Config Config
+	// onReplace, when set, runs in the transaction that replaces each lot, so whatever it writes commits or rolls back
+	// right along with the lot.  It's how Reviewer marks a lot approved
+	onReplace func(tx *gorm.DB, changes LotChanges) error
 }
 
 // Run is our heavy-lifter.  Take some cues from "big data" and apply some functional programming
@@ -47,49 +100,353 @@ type FullReplaceRunner struct {
 // cache-consistency that result from a big-data approach.  It does leave our Importer quite testable
 // and if I had time to generate mocks and tests; this should be relatively testable as well.
 func (runner FullReplaceRunner) Run(importer Importer, db *gorm.DB) error {
+	return runner.RunContext(context.Background(), importer, db)
+}
 
+// RunContext is Run, stopping when ctx is done.  Each lot is replaced in a transaction of its own,
+// and cancellation is only noticed between lots, so a cancelled run leaves every lot either
+// fully replaced or untouched--never halfway.  Aquisition is cancelled outright, since it hasn't touched anything yet
+func (runner FullReplaceRunner) RunContext(ctx context.Context, importer Importer, db *gorm.DB) error {
 	filename := runner.Config.Filename
+	metrics := runner.Config.Metrics
+
+	emitter := Emitter{}
+	if runner.Config.Emitter != nil && !runner.Config.DryRun {
+		emitter = *runner.Config.Emitter
+	}
+	emitter.RunID = newID()
+
+	run := runState{
+		FullReplaceRunner: runner,
+		log:               runner.Config.Logger.With("run_id", emitter.RunID, "feed", filename),
+		emitter:           emitter,
+		phases:            map[string]time.Duration{},
+	}
+	run.log.Info("Run started", "dry_run", runner.Config.DryRun)
+
+	err := run.run(ctx, importer, db)
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+		run.log.Error("Run failed", err, "duration", time.Since(run.start).Seconds())
+	} else {
+		run.log.Info("Run finished", "duration", time.Since(run.start).Seconds())
+	}
+	for phase, duration := range run.phases {
+		metrics.Set("dealer_import_phase_duration_seconds", duration.Seconds(), "feed", filename, "phase", phase)
+	}
+	metrics.Add("dealer_import_runs_total", 1, "feed", filename, "result", result)
+	metrics.Set("dealer_import_last_run_success", boolGauge(err == nil), "feed", filename)
+	metrics.Set("dealer_import_last_run_duration_seconds", time.Since(run.start).Seconds(), "feed", filename)
+	metrics.Set("dealer_import_last_run_timestamp_seconds", float64(time.Now().Unix()), "feed", filename)
+	return err
+}
+
+// runState is everything a single Run carries around with it
+type runState struct {
+	FullReplaceRunner
+	log     Logger
+	emitter Emitter
+	start   time.Time
+	phases  map[string]time.Duration
+	claims  feedClaims
+	// preloaded are the inventory sets of every lot in the feed, loaded in one go before the first is replaced.
+	// Each is handed out once; a lot the feed comes back to later gets loaded again, as it is by then
+	preloaded map[lotKey]InventorySet
+}
+
+// timed adds however long f takes to the named phase
+func (run runState) timed(phase string, f func() error) error {
+	start := time.Now()
+	err := f()
+	run.phases[phase] += time.Since(start)
+	return err
+}
+
+func (run *runState) run(ctx context.Context, importer Importer, db *gorm.DB) error {
+	run.start = time.Now()
+	filename := run.Config.Filename
+	logged := loggedImporter{importer, run.log}
+
 	if !importer.HasAquired(filename) {
-		importer.AquireRecords(filename)
+		err := run.timed("aquire", func() error {
+			aquireCtx := ctx
+			if run.Config.AquireTimeout > 0 {
+				var cancel context.CancelFunc
+				aquireCtx, cancel = context.WithTimeout(ctx, run.Config.AquireTimeout)
+				defer cancel()
+			}
+			if err := logged.AquireRecordsContext(aquireCtx, filename); err != nil {
+				return fmt.Errorf("Aquiring records: %w", err)
+			}
+			if err := run.archive(importer, filename); err != nil {
+				return fmt.Errorf("Archiving records: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	if !runner.Config.DoProcessing {
+	if !run.Config.DoProcessing {
 		return nil
 	}
 
-	records, err := importer.LoadRecords(filename)
+	if run.Config.DryRun {
+		// Everything past here happens for real, right up until we throw it all away
+		db = db.Begin()
+		defer db.Rollback()
+	}
+
+	// Downstream gets told how it went either way
+	finished := RunFinished{Filename: filename}
+	var emitErr error
+
+	err := run.replaceLots(ctx, logged, db, func(changes LotChanges) {
+		finished.Lots++
+		finished.Added += len(changes.Added)
+		finished.Changed += len(changes.Changed)
+		finished.Removed += len(changes.Removed)
+		finished.Transferred += len(changes.Transferred)
+		if changes.Held {
+			finished.HeldLots++
+		}
+		for _, violation := range changes.Violations {
+			switch violation.Severity {
+			case Warn:
+				finished.Warnings++
+			case RejectRow:
+				finished.Rejected++
+			}
+		}
+		if !run.Config.Outbox {
+			for _, event := range lotEvents(changes) {
+				if err := run.emitter.EmitContext(ctx, event); err != nil && emitErr == nil {
+					emitErr = err
+				}
+			}
+		}
+	})
+
+	finished.Duration = time.Since(run.start)
+	if err != nil {
+		finished.Error = err.Error()
+	}
+	if run.Config.Outbox {
+		if err := WriteOutbox(db, run.emitter, []Event{finished}); err != nil && emitErr == nil {
+			emitErr = err
+		}
+	} else if err := run.emitter.EmitContext(ctx, finished); err != nil && emitErr == nil {
+		emitErr = err
+	}
+
 	if err != nil {
-		return fmt.Errorf("Loading records: %w", err)
+		return err
+	}
+	if emitErr != nil {
+		return fmt.Errorf("Emitting events: %w", emitErr)
 	}
+	return nil
+}
 
-	var set InventorySet
+// replaceLots loads and processes every record, and full-replaces each lot as the feed moves past it
+// calling replaced with whatever each replacement did once it's been committed
+func (run *runState) replaceLots(ctx context.Context, importer ContextImporter, db *gorm.DB, replaced func(LotChanges)) error {
+	filename := run.Config.Filename
+	metrics := run.Config.Metrics
 
+	var records []Record
+	err := run.timed("load", func() (err error) {
+		records, err = importer.LoadRecordsContext(ctx, filename)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Loading records: %w", err)
+	}
+	metrics.Add("dealer_import_records_read_total", float64(len(records)), "feed", filename)
+
+	// Every record is processed before any lot is replaced, so each lot knows which of its
+	// vehicles the feed has put on some other lot
+	vehicles := make([]dealer.Vehicle, 0, len(records))
 	for i, record := range records {
-		vehicle, err := importer.ProcessRecord(record)
+		if err := ctx.Err(); err != nil {
+			run.log.Warn("Run cancelled", "row", i)
+			return fmt.Errorf("Cancelled at record %d: %w", i, err)
+		}
+
+		var vehicle dealer.Vehicle
+		err := run.timed("process", func() (err error) {
+			vehicle, err = importer.ProcessRecord(record)
+			return err
+		})
 		if err != nil {
-			return fmt.Errorf("Processing record %d: %w", i, err)
+			// An importer that didn't say where the problem is gets it said for them
+			var recordErr *RecordError
+			if !errors.As(err, &recordErr) {
+				err = &RecordError{Location: record.Location(), Row: record.Row(), Err: err}
+			}
+			metrics.Add("dealer_import_records_rejected_total", 1, "feed", filename)
+			run.log.Error("Record rejected", err, "row", i, "location", record.Location().String(), "dealer_id", vehicle.DealerID, "lot_type", vehicle.LotType)
+			return fmt.Errorf("Processing records: %w", err)
+		}
+		vehicles = append(vehicles, vehicle)
+	}
+	run.claims = newFeedClaims(vehicles)
+	run.claims.reportMultiDealer(run.log, metrics, filename)
+	if err := run.timed("preload", func() error { return run.preload(vehicles, db) }); err != nil {
+		return err
+	}
+
+	var set InventorySet
+	var rows lotRows
+	var feed lotFeed
+	lotLog := run.log
+
+	for i, vehicle := range vehicles {
+		if err := ctx.Err(); err != nil {
+			// Whatever lot we were partway through never gets replaced, which is the point
+			lotLog.Warn("Run cancelled", "row", i)
+			return fmt.Errorf("Cancelled at record %d: %w", i, err)
 		}
 
 		lot := set.Lot()
-		if lot != vehicle.Lot {
+		if lot.DealerID != vehicle.DealerID || lot.LotType != vehicle.LotType {
 			// Cheating here--there is no d_id == 0 so its easy to tell when we're on the first record
 			if lot.DealerID != 0 {
 				// Before the lot changes, capture the state of the InventorySet
-				set.FullReplace(db)
+				changes, err := run.finishLot(set, feed, db, lotLog)
+				if err != nil {
+					return fmt.Errorf("Replacing lot %v: %w", lot, err)
+				}
+				replaced(changes)
 			}
 
-			set = NewInventorySet(vehicle.Lot, db)
+			lotLog = run.log.With("dealer_id", vehicle.DealerID, "lot_type", vehicle.LotType)
+			if set, err = run.inventorySet(vehicle.Lot, db); err != nil {
+				return err
+			}
+			rows = newLotRows()
+			feed = lotFeed{}
 		}
 
+		// The master record's name is the one that sticks, whatever the feed says
+		vehicle.Lot = set.Lot()
+		vehicle.LotID = set.LotID()
+		feed.vehicles = append(feed.vehicles, vehicle.FeedVehicle)
+
+		// Anything odd for the lot it's on gets flagged, but it's still the feed's say-so
+		for _, problem := range vehicle.LotType.Check(vehicle.FeedVehicle) {
+			lotLog.Warn("Vehicle unexpected for lot type", "row", i, "vin", vehicle.VIN, "problem", problem.Error())
+			metrics.Add("dealer_import_lot_type_warnings_total", 1, "feed", filename, "lot_type", string(vehicle.LotType))
+		}
+		for _, problem := range vehicle.CheckPowertrain() {
+			lotLog.Warn("Vehicle powertrain incomplete", "row", i, "vin", vehicle.VIN, "problem", problem.Error())
+			metrics.Add("dealer_import_powertrain_warnings_total", 1, "feed", filename, "fuel", vehicle.Fuel)
+		}
+		// Quality goes by what the feed sent, duplicates and all, since that's what the dealer can do something about
+		feed.quality.Add(dealer.ScoreVehicle(vehicle.FeedVehicle, vehicle.LotType, run.start))
+
 		// All the bits have been extracted at this point
-		matchingVehicle, found := set.MatchingVehicle(vehicle.VehicleKey)
+		var replacing *lotRow
+		if duplicate, first, ok := rows.duplicateOf(i, vehicle.FeedVehicle); ok {
+			run.duplicate(set, duplicate, lotLog)
+			switch run.Config.DuplicatePolicy {
+			case FirstWins:
+				continue
+			case RejectBoth:
+				rows.reject(set, first)
+				continue
+			}
+			if first.added() {
+				replacing = first
+			}
+		}
+
+		match := set.Match(vehicle.FeedVehicle)
+		if len(match.Ambiguous) > 0 {
+			candidates := []string{}
+			for _, candidate := range match.Ambiguous {
+				candidates = append(candidates, fmt.Sprintf("%d:%s:%.2f", candidate.Vehicle.ID, candidate.Strategy, candidate.Confidence))
+			}
+			lotLog.Warn("Ambiguous match, record skipped", "row", i, "vin", vehicle.VIN, "stock", vehicle.Stock, "candidates", strings.Join(candidates, " "))
+			metrics.Add("dealer_import_matches_total", 1, "feed", filename, "strategy", "ambiguous")
+			set.Hold(vehicle.FeedVehicle, match.Ambiguous)
+			continue
+		}
+
+		// A vehicle on its way from another lot is checked as a new one--its rules are about this lot's inventory
+		var persisted *dealer.Vehicle
+		if match.Found {
+			persisted = &match.Vehicle
+		}
+		broken, severity := run.checkRules(i, vehicle, persisted, lotLog)
+		feed.violations = append(feed.violations, broken...)
+		if severity == RejectRow {
+			set.Reject(match)
+			if run.Config.Review {
+				kind, id := PendingInsert, 0
+				if match.Found {
+					kind, id = PendingUpdate, match.Vehicle.ID
+				}
+				if err := run.park(set, kind, id, vehicle.FeedVehicle, problems(broken)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		matchingVehicle, found := match.Vehicle, match.Found
+		if found {
+			if conflict, ok := conflictWith(i, vehicle.FeedVehicle, match.Match); ok {
+				run.duplicate(set, conflict, lotLog)
+			}
+			metrics.Add("dealer_import_matches_total", 1, "feed", filename, "strategy", match.Strategy)
+			if match.Confidence < 1 {
+				lotLog.Info("Matched inexactly", "row", i, "v_id", matchingVehicle.ID, "strategy", match.Strategy, "confidence", match.Confidence)
+			}
+		} else {
+			// Nothing on this lot, but it might be on another one on its way here
+			source, from, ok, err := run.transferFrom(db, set, vehicle.FeedVehicle, lotLog)
+			if err != nil {
+				return err
+			}
+			if ok {
+				lotLog.Info("Vehicle transferred", "row", i, "v_id", source.ID, "from_dealer_id", from.DealerID, "from_lot_type", from.LotType)
+				metrics.Add("dealer_import_matches_total", 1, "feed", filename, "strategy", "transfer")
+				matchingVehicle = set.Transfer(source, from)
+			} else {
+				metrics.Add("dealer_import_matches_total", 1, "feed", filename, "strategy", "none")
+			}
+		}
+		// An odometer going backwards or a Ford turning into a Honda is more likely a mixup than a change
+		if found || matchingVehicle.State == dealer.StateTransferred {
+			if anomalies := matchingVehicle.FeedVehicle.Anomalies(vehicle.FeedVehicle); len(anomalies) > 0 {
+				held, err := run.suspicious(set, i, matchingVehicle, vehicle.FeedVehicle, anomalies, lotLog)
+				if err != nil {
+					return err
+				}
+				if held {
+					continue
+				}
+			}
+		}
+
 		now := time.Now()
-		if !found {
+		switch {
+		case matchingVehicle.State == dealer.StateTransferred:
+			// Whatever else changed, the lot did--so it's a transfer even if the rest of it is identical
+			matchingVehicle.FeedVehicle = vehicle.FeedVehicle
+			vehicle = matchingVehicle
+			vehicle.TheGuilty = "IMPORT"
+			vehicle.LastModified = now
+		case !found:
 			vehicle.TheGuilty = "IMPORT"
 			vehicle.LastModified = now
 			vehicle.Created = now
 			vehicle.State = dealer.StateUnknown
-		} else if matchingVehicle.FeedVehicle != vehicle.FeedVehicle {
+		case matchingVehicle.FeedVehicle != vehicle.FeedVehicle:
 			// Struct equivalency and assignment is a convenient Go hack
 			// Its also how data you may not want set, gets unset--but
 			// for this exercise, assume the feed is sourse of truth
@@ -98,13 +455,455 @@ func (runner FullReplaceRunner) Run(importer Importer, db *gorm.DB) error {
 			vehicle.TheGuilty = "IMPORT"
 			vehicle.LastModified = now
 			vehicle.State = dealer.StateAltered
-		} else {
+		default:
 			vehicle = matchingVehicle
 			vehicle.State = dealer.StateUnaltered
 		}
+		if replacing != nil && vehicle.State == dealer.StateUnknown {
+			// There's nothing in inventory for this row or the one it repeats, so it takes the place of the
+			// vehicle that row added--rather than the unsaved vehicle being matched, or added twice
+			set.ClearVehicle(replacing.vehicle)
+			set.SetVehicle(vehicle)
+			rows.replace(replacing, i, vehicle)
+			continue
+		}
 		set.SetVehicle(vehicle)
+
+		// Remember what the row replaced, in case a later row turns out to be its duplicate
+		var previous *dealer.Vehicle
+		if found {
+			previous = &match.Vehicle
+		}
+		rows.accept(i, vehicle, previous)
 	}
 
 	// Capture the state of the InventorySet after the last Lot in the feed
-	return set.FullReplace(db)
+	if set.Lot().DealerID == 0 {
+		// An empty feed replaces nothing
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		lotLog.Warn("Run cancelled", "row", len(records))
+		return fmt.Errorf("Cancelled at record %d: %w", len(records), err)
+	}
+	changes, err := run.finishLot(set, feed, db, lotLog)
+	if err != nil {
+		return fmt.Errorf("Replacing lot %v: %w", set.Lot(), err)
+	}
+	replaced(changes)
+	return nil
+}
+
+// preload resolves the master records of every lot in the feed and loads all of their inventory at once
+func (run *runState) preload(vehicles []dealer.Vehicle, db *gorm.DB) error {
+	lots := map[int]dealer.Lot{}
+	keys := map[int]lotKey{}
+	seen := map[lotKey]bool{}
+	for _, vehicle := range vehicles {
+		key := keyOf(vehicle.Lot)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		lot, lotID, err := run.resolve(vehicle.Lot, db)
+		if err != nil {
+			return err
+		}
+		lots[lotID] = lot
+		keys[lotID] = key
+	}
+
+	sets, err := LoadInventorySets(db, lots)
+	if err != nil {
+		return err
+	}
+	run.preloaded = map[lotKey]InventorySet{}
+	for lotID, set := range sets {
+		run.preloaded[keys[lotID]] = set
+	}
+	return nil
+}
+
+// inventorySet hands out the preloaded inventory for a lot, or loads it fresh if it's been handed out already
+func (run *runState) inventorySet(lot dealer.Lot, db *gorm.DB) (InventorySet, error) {
+	set, ok := run.preloaded[keyOf(lot)]
+	if ok {
+		delete(run.preloaded, keyOf(lot))
+	} else {
+		resolved, lotID, err := run.resolve(lot, db)
+		if err != nil {
+			return InventorySet{}, err
+		}
+		set = NewInventorySet(resolved, lotID, db)
+	}
+	if run.Config.Matcher != nil {
+		set = set.WithMatcher(*run.Config.Matcher)
+	}
+	return set.WithBatchSize(run.Config.BatchSize), nil
+}
+
+// resolve finds, or creates, the master records for a lot in the feed.  A feed with a dealer name that doesn't
+// match the master record gets logged and counted; it doesn't change the name--that's a job for a human,
+// since a feed is just as likely to have it wrong
+func (run *runState) resolve(lot dealer.Lot, db *gorm.DB) (dealer.Lot, int, error) {
+	dealerLot, master, err := dealer.ResolveLot(db, lot)
+	if err != nil {
+		return dealer.Lot{}, 0, fmt.Errorf("Resolving lot %v: %w", lot, err)
+	}
+	if master.Name != lot.DealerName {
+		run.log.Warn("Dealer name differs from master record", "dealer_id", lot.DealerID, "lot_type", lot.LotType, "feed_name", lot.DealerName, "master_name", master.Name)
+		run.Config.Metrics.Add("dealer_import_dealer_name_mismatches_total", 1, "feed", run.Config.Filename, "dealer_id", fmt.Sprint(lot.DealerID))
+	}
+	return dealerLot.Lot(master), dealerLot.ID, nil
+}
+
+// changedColumns lists the columns a diff touches, space separated like the rest of the log's lists
+func changedColumns(changes []dealer.FieldChange) string {
+	columns := make([]string, len(changes))
+	for i, change := range changes {
+		columns[i] = change.Column
+	}
+	return strings.Join(columns, " ")
+}
+
+// duplicate reports a repeated or conflicting row--in the log, in metrics and in the lot's LotChanges
+func (run *runState) duplicate(set InventorySet, duplicate Duplicate, log Logger) {
+	keyvals := []interface{}{"kind", duplicate.Kind, "row", duplicate.Row, "vin", duplicate.Vehicle.VIN, "stock", duplicate.Vehicle.Stock}
+	if duplicate.Existing != nil {
+		keyvals = append(keyvals, "v_id", duplicate.Existing.ID, "existing_vin", duplicate.Existing.VIN, "existing_stock", duplicate.Existing.Stock)
+		log.Warn("Row conflicts with inventory", keyvals...)
+	} else {
+		keyvals = append(keyvals, "first_row", duplicate.FirstRow, "policy", run.Config.DuplicatePolicy.String())
+		log.Warn("Duplicate row", keyvals...)
+	}
+	run.Config.Metrics.Add("dealer_import_duplicates_total", 1, "feed", run.Config.Filename, "kind", duplicate.Kind)
+	set.ReportDuplicate(duplicate)
+}
+
+// lotFeed is what the feed said about the lot the runner is on, for when it's done with it
+type lotFeed struct {
+	vehicles   []dealer.FeedVehicle
+	quality    dealer.LotQuality
+	violations []Violation
+}
+
+// problems are what each of violations says is wrong, for a PendingChange's Reason
+func problems(violations []Violation) string {
+	reasons := make([]string, len(violations))
+	for i, violation := range violations {
+		reasons[i] = fmt.Sprintf("%s: %s", violation.Rule, violation.Problem)
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// park puts a vehicle the runner is leaving out in the lot's pending changes, to be written along with the lot
+func (run *runState) park(set InventorySet, kind string, vehicleID int, feed dealer.FeedVehicle, reason string) error {
+	change, err := ParkVehicle(kind, set.LotID(), vehicleID, feed, reason)
+	if err != nil {
+		return err
+	}
+	set.Park(change)
+	return nil
+}
+
+// suspicious reports a change to a vehicle with anomalies--in the log, in metrics and in the lot's LotChanges--and
+// holds the vehicle as inventory has it if the Config says to, parking the change for review if it says that too.
+// It returns whether it held it
+func (run *runState) suspicious(set InventorySet, row int, vehicle dealer.Vehicle, feed dealer.FeedVehicle, anomalies []dealer.Anomaly, log Logger) (bool, error) {
+	kinds := make([]string, len(anomalies))
+	for i, anomaly := range anomalies {
+		kinds[i] = anomaly.String()
+		run.Config.Metrics.Add("dealer_import_anomalies_total", 1, "feed", run.Config.Filename, "kind", anomaly.Kind)
+	}
+	log.Warn("Suspicious change", "row", row, "v_id", vehicle.ID, "vin", feed.VIN, "stock", feed.Stock, "anomalies", strings.Join(kinds, "; "), "held", run.Config.HoldAnomalies)
+
+	set.ReportSuspicious(SuspiciousChange{Row: row, Vehicle: vehicle, Feed: feed, Anomalies: anomalies, Held: run.Config.HoldAnomalies})
+	if !run.Config.HoldAnomalies {
+		return false, nil
+	}
+	// A transfer that's held stays on the lot it was on, same as any other vehicle that's held--approving it
+	// moves it here
+	vehicle.State = dealer.StateHeld
+	set.SetVehicle(vehicle)
+	if run.Config.Review {
+		return true, run.park(set, PendingUpdate, vehicle.ID, feed, strings.Join(kinds, "; "))
+	}
+	return true, nil
+}
+
+// holdDeletes keeps every vehicle the feed left off a lot when it left off more than Config.MaxDeletePercent of them,
+// parking each as a delete if the Config says to review.  Vehicles moving here from another lot aren't counted; they
+// were never the lot's to lose
+func (run *runState) holdDeletes(set InventorySet, log Logger) error {
+	if run.Config.MaxDeletePercent <= 0 {
+		return nil
+	}
+	inventory := 0
+	deletes := []dealer.Vehicle{}
+	for _, vehicle := range set.Vehicles() {
+		if vehicle.ID == 0 || vehicle.State == dealer.StateTransferred {
+			continue
+		}
+		inventory++
+		if vehicle.State == dealer.StatePersisted {
+			deletes = append(deletes, vehicle)
+		}
+	}
+	if len(deletes) == 0 || float64(len(deletes))*100 <= run.Config.MaxDeletePercent*float64(inventory) {
+		return nil
+	}
+
+	reason := fmt.Sprintf("Feed left out %d of the lot's %d vehicles, more than %g%%", len(deletes), inventory, run.Config.MaxDeletePercent)
+	log.Warn("Deletes held", "deletes", len(deletes), "vehicles", inventory, "max_delete_percent", run.Config.MaxDeletePercent)
+	lot := set.Lot()
+	run.Config.Metrics.Add("dealer_import_deletes_held_total", float64(len(deletes)), "feed", run.Config.Filename, "dealer_id", fmt.Sprint(lot.DealerID), "lot_type", string(lot.LotType))
+	for _, vehicle := range deletes {
+		vehicle.State = dealer.StateHeld
+		set.SetVehicle(vehicle)
+		if run.Config.Review {
+			if err := run.park(set, PendingDelete, vehicle.ID, vehicle.FeedVehicle, reason); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replace full-replaces a single lot in a transaction of its own, so a lot is either replaced or it isn't.
+// With an outbox, the lot's events are committed right along with it
+func (run *runState) replace(set InventorySet, quality dealer.LotQuality, db *gorm.DB, log Logger) (LotChanges, error) {
+	var changes LotChanges
+	start := time.Now()
+	run.holdTransfers(set, log)
+	if err := run.holdDeletes(set, log); err != nil {
+		return changes, err
+	}
+	quality.RunID, quality.LotID, quality.Scored = run.emitter.RunID, set.LotID(), run.start
+	err := run.timed("replace", func() error {
+		return inTransaction(db, func(tx *gorm.DB) error {
+			var err error
+			if changes, err = set.FullReplace(tx); err != nil {
+				return err
+			}
+			if err = tx.Create(&quality).Error; err != nil {
+				return fmt.Errorf("Recording lot quality: %w", err)
+			}
+			if err = WritePending(tx, run.emitter.RunID, changes.Pending); err != nil {
+				return err
+			}
+			if run.onReplace != nil {
+				if err = run.onReplace(tx, changes); err != nil {
+					return err
+				}
+			}
+			if run.Config.Outbox {
+				return WriteOutbox(tx, run.emitter, lotEvents(changes))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Error("Lot replacement failed", err)
+		return changes, err
+	}
+
+	lot := set.Lot()
+	labels := []string{"feed", run.Config.Filename, "dealer_id", fmt.Sprint(lot.DealerID), "lot_type", string(lot.LotType)}
+	metrics := run.Config.Metrics
+	metrics.Add("dealer_import_vehicles_inserted_total", float64(len(changes.Added)), labels...)
+	metrics.Add("dealer_import_vehicles_updated_total", float64(len(changes.Changed)), labels...)
+	metrics.Add("dealer_import_vehicles_deleted_total", float64(len(changes.Removed)), labels...)
+	metrics.Add("dealer_import_vehicles_transferred_total", float64(len(changes.Transferred)), labels...)
+	metrics.Add("dealer_import_vehicles_unaltered_total", float64(changes.Unaltered), labels...)
+	metrics.Set("dealer_import_lot_duration_seconds", time.Since(start).Seconds(), labels...)
+	metrics.Set("dealer_import_lot_quality_score", quality.Score, labels...)
+	metrics.Add("dealer_import_changes_parked_total", float64(len(changes.Pending)), labels...)
+
+	// Which columns of each vehicle were written, for anyone wondering later why a price went to zero
+	for _, change := range changes.Changed {
+		log.Info("Vehicle updated", "v_id", change.Vehicle.ID, "columns", changedColumns(change.Changes))
+	}
+
+	log.Info("Lot replaced",
+		"added", len(changes.Added),
+		"changed", len(changes.Changed),
+		"removed", len(changes.Removed),
+		"transferred", len(changes.Transferred),
+		"unaltered", changes.Unaltered,
+		"ambiguous", len(changes.Ambiguous),
+		"duplicates", len(changes.Duplicates),
+		"suspicious", len(changes.Suspicious),
+		"pending", len(changes.Pending),
+		"quality", quality.Score,
+		"duration", time.Since(start).Seconds(),
+	)
+	return changes, nil
+}
+
+// finishLot replaces a lot the feed is done with, and says which rules its rows broke--unless one of them said to hold
+// the lot, in which case it's left as it is, and everything the feed said about it is parked for review if the Config
+// says to.  Its quality is recorded either way, since the feed is no better for it
+func (run *runState) finishLot(set InventorySet, feed lotFeed, db *gorm.DB, log Logger) (LotChanges, error) {
+	quality, violations := feed.quality, feed.violations
+	for _, violation := range violations {
+		if violation.Severity != HoldLot {
+			continue
+		}
+		held := LotChanges{Lot: set.Lot(), Held: true, Violations: violations}
+		quality.RunID, quality.LotID, quality.Scored = run.emitter.RunID, set.LotID(), run.start
+		err := inTransaction(db, func(tx *gorm.DB) error {
+			if err := tx.Create(&quality).Error; err != nil {
+				return fmt.Errorf("Recording lot quality: %w", err)
+			}
+			if run.Config.Review {
+				var holding []Violation
+				for _, violation := range violations {
+					if violation.Severity == HoldLot {
+						holding = append(holding, violation)
+					}
+				}
+				change, err := ParkLot(set.LotID(), feed.vehicles, problems(holding))
+				if err != nil {
+					return err
+				}
+				held.Pending = []PendingChange{change}
+				if err = WritePending(tx, run.emitter.RunID, held.Pending); err != nil {
+					return err
+				}
+			}
+			// LotHeld goes out the same way LotReplaced would have
+			if run.Config.Outbox {
+				return WriteOutbox(tx, run.emitter, lotEvents(held))
+			}
+			return nil
+		})
+		if err != nil {
+			return LotChanges{}, err
+		}
+		run.Config.Metrics.Add("dealer_import_lots_held_total", 1, "feed", run.Config.Filename, "dealer_id", fmt.Sprint(set.Lot().DealerID), "lot_type", string(set.Lot().LotType))
+		log.Warn("Lot held", "rule", violation.Rule, "row", violation.Row, "violations", len(violations), "pending", len(held.Pending))
+		return held, nil
+	}
+
+	changes, err := run.replace(set, quality, db, log)
+	changes.Violations = violations
+	return changes, err
+}
+
+// Replay puts a previously archived aquisition back in the Importer's working directory
+// and runs it as though it had just been aquired.  Pair with Config.DryRun to see what
+// an old feed would do to the current inventory without it actually doing it.
+// It's restored under a name of its own--see ReplayFilename--and removed after, so the live
+// feed's file is never touched, and the next run doesn't go importing history for real
+func (runner FullReplaceRunner) Replay(importer Importer, entry ArchiveEntry, db *gorm.DB) error {
+	return runner.ReplayContext(context.Background(), importer, entry, db)
+}
+
+// ReplayContext is Replay, stopping when ctx is done in the same way as RunContext
+func (runner FullReplaceRunner) ReplayContext(ctx context.Context, importer Importer, entry ArchiveEntry, db *gorm.DB) error {
+	archiver, ok := importer.(Archiver)
+	if !ok || runner.Config.Archive == nil {
+		return fmt.Errorf("Replaying %s: importer cannot restore archived records", entry)
+	}
+
+	reader, err := runner.Config.Archive.Open(entry)
+	if err != nil {
+		return err
+	}
+	filename := ReplayFilename(entry.Filename)
+	err = archiver.RestoreAquired(filename, reader)
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("Restoring %s: %w", entry, err)
+	}
+
+	// The restored file counts as aquired, so this won't go off and fetch anything new
+	runner.Config.Filename = filename
+	runner.Config.DoProcessing = true
+	err = runner.RunContext(ctx, importer, db)
+	if removeErr := archiver.RemoveAquired(filename); removeErr != nil && err == nil {
+		err = fmt.Errorf("Removing replayed %s: %w", entry, removeErr)
+	}
+	return err
+}
+
+// ReplayFilename is the name a replay of filename is restored and run under.  It keeps the extension, so an
+// importer that goes by it reads the file the same way, and it's what logs and metrics label the replay's feed
+// with, so a replay isn't mistaken for the live feed's latest run
+func ReplayFilename(filename string) string {
+	return "replay." + filename
+}
+
+// archive stores a freshly aquired file, if there's an archive and the importer can give it to us,
+// and then prunes whatever the retention policy says is too old
+func (runner FullReplaceRunner) archive(importer Importer, filename string) error {
+	archiver, ok := importer.(Archiver)
+	if !ok || runner.Config.Archive == nil {
+		return nil
+	}
+
+	reader, err := archiver.OpenAquired(filename)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	now := time.Now()
+	if _, err = runner.Config.Archive.Store(filename, now, reader); err != nil {
+		return err
+	}
+	_, err = runner.Config.Archive.Prune(filename, now)
+	return err
+}
+
+// loggedImporter wraps an Importer to log each of its calls with the run's context,
+// which saves every Importer implementation from having to care about logging.
+// It's a ContextImporter whether or not what it wraps is one, which saves the runner caring either
+type loggedImporter struct {
+	Importer
+	log Logger
+}
+
+func (importer loggedImporter) AquireRecordsContext(ctx context.Context, filename string) error {
+	start := time.Now()
+	var err error
+	if contextImporter, ok := importer.Importer.(ContextImporter); ok {
+		err = contextImporter.AquireRecordsContext(ctx, filename)
+	} else if err = ctx.Err(); err == nil {
+		err = importer.Importer.AquireRecords(filename)
+	}
+
+	if err != nil {
+		importer.log.Error("Aquisition failed", err, "duration", time.Since(start).Seconds())
+	} else {
+		importer.log.Info("Records aquired", "duration", time.Since(start).Seconds())
+	}
+	return err
+}
+
+func (importer loggedImporter) LoadRecordsContext(ctx context.Context, filename string) ([]Record, error) {
+	start := time.Now()
+	var records []Record
+	var err error
+	if contextImporter, ok := importer.Importer.(ContextImporter); ok {
+		records, err = contextImporter.LoadRecordsContext(ctx, filename)
+	} else if err = ctx.Err(); err == nil {
+		records, err = importer.Importer.LoadRecords(filename)
+	}
+
+	if err != nil {
+		importer.log.Error("Loading failed", err, "duration", time.Since(start).Seconds())
+	} else {
+		importer.log.Info("Records loaded", "records", len(records), "duration", time.Since(start).Seconds())
+	}
+	return records, err
+}
+
+// boolGauge is how Prometheus likes its booleans
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
 }
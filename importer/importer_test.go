@@ -0,0 +1,135 @@
+package importer_test
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/seamuncle/dealer"
+	"github.com/seamuncle/dealer/importer"
+	"github.com/seamuncle/dealer/importer/importertest"
+)
+
+// countOf is how many rows of table match where
+func countOf(t *testing.T, db *gorm.DB, table, where string, args ...interface{}) int {
+	t.Helper()
+	var count int
+	if err := db.Table(table).Where(where, args...).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestFullReplace(t *testing.T) {
+	newLot := importertest.Lot(1001, dealer.TypeNew)
+	usedLot := importertest.Lot(1001, dealer.TypeUsed)
+	fusion := func(lot dealer.Lot) importertest.Builder {
+		return importertest.Vehicle(lot, "A124", "1GCEP22T1G3329139")
+	}
+	mustang := func(lot dealer.Lot) importertest.Builder {
+		return importertest.Vehicle(lot, "B105", "KM8SB12B02U162029").Described(2014, "Ford", "Mustang", "GT")
+	}
+	edge := func(lot dealer.Lot) importertest.Builder {
+		return importertest.Vehicle(lot, "N900", "2FMDK3KC0ABA00001").Described(2018, "Ford", "Edge", "SE")
+	}
+
+	for _, scenario := range []importertest.Scenario{{
+		Name:   "price drop",
+		Seed:   []dealer.Vehicle{fusion(newLot).Build()},
+		Feed:   importertest.Records(fusion(newLot).Priced(25999)),
+		Golden: "testdata/price_drop.golden",
+	}, {
+		Name:   "new vehicle in, unlisted vehicle out",
+		Seed:   []dealer.Vehicle{fusion(newLot).Build(), mustang(newLot).Build()},
+		Feed:   importertest.Records(fusion(newLot), edge(newLot)),
+		Golden: "testdata/added_and_removed.golden",
+	}, {
+		Name:   "new vehicle listed twice, last row wins",
+		Seed:   []dealer.Vehicle{fusion(newLot).Build()},
+		Feed:   importertest.Records(fusion(newLot), edge(newLot).Priced(31000), edge(newLot).Priced(30000)),
+		Golden: "testdata/duplicate_new_vehicle.golden",
+	}, {
+		Name:   "new vehicle listed twice, the same both times",
+		Seed:   []dealer.Vehicle{fusion(newLot).Build()},
+		Feed:   importertest.Records(fusion(newLot), edge(newLot), edge(newLot)),
+		Golden: "testdata/duplicate_identical_new_vehicle.golden",
+	}, {
+		Name:   "new vehicle listed twice, first row wins",
+		Seed:   []dealer.Vehicle{fusion(newLot).Build()},
+		Feed:   importertest.Records(fusion(newLot), edge(newLot).Priced(31000), edge(newLot).Priced(30000)),
+		Config: importer.Config{DuplicatePolicy: importer.FirstWins},
+		Golden: "testdata/duplicate_new_vehicle_first_wins.golden",
+	}, {
+		Name:   "transfer from used to new",
+		Seed:   []dealer.Vehicle{fusion(newLot).Build(), mustang(usedLot).Build()},
+		Feed:   importertest.Records(fusion(newLot), mustang(newLot)),
+		Golden: "testdata/transfer.golden",
+	}, {
+		Name: "transfer of a VIN stored with a dash and a space",
+		Seed: []dealer.Vehicle{fusion(newLot).Build(), mustang(usedLot).With(func(vehicle *dealer.Vehicle) {
+			vehicle.VIN = "km8sb-12b02 u162029"
+		}).Build()},
+		Feed:   importertest.Records(fusion(newLot), mustang(newLot)),
+		Golden: "testdata/transfer_normalized_vin.golden",
+	}, {
+		Name:   "rejected row leaves the vehicle alone",
+		Seed:   []dealer.Vehicle{fusion(newLot).Build(), mustang(newLot).Build()},
+		Feed:   importertest.Records(fusion(newLot).Priced(31), mustang(newLot).Priced(19999)),
+		Config: importer.Config{Rules: []importer.Rule{importer.PriceFloor(500, importer.RejectRow)}},
+		Golden: "testdata/rejected_row.golden",
+	}, {
+		Name:   "held lot is left as it is",
+		Seed:   []dealer.Vehicle{fusion(newLot).Build(), mustang(newLot).Build()},
+		Feed:   importertest.Records(fusion(newLot).Priced(31), edge(newLot)),
+		Config: importer.Config{Rules: []importer.Rule{importer.PriceFloor(500, importer.HoldLot)}},
+		Golden: "testdata/held_lot.golden",
+	}, {
+		Name:   "odometer rollback held",
+		Seed:   []dealer.Vehicle{mustang(usedLot).Driven(145754).Build()},
+		Feed:   importertest.Records(mustang(usedLot).Driven(14575).Priced(8999)),
+		Config: importer.Config{HoldAnomalies: true},
+		Golden: "testdata/odometer_rollback.golden",
+	}, {
+		Name:   "odometer rollback parked for review",
+		Seed:   []dealer.Vehicle{mustang(usedLot).Driven(145754).Build()},
+		Feed:   importertest.Records(mustang(usedLot).Driven(14575).Priced(8999)),
+		Config: importer.Config{HoldAnomalies: true, Review: true},
+		Golden: "testdata/odometer_rollback.golden",
+		Check: func(t *testing.T, db *gorm.DB) {
+			if count := countOf(t, db, "pending_changes", "kind = ? AND status = ?", importer.PendingUpdate, importer.StatusPending); count != 1 {
+				t.Errorf("%d pending updates, want the rollback parked", count)
+			}
+		},
+	}, {
+		// Two of the lot's three is more than half, so all three stay--and the price drop still happens
+		Name:   "feed leaving out most of a lot deletes nothing",
+		Seed:   []dealer.Vehicle{fusion(newLot).Build(), mustang(newLot).Build(), edge(newLot).Build()},
+		Feed:   importertest.Records(fusion(newLot).Priced(25999)),
+		Config: importer.Config{MaxDeletePercent: 50, Review: true},
+		Golden: "testdata/deletes_held.golden",
+		Check: func(t *testing.T, db *gorm.DB) {
+			if count := countOf(t, db, "pending_changes", "kind = ? AND status = ?", importer.PendingDelete, importer.StatusPending); count != 2 {
+				t.Errorf("%d pending deletes, want both vehicles the feed left out parked", count)
+			}
+		},
+	}, {
+		// One of three isn't
+		Name:   "feed leaving out a little of a lot deletes it",
+		Seed:   []dealer.Vehicle{fusion(newLot).Build(), mustang(newLot).Build(), edge(newLot).Build()},
+		Feed:   importertest.Records(fusion(newLot), mustang(newLot)),
+		Config: importer.Config{MaxDeletePercent: 50, Review: true},
+		Golden: "testdata/deletes_under_limit.golden",
+		Check: func(t *testing.T, db *gorm.DB) {
+			if count := countOf(t, db, "pending_changes", "1 = 1"); count != 0 {
+				t.Errorf("%d pending changes, want none", count)
+			}
+		},
+	}, {
+		Name:   "odometer rollback rejected by rule",
+		Seed:   []dealer.Vehicle{mustang(usedLot).Driven(145754).Build(), fusion(usedLot).Driven(20000).Build()},
+		Feed:   importertest.Records(mustang(usedLot).Driven(14575).Priced(8999), fusion(usedLot).Driven(21000)),
+		Config: importer.Config{Rules: []importer.Rule{importer.OdometerDecrease(importer.RejectRow)}},
+		Golden: "testdata/odometer_decrease_rejected.golden",
+	}} {
+		t.Run(scenario.Name, scenario.Run)
+	}
+}
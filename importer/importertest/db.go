@@ -0,0 +1,94 @@
+// Package importertest is what it takes to test an importer without the sample database or the network: an
+// in-memory database with the inventory schema, vehicles to fill it with, an Importer that hands a runner
+// whatever records it's told to, and golden files to compare the database with afterwards.
+//
+// A scenario is a lot the database starts with, a feed, and what the database should look like after a run:
+//
+//	func TestReplace(t *testing.T) {
+//		lot := importertest.Lot(1001, dealer.TypeNew)
+//		for _, scenario := range []importertest.Scenario{{
+//			Name:   "price drop",
+//			Seed:   []dealer.Vehicle{importertest.Vehicle(lot, "A124", "1GCEP22T1G3329139").Build()},
+//			Feed:   importertest.Records(importertest.Vehicle(lot, "A124", "1GCEP22T1G3329139").Priced(25999)),
+//			Golden: "testdata/price_drop.golden",
+//		}} {
+//			t.Run(scenario.Name, scenario.Run)
+//		}
+//	}
+//
+// Run the tests with -importertest.update to write the golden files instead of comparing with them.
+package importertest
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	// The in-memory database is SQLite, same as the real one
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/seamuncle/dealer"
+	"github.com/seamuncle/dealer/importer"
+)
+
+// databases keeps every in-memory database apart from the others--they're shared by name across connections
+var databases int64
+
+// OpenDB opens a new, empty in-memory database with every table an import touches, failing tb if it can't.
+// Nobody else sees it, and it's gone once it's closed--which is up to the caller
+func OpenDB(tb testing.TB) *gorm.DB {
+	tb.Helper()
+	db, err := NewDB()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+// NewDB is OpenDB for when there's no testing.TB around to fail
+func NewDB() (*gorm.DB, error) {
+	// cache=shared is what lets every connection gorm pools see the same database; without it each gets its own
+	name := fmt.Sprintf("file:importertest%d?mode=memory&cache=shared", atomic.AddInt64(&databases, 1))
+	db, err := gorm.Open("sqlite3", name)
+	if err != nil {
+		return nil, fmt.Errorf("Opening in-memory database: %w", err)
+	}
+	// SQLite drops a shared in-memory database when its last connection closes, so one is kept around
+	db.DB().SetMaxIdleConns(1)
+	db.LogMode(false)
+
+	if err = dealer.Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err = db.AutoMigrate(&importer.OutboxMessage{}).Error; err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Migrating outbox: %w", err)
+	}
+	if err = db.AutoMigrate(&importer.PendingChange{}).Error; err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Migrating pending changes: %w", err)
+	}
+	return db, nil
+}
+
+// Seed puts vehicles in inventory the way an earlier import would have, creating master records for their lots
+// as it goes.  It returns them as they were stored, IDs and all
+func Seed(tb testing.TB, db *gorm.DB, vehicles ...dealer.Vehicle) []dealer.Vehicle {
+	tb.Helper()
+	seeded := make([]dealer.Vehicle, 0, len(vehicles))
+	for _, vehicle := range vehicles {
+		dealerLot, master, err := dealer.ResolveLot(db, vehicle.Lot)
+		if err != nil {
+			tb.Fatalf("Seeding vehicle %v: %v", vehicle.VehicleKey, err)
+		}
+		vehicle.Lot = dealerLot.Lot(master)
+		vehicle.LotID = dealerLot.ID
+		vehicle.State = dealer.StatePersisted
+		if err = db.Create(&vehicle).Error; err != nil {
+			tb.Fatalf("Seeding vehicle %v: %v", vehicle.VehicleKey, err)
+		}
+		seeded = append(seeded, vehicle)
+	}
+	return seeded
+}
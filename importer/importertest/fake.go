@@ -0,0 +1,149 @@
+package importertest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/seamuncle/dealer"
+	"github.com/seamuncle/dealer/importer"
+)
+
+// FakeImporter is an importer.ContextImporter that hands a runner whatever it's been scripted to--no files, no
+// network.  A ScriptedRecord is processed into the vehicle it holds, or the error it holds; any other
+// importer.Record is processed the way cmd/import would, more or less, which is what it takes to test
+// a feed of FieldRecords
+type FakeImporter struct {
+	Records []importer.Record
+	// Aquired is whether there's anything to load yet--AquireRecords sets it
+	Aquired bool
+	// AquireErr and LoadErr, when set, are what AquireRecords and LoadRecords fail with
+	AquireErr error
+	LoadErr   error
+	// AquireCalls counts the calls to AquireRecords, for tests that care whether a runner made any
+	AquireCalls int
+}
+
+// ScriptedRecord is a record that's already been processed, or already failed to be
+type ScriptedRecord struct {
+	Number  int
+	Vehicle dealer.Vehicle
+	Err     error
+}
+
+// Row is the record's place in the feed--FakeImporter numbers them if nobody else has
+func (record ScriptedRecord) Row() int {
+	return record.Number
+}
+
+// Location is the row, in a file that doesn't exist
+func (record ScriptedRecord) Location() importer.Location {
+	return importer.Location{File: "scripted", Line: record.Number}
+}
+
+// Fields are the ones a vehicle can't be told apart without
+func (record ScriptedRecord) Fields() []string {
+	return []string{"DealerID", "Type", "Stock", "VIN"}
+}
+
+// Field is one of Fields, as a feed would have it
+func (record ScriptedRecord) Field(name string) (string, bool) {
+	switch name {
+	case "DealerID":
+		return fmt.Sprint(record.Vehicle.DealerID), true
+	case "Type":
+		return record.Vehicle.LotType.FeedValue(), true
+	case "Stock":
+		return record.Vehicle.Stock, true
+	case "VIN":
+		return record.Vehicle.VIN, true
+	}
+	return "", false
+}
+
+// Records is a feed of vehicles, for FakeImporter.Records or Scenario.Feed
+func Records(vehicles ...Builder) []importer.Record {
+	records := make([]importer.Record, len(vehicles))
+	for i, vehicle := range vehicles {
+		records[i] = ScriptedRecord{Number: i + 1, Vehicle: vehicle.Build()}
+	}
+	return records
+}
+
+// Rejected is a record ProcessRecord fails with err
+func Rejected(err error) importer.Record {
+	return ScriptedRecord{Err: err}
+}
+
+// AquireRecords pretends to go get the feed, unless it's been told to fail
+func (fake *FakeImporter) AquireRecords(filename string) error {
+	return fake.AquireRecordsContext(context.Background(), filename)
+}
+
+// AquireRecordsContext is AquireRecords, failing straight away if ctx is already done
+func (fake *FakeImporter) AquireRecordsContext(ctx context.Context, filename string) error {
+	fake.AquireCalls++
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if fake.AquireErr != nil {
+		return fake.AquireErr
+	}
+	fake.Aquired = true
+	return nil
+}
+
+// HasAquired reports whether AquireRecords has been, or Aquired was set to begin with
+func (fake *FakeImporter) HasAquired(filename string) bool {
+	return fake.Aquired
+}
+
+// LoadRecords hands over the scripted records
+func (fake *FakeImporter) LoadRecords(filename string) ([]importer.Record, error) {
+	return fake.LoadRecordsContext(context.Background(), filename)
+}
+
+// LoadRecordsContext is LoadRecords, failing straight away if ctx is already done
+func (fake *FakeImporter) LoadRecordsContext(ctx context.Context, filename string) ([]importer.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if fake.LoadErr != nil {
+		return nil, fake.LoadErr
+	}
+	// Feeds put together out of bits get their rows numbered by where they ended up
+	records := make([]importer.Record, len(fake.Records))
+	for i, record := range fake.Records {
+		if scripted, ok := record.(ScriptedRecord); ok {
+			scripted.Number = i + 1
+			record = scripted
+		}
+		records[i] = record
+	}
+	return records, nil
+}
+
+// ProcessRecord turns a scripted record back into a vehicle, or the error it was scripted to be.  Anything
+// else gets its DealerID, Type, Stock and VIN fields read, which is enough to put it on a lot
+func (fake *FakeImporter) ProcessRecord(record importer.Record) (dealer.Vehicle, error) {
+	if scripted, ok := record.(ScriptedRecord); ok {
+		return scripted.Vehicle, scripted.Err
+	}
+
+	vehicle := dealer.Vehicle{}
+	value, _ := record.Field("DealerID")
+	dealerID, err := strconv.Atoi(value)
+	if err != nil {
+		return vehicle, importer.FieldError(record, "DealerID", fmt.Errorf("Parsing DealerID (%s): %w", value, err))
+	}
+	vehicle.DealerID = dealerID
+	vehicle.DealerName = fmt.Sprintf("Dealer %d", dealerID)
+
+	value, _ = record.Field("Type")
+	if vehicle.LotType, err = dealer.ParseLotType(value); err != nil {
+		return vehicle, importer.FieldError(record, "Type", err)
+	}
+	vehicle.Stock, _ = record.Field("Stock")
+	vehicle.VIN, _ = record.Field("VIN")
+	return vehicle, nil
+}
@@ -0,0 +1,129 @@
+package importertest
+
+import (
+	"bytes"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/seamuncle/dealer"
+)
+
+// update is the flag that has Golden write golden files instead of comparing with them
+var update = flag.Bool("importertest.update", false, "write golden files instead of comparing with them")
+
+// snapshotTables are the tables an import is responsible for, in the order Snapshot shows them
+var snapshotTables = []string{"dealers", "lots", dealer.Vehicle{}.TableName(), dealer.Transfer{}.TableName()}
+
+// volatileColumns change every time a test runs, so they're left out of snapshots
+var volatileColumns = map[string]bool{
+	"created_time":       true,
+	"last_modified_time": true,
+	"transferred_time":   true,
+}
+
+// Snapshot is every table an import touches, written out in a way that's the same every time the database is,
+// for comparing with a golden file.  Rows are in primary key order; timestamps are left out, since they never are the same
+func Snapshot(tb testing.TB, db *gorm.DB) string {
+	tb.Helper()
+	snapshot, err := snapshotOf(db)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return snapshot
+}
+
+// snapshotOf is Snapshot without the testing.TB
+func snapshotOf(db *gorm.DB) (string, error) {
+	var out strings.Builder
+	for _, table := range snapshotTables {
+		rows, err := db.DB().Query(fmt.Sprintf(`SELECT * FROM "%s" ORDER BY 1`, table))
+		if err != nil {
+			return "", fmt.Errorf("Snapshotting %s: %w", table, err)
+		}
+		err = writeTable(&out, table, rows)
+		rows.Close()
+		if err != nil {
+			return "", fmt.Errorf("Snapshotting %s: %w", table, err)
+		}
+	}
+	return out.String(), nil
+}
+
+// writeTable writes a table's rows as tab separated lines under its name and column names
+func writeTable(out *strings.Builder, table string, rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	kept := []string{}
+	for _, column := range columns {
+		if !volatileColumns[column] {
+			kept = append(kept, column)
+		}
+	}
+	fmt.Fprintf(out, "# %s\n%s\n", table, strings.Join(kept, "\t"))
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(pointers...); err != nil {
+			return err
+		}
+		fields := []string{}
+		for i, column := range columns {
+			if !volatileColumns[column] {
+				fields = append(fields, format(values[i]))
+			}
+		}
+		fmt.Fprintln(out, strings.Join(fields, "\t"))
+	}
+	out.WriteString("\n")
+	return rows.Err()
+}
+
+// format writes a column value the same way whichever type the driver chose for it
+func format(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(value)
+	case time.Time:
+		return value.UTC().Format("2006-01-02")
+	}
+	return fmt.Sprint(value)
+}
+
+// Golden compares got with the golden file at path, failing tb with both if they differ--or, when the tests are run
+// with -importertest.update, writes got to path instead
+func Golden(tb testing.TB, path, got string) {
+	tb.Helper()
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+			tb.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		tb.Fatalf("Reading golden file (run with -importertest.update to write it): %v", err)
+	}
+	if !bytes.Equal(want, []byte(got)) {
+		tb.Errorf("Database differs from %s (run with -importertest.update if it should):\n--- want\n%s\n--- got\n%s", path, want, got)
+	}
+}
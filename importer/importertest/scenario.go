@@ -0,0 +1,56 @@
+package importertest
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/seamuncle/dealer"
+	"github.com/seamuncle/dealer/importer"
+)
+
+// Scenario is a single table-driven import test: what inventory starts out as, the feed, and what inventory
+// should be once a FullReplaceRunner has run it
+type Scenario struct {
+	Name string
+	// Seed is inventory before the run--see Seed
+	Seed []dealer.Vehicle
+	// Feed is the records a FakeImporter hands the runner
+	Feed []importer.Record
+	// Config is the runner's; DoProcessing is always on, and Filename defaults to scenario.csv
+	Config importer.Config
+	// WantErr is whether the run should fail
+	WantErr bool
+	// Golden is the file to compare a Snapshot of the database with after the run--empty skips the comparison
+	Golden string
+	// Check, when set, gets a look at the database after the run for anything a golden file can't say
+	Check func(t *testing.T, db *gorm.DB)
+}
+
+// Run runs the scenario against a database of its own, as a test or subtest
+func (scenario Scenario) Run(t *testing.T) {
+	t.Helper()
+	db := OpenDB(t)
+	defer db.Close()
+	Seed(t, db, scenario.Seed...)
+
+	config := scenario.Config
+	config.DoProcessing = true
+	if config.Filename == "" {
+		config.Filename = "scenario.csv"
+	}
+	runner := importer.FullReplaceRunner{Config: config}
+	err := runner.Run(&FakeImporter{Records: scenario.Feed, Aquired: true}, db)
+	if scenario.WantErr && err == nil {
+		t.Fatal("Run succeeded, and it should have failed")
+	}
+	if !scenario.WantErr && err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if scenario.Golden != "" {
+		Golden(t, scenario.Golden, Snapshot(t, db))
+	}
+	if scenario.Check != nil {
+		scenario.Check(t, db)
+	}
+}
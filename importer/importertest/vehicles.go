@@ -0,0 +1,118 @@
+package importertest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/seamuncle/dealer"
+)
+
+// Seeded is when every fixture vehicle was first seen, so nothing about a fixture depends on when a test runs
+var Seeded = time.Date(2018, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+// Lot is a lot as a feed describes it, for a dealer named after its ID
+func Lot(dealerID int, lotType dealer.LotType) dealer.Lot {
+	return dealer.Lot{DealerID: dealerID, DealerName: fmt.Sprintf("Dealer %d", dealerID), LotType: lotType}
+}
+
+// Builder builds a dealer.Vehicle a bit at a time, starting from one that's complete enough to get through an
+// import without anybody having to think about it.  The vehicle's embedded, so anything without a method of
+// its own can be set directly
+type Builder struct {
+	dealer.Vehicle
+}
+
+// Vehicle starts a Builder off with a vehicle on lot with the given stock number and VIN--a 2018 Ford Fusion,
+// unless it's told otherwise
+func Vehicle(lot dealer.Lot, stock, vin string) Builder {
+	return Builder{dealer.Vehicle{
+		Lot:          lot,
+		Created:      Seeded,
+		LastModified: Seeded,
+		TheGuilty:    "FIXTURE",
+		FeedVehicle: dealer.FeedVehicle{
+			VehicleKey:       dealer.VehicleKey{Stock: stock, VIN: vin},
+			Year:             2018,
+			Make:             "Ford",
+			Model:            "Fusion",
+			Trim:             "Sport",
+			Body:             "Car",
+			Doors:            4,
+			ExteriorColour:   "Oxford White",
+			InteriorColour:   "Ebony Black",
+			ExtColourGeneric: "white",
+			IntColourGeneric: "black",
+			Cylinders:        6,
+			Displacement:     2.7,
+			Fuel:             "Gasoline",
+			TransmissionType: "Automatic",
+			Drive:            "AWD",
+			Odometer:         10,
+			Price:            29999,
+			MSRP:             31509,
+			Passengers:       5,
+			InStock:          dealer.DateOf(Seeded),
+		},
+	}}
+}
+
+// Described sets what the vehicle is
+func (builder Builder) Described(year int, make, model, trim string) Builder {
+	builder.Year, builder.Make, builder.Model, builder.Trim = year, make, model, trim
+	return builder
+}
+
+// Priced sets the asking price
+func (builder Builder) Priced(price float64) Builder {
+	builder.Price = price
+	return builder
+}
+
+// Driven sets the odometer
+func (builder Builder) Driven(odometer int) Builder {
+	builder.Odometer = odometer
+	return builder
+}
+
+// Coloured sets the exterior and interior colours, and leaves the generic ones be
+func (builder Builder) Coloured(exterior, interior string) Builder {
+	builder.ExteriorColour, builder.InteriorColour = exterior, interior
+	return builder
+}
+
+// Certified makes the vehicle certified pre-owned, by program if there is one
+func (builder Builder) Certified(program string) Builder {
+	builder.FeedVehicle.Certified, builder.CertificationProgram = true, program
+	return builder
+}
+
+// Electric makes the vehicle a battery electric one: no engine, and the battery, range, charge port and motors given
+func (builder Builder) Electric(battery float64, electricRange int, port string, motors int) Builder {
+	builder.Fuel, builder.Configuration, builder.Cylinders, builder.Displacement = dealer.FuelElectric, "", 0, 0
+	builder.TransmissionType, builder.TransmissionSpeeds = dealer.TransmissionSingleSpeed, 1
+	builder.BatteryCapacity, builder.Range, builder.ChargePort, builder.Motors = battery, electricRange, port, motors
+	return builder
+}
+
+// Stocked sets the day the dealer says it arrived
+func (builder Builder) Stocked(year int, month time.Month, day int) Builder {
+	builder.InStock = dealer.Date{Year: year, Month: month, Day: day}
+	return builder
+}
+
+// OnLot moves the vehicle to another lot
+func (builder Builder) OnLot(lot dealer.Lot) Builder {
+	builder.Lot = lot
+	return builder
+}
+
+// With changes whatever else needs changing
+func (builder Builder) With(change func(*dealer.Vehicle)) Builder {
+	change(&builder.Vehicle)
+	return builder
+}
+
+// Build is the vehicle
+func (builder Builder) Build() dealer.Vehicle {
+	return builder.Vehicle
+}
@@ -1,37 +1,177 @@
 package importer
 
 import (
+	"fmt"
+	"sort"
+	"time"
+
 	"github.com/jinzhu/gorm"
 	"github.com/seamuncle/dealer"
 )
 
-// NewInventorySet does what it says on the box
-func NewInventorySet(lot dealer.Lot, db *gorm.DB) InventorySet {
-	set := InventorySet{
-		lot:      lot,
-		vehicles: map[dealer.VehicleKey]dealer.Vehicle{},
-	}
+// NewInventorySet does what it says on the box, for the lot with the master record lotID
+func NewInventorySet(lot dealer.Lot, lotID int, db *gorm.DB) InventorySet {
+	set := newInventorySet(lot, lotID)
 
-	var vehicles []*dealer.Vehicle
-	db.Where("d_id = ? AND stock_type = ?", lot.DealerID, lot.LotType).Find(&vehicles)
+	var vehicles []dealer.Vehicle
+	db.Where("lot_id = ?", lotID).Find(&vehicles)
 	for _, vehicle := range vehicles {
-		// StatePersisted is the default, but lets be explicit for clarity
-		vehicle.State = dealer.StatePersisted
-		set.SetVehicle(*vehicle)
+		set.load(vehicle)
 	}
 	return set
 }
 
-// InventorySet  uses a map of fully populated and partially populated VehicleKeys to reference Vehicle entries
-// Assumption: at least one of VIN or stock will be present for both a record and a previously persisted vehicle
+// LoadInventorySets is NewInventorySet for several lots at once, keyed by the ID of each lot's master record.
+// It's one query instead of one per lot, which adds up for a feed with a few hundred of them
+func LoadInventorySets(db *gorm.DB, lots map[int]dealer.Lot) (map[int]InventorySet, error) {
+	sets := map[int]InventorySet{}
+	if len(lots) == 0 {
+		return sets, nil
+	}
+	lotIDs := make([]int, 0, len(lots))
+	for lotID, lot := range lots {
+		sets[lotID] = newInventorySet(lot, lotID)
+		lotIDs = append(lotIDs, lotID)
+	}
+	sort.Ints(lotIDs)
+
+	// SQLite only takes so many variables in one go
+	perQuery := rowsPerStatement(maxVariables, 1)
+	for start := 0; start < len(lotIDs); start += perQuery {
+		end := start + perQuery
+		if end > len(lotIDs) {
+			end = len(lotIDs)
+		}
+		var vehicles []dealer.Vehicle
+		if err := db.Where("lot_id IN (?)", lotIDs[start:end]).Order("v_id").Find(&vehicles).Error; err != nil {
+			return nil, fmt.Errorf("Loading inventory of %d lots: %w", end-start, err)
+		}
+		for _, vehicle := range vehicles {
+			sets[vehicle.LotID].load(vehicle)
+		}
+	}
+	return sets, nil
+}
+
+// newInventorySet is an InventorySet with nothing in it yet
+func newInventorySet(lot dealer.Lot, lotID int) InventorySet {
+	return InventorySet{
+		lot:   lot,
+		lotID: lotID,
+		vehicleIndex: &vehicleIndex{
+			byID:      map[int]int{},
+			byKey:     map[dealer.VehicleKey]int{},
+			byVIN:     map[string][]int{},
+			byStock:   map[string][]int{},
+			transfers: map[int]transferSource{},
+		},
+		persisted: map[int]dealer.FeedVehicle{},
+		matcher:   DefaultMatcher(),
+	}
+}
+
+// load adds a vehicle straight out of inventory to the set
+func (set InventorySet) load(vehicle dealer.Vehicle) {
+	// Inventory only knows its lot by ID
+	vehicle.Lot = set.lot
+	// StatePersisted is the default, but lets be explicit for clarity
+	vehicle.State = dealer.StatePersisted
+	set.SetVehicle(vehicle)
+	set.persisted[vehicle.ID] = vehicle.FeedVehicle
+}
+
+// InventorySet holds every vehicle in a lot, from the database and from the feed, and indexes them by ID
+// and by fully and partially populated VehicleKeys.  A vehicle with neither a VIN nor a stock number
+// still gets a place of its own; it just can't be found by key
 type InventorySet struct {
-	lot      dealer.Lot
-	vehicles map[dealer.VehicleKey]dealer.Vehicle
+	lot   dealer.Lot
+	lotID int
+	*vehicleIndex
+	// persisted remembers what each vehicle looked like when it came out of the database,
+	// by v_id, since by the time it gets to FullReplace an altered vehicle has been overwritten
+	persisted map[int]dealer.FeedVehicle
+	matcher   Matcher
+	// batchSize is how many rows FullReplace writes per statement--0 is DefaultBatchSize
+	batchSize int
+}
+
+// vehicleIndex is the part of an InventorySet every copy of it shares--same as it would if it were only maps.
+// Vehicles keep their slot for as long as they're in the set, so the set replaces them in the order they arrived
+type vehicleIndex struct {
+	slots   []dealer.Vehicle
+	removed []bool
+	byID    map[int]int
+	byKey   map[dealer.VehicleKey]int
+	// byVIN and byStock are every slot with a given VIN (normalized the way VINMatch sees it) or stock
+	// number, in slot order--byKey only remembers the last one, which is no good to a Matcher
+	byVIN      map[string][]int
+	byStock    map[string][]int
+	ambiguous  []AmbiguousMatch
+	duplicates []Duplicate
+	suspicious []SuspiciousChange
+	pending    []PendingChange
+	// transfers are the lots transferred vehicles are coming from, by v_id
+	transfers map[int]transferSource
+}
+
+// transferSource is the lot a transferred vehicle was on before
+type transferSource struct {
+	lot   dealer.Lot
+	lotID int
+}
+
+// VehicleChange is an altered vehicle along with what was altered about it
+type VehicleChange struct {
+	Vehicle dealer.Vehicle
+	Changes []dealer.FieldChange
+}
+
+// SuspiciousChange is a feed row that changed a vehicle in a way that says it's probably not the same one
+type SuspiciousChange struct {
+	Row int
+	// Vehicle is as inventory has it, and Feed what the row says it is now
+	Vehicle   dealer.Vehicle
+	Feed      dealer.FeedVehicle
+	Anomalies []dealer.Anomaly
+	// Held is whether the vehicle was left as inventory has it
+	Held bool
+}
+
+// VehicleTransfer is a vehicle that moved onto a lot from another one, along with anything else about it that changed
+type VehicleTransfer struct {
+	Vehicle   dealer.Vehicle
+	From      dealer.Lot
+	FromLotID int
+	Changes   []dealer.FieldChange
+}
+
+// LotChanges records what a FullReplace did to the database for a single lot
+type LotChanges struct {
+	Lot     dealer.Lot
+	Added   []dealer.Vehicle
+	Changed []VehicleChange
+	Removed []dealer.Vehicle
+	// Transferred are vehicles moved onto the lot from another
+	Transferred []VehicleTransfer
+	Unaltered   int
+	// Ambiguous are feed vehicles that couldn't be told apart from more than one vehicle, and were left out
+	Ambiguous []AmbiguousMatch
+	// Duplicates are rows that repeated an earlier one, or disagreed with inventory about their keys
+	Duplicates []Duplicate
+	// Suspicious are changes that didn't look like they were to the same vehicle, held or not
+	Suspicious []SuspiciousChange
+	// Violations are every rule the lot's rows broke, whatever became of them
+	Violations []Violation
+	// Pending are changes left out of the lot and parked for review instead
+	Pending []PendingChange
+	// Held is a lot a rule said to leave alone--nothing was added, changed or removed
+	Held bool
 }
 
 // FullReplace performsa full replacement import based on the VehicleState of all of its elements
-// and then updates the database accordingly.
-func (set InventorySet) FullReplace(db *gorm.DB) error {
+// and then updates the database accordingly.  It reports back what it did.
+func (set InventorySet) FullReplace(db *gorm.DB) (LotChanges, error) {
+	result := LotChanges{Lot: set.lot, Ambiguous: set.Ambiguous(), Duplicates: set.Duplicates(), Suspicious: set.Suspicious(), Pending: set.Pending()}
 
 	// StateUnknown indeicates probably not in the database
 	Unknowns := []dealer.Vehicle{}
@@ -39,15 +179,12 @@ func (set InventorySet) FullReplace(db *gorm.DB) error {
 	Persisteds := []dealer.Vehicle{}
 	// StateAltered indicates there is a difference between a feed vehicle and its db counterpart
 	Altereds := []dealer.Vehicle{}
+	// StateTransferred indicates a vehicle that's moving here from another lot
+	Transferreds := []dealer.Vehicle{}
 
-	for key, vehicle := range set.vehicles {
-		// Dedupe vehicles referred to by synthetically partial keys
-		if key != vehicle.VehicleKey {
-			continue
-		}
-
+	for _, vehicle := range set.Vehicles() {
 		// Add vehicle to its collection according to state
-		// Note that dealer.StateUnaltered vehicles require no further action
+		// Note that dealer.StateUnaltered and dealer.StateHeld vehicles require no further action
 		switch vehicle.State {
 		case dealer.StateUnknown:
 			Unknowns = append(Unknowns, vehicle)
@@ -55,6 +192,10 @@ func (set InventorySet) FullReplace(db *gorm.DB) error {
 			Persisteds = append(Persisteds, vehicle)
 		case dealer.StateAltered:
 			Altereds = append(Altereds, vehicle)
+		case dealer.StateTransferred:
+			Transferreds = append(Transferreds, vehicle)
+		case dealer.StateUnaltered:
+			result.Unaltered++
 		}
 	}
 
@@ -62,19 +203,62 @@ func (set InventorySet) FullReplace(db *gorm.DB) error {
 	// The Unknowns should be inserted to dealer inventoryas they are unknown to the system
 	// The Persisteds should be deleted as have not been deemed Unaltered, which means they exist only in the DB
 	// The Altereds should be updated as there is some descrepency between the DB and the feed
-	for _, vehicle := range Unknowns {
-		db.Create(&vehicle)
+	// Each of them goes in batches, since a statement per vehicle is most of what a big lot costs
+	if err := insertVehicles(db, Unknowns, set.batchSize); err != nil {
+		return result, err
 	}
-	for _, vehicle := range Persisteds {
-		db.Delete(&vehicle)
+	result.Added = append(result.Added, Unknowns...)
+
+	if err := deleteVehicles(db, Persisteds, set.batchSize); err != nil {
+		return result, err
 	}
+	result.Removed = append(result.Removed, Persisteds...)
 
-	model := db.Model(&dealer.Vehicle{})
+	// Only the columns that actually changed get written--zero values included, which is the point
+	updates := make([]columnUpdate, 0, len(Altereds))
 	for _, vehicle := range Altereds {
-		model.Updates(vehicle)
+		changes := set.persisted[vehicle.ID].Diff(vehicle.FeedVehicle)
+		updates = append(updates, columnUpdate{id: vehicle.ID, columns: vehicle.Updates(changes)})
+		result.Changed = append(result.Changed, VehicleChange{Vehicle: vehicle, Changes: changes})
+	}
+	if err := updateColumns(db, updates, set.batchSize); err != nil {
+		return result, err
+	}
+
+	// A transfer is an update that happens to include lot_id--and a note of where it came from
+	updates = make([]columnUpdate, 0, len(Transferreds))
+	now := time.Now()
+	transfers := make([]dealer.Transfer, 0, len(Transferreds))
+	for _, vehicle := range Transferreds {
+		changes := set.persisted[vehicle.ID].Diff(vehicle.FeedVehicle)
+		columns := vehicle.Updates(changes)
+		columns["lot_id"] = set.lotID
+		updates = append(updates, columnUpdate{id: vehicle.ID, columns: columns})
+
+		source := set.transfers[vehicle.ID]
+		transfers = append(transfers, dealer.Transfer{
+			VehicleID:   vehicle.ID,
+			VIN:         vehicle.VIN,
+			FromLotID:   source.lotID,
+			ToLotID:     set.lotID,
+			Transferred: now,
+			TheGuilty:   vehicle.TheGuilty,
+		})
+		result.Transferred = append(result.Transferred, VehicleTransfer{
+			Vehicle:   vehicle,
+			From:      source.lot,
+			FromLotID: source.lotID,
+			Changes:   changes,
+		})
+	}
+	if err := updateColumns(db, updates, set.batchSize); err != nil {
+		return result, fmt.Errorf("Transferring vehicles: %w", err)
+	}
+	if err := insertTransfers(db, transfers, set.batchSize); err != nil {
+		return result, err
 	}
 
-	return nil
+	return result, nil
 }
 
 // Lot returns the lot asociated wtih the InventorySet
@@ -82,63 +266,279 @@ func (set InventorySet) Lot() dealer.Lot {
 	return set.lot
 }
 
-// SetVehicle adds the given vehicle to the InventorySet
+// LotID returns the ID of the lot's master record
+func (set InventorySet) LotID() int {
+	return set.lotID
+}
+
+// SetVehicle adds the given vehicle to the InventorySet, replacing the vehicle with the same ID--or failing
+// that, the same VehicleKey--if there is one
 func (set InventorySet) SetVehicle(vehicle dealer.Vehicle) {
-	// If an existing key is better, run with that
-	key := set.bestKey(vehicle.VehicleKey)
+	slot, ok := set.slotOf(vehicle)
+	if !ok {
+		slot = len(set.slots)
+		set.slots = append(set.slots, vehicle)
+		set.removed = append(set.removed, false)
+	} else {
+		set.unindex(slot)
+		set.slots[slot] = vehicle
+	}
 
-	// while the set is copied, the map is implicitly a pointer, so we can write to the original map
-	// using a copy of the set.  Yay!  Go's half-assed data protection
-	set.vehicles[key] = vehicle
-	if len(key.VIN) != 0 {
-		set.vehicles[dealer.VehicleKey{VIN: key.VIN, Stock: ""}] = vehicle
+	if vehicle.ID != 0 {
+		set.byID[vehicle.ID] = slot
 	}
-	if len(key.Stock) != 0 {
-		set.vehicles[dealer.VehicleKey{VIN: "", Stock: key.Stock}] = vehicle
+	for _, key := range indexKeys(vehicle.VehicleKey) {
+		set.byKey[key] = slot
 	}
-}
-
-// convenience method that looks for a better key in the event one passeed is partial
-func (set InventorySet) bestKey(key dealer.VehicleKey) dealer.VehicleKey {
-	best := key
-	if len(best.VIN) == 0 || len(best.Stock) == 0 {
-		if vehicle, ok := set.MatchingVehicle(key); ok {
-			// There's no promise this is actually better; but given one of the current keys is unset; it can't be worse
-			best = vehicle.VehicleKey
-		}
+	if vin := dealer.NormalizeVIN(vehicle.VIN); vin != "" {
+		set.byVIN[vin] = insertSlot(set.byVIN[vin], slot)
+	}
+	if vehicle.Stock != "" {
+		set.byStock[vehicle.Stock] = insertSlot(set.byStock[vehicle.Stock], slot)
 	}
-	return best
 }
 
 // ClearVehicle removes the given vehicle from the InventorySet
 // Turns out to be unnecessary, but completeness
 func (set InventorySet) ClearVehicle(vehicle dealer.Vehicle) {
-	key := set.bestKey(vehicle.VehicleKey)
-	// while the set is copied, the map is implicitly a pointer, so we can write to the original map
-	// using a copy of the set.  Yay!  Go's half-assed data protection
-	delete(set.vehicles, key)
-	delete(set.vehicles, dealer.VehicleKey{VIN: key.VIN, Stock: ""})
-	delete(set.vehicles, dealer.VehicleKey{VIN: "", Stock: key.Stock})
+	if slot, ok := set.slotOf(vehicle); ok {
+		set.unindex(slot)
+		set.removed[slot] = true
+	}
+}
+
+// Vehicles lists everything in the set, in the order it was added
+func (set InventorySet) Vehicles() []dealer.Vehicle {
+	if set.vehicleIndex == nil {
+		return nil
+	}
+	vehicles := make([]dealer.Vehicle, 0, len(set.slots))
+	for slot, vehicle := range set.slots {
+		if !set.removed[slot] {
+			vehicles = append(vehicles, vehicle)
+		}
+	}
+	return vehicles
 }
 
 // MatchingVehicle looks for the given vehicle in the InventorySet and if found,
 // returns true and the vehicle.  If not found the Vehicle it returns false and the
 // vehicle has a zero-vehicle characteristics
+// It's an exact lookup by key; Match is the one that tries harder
 func (set InventorySet) MatchingVehicle(key dealer.VehicleKey) (dealer.Vehicle, bool) {
-	if v, ok := set.vehicles[key]; ok {
-		return v, true
+	if slot, ok := set.slotOfKey(key); ok {
+		return set.slots[slot], true
 	}
-	// Check for vin only
-	if len(key.VIN) != 0 {
-		if v, ok := set.vehicles[dealer.VehicleKey{VIN: key.VIN, Stock: ""}]; ok {
-			return v, true
+	return dealer.Vehicle{}, false
+}
+
+// WithMatcher returns a copy of the set that matches feed vehicles with matcher
+func (set InventorySet) WithMatcher(matcher Matcher) InventorySet {
+	set.matcher = matcher
+	return set
+}
+
+// WithBatchSize returns a copy of the set that FullReplace writes size rows per statement at most.
+// A size of 1 is a statement per vehicle, same as it always used to be
+func (set InventorySet) WithBatchSize(size int) InventorySet {
+	set.batchSize = size
+	return set
+}
+
+// Match looks for whichever vehicle in the set a feed vehicle is, using the set's Matcher
+func (set InventorySet) Match(vehicle dealer.FeedVehicle) MatchResult {
+	return set.matcher.Match(vehicle, set)
+}
+
+// All is every vehicle in the set, so an InventorySet is Candidates for its own Matcher
+func (set InventorySet) All() []dealer.Vehicle {
+	return set.Vehicles()
+}
+
+// WithVIN is every vehicle in the set with the same VIN, give or take case and whitespace
+func (set InventorySet) WithVIN(vin string) []dealer.Vehicle {
+	if set.vehicleIndex == nil {
+		return nil
+	}
+	return set.inSlots(set.byVIN[dealer.NormalizeVIN(vin)])
+}
+
+// WithStock is every vehicle in the set with exactly the same stock number
+func (set InventorySet) WithStock(stock string) []dealer.Vehicle {
+	if set.vehicleIndex == nil {
+		return nil
+	}
+	return set.inSlots(set.byStock[stock])
+}
+
+// inSlots is the vehicles in the given slots
+func (set InventorySet) inSlots(slots []int) []dealer.Vehicle {
+	vehicles := make([]dealer.Vehicle, 0, len(slots))
+	for _, slot := range slots {
+		vehicles = append(vehicles, set.slots[slot])
+	}
+	return vehicles
+}
+
+// Hold leaves the candidates of an ambiguous match as they are, so a feed vehicle that could have been
+// any of them doesn't get to change or delete one of them by guesswork--and remembers it for LotChanges
+func (set InventorySet) Hold(vehicle dealer.FeedVehicle, candidates []Match) {
+	for _, candidate := range candidates {
+		held := candidate.Vehicle
+		if held.State == dealer.StatePersisted {
+			held.State = dealer.StateHeld
+			set.SetVehicle(held)
+		}
+	}
+	set.ambiguous = append(set.ambiguous, AmbiguousMatch{Vehicle: vehicle, Candidates: candidates})
+}
+
+// Reject leaves whatever a feed vehicle matched as inventory has it, the same as Hold does for an ambiguous match--
+// a vehicle the feed wasn't trusted with shouldn't be deleted for not being in it
+func (set InventorySet) Reject(match MatchResult) {
+	if match.Found && match.Vehicle.State == dealer.StatePersisted {
+		held := match.Vehicle
+		held.State = dealer.StateHeld
+		set.SetVehicle(held)
+	}
+}
+
+// Ambiguous lists every feed vehicle Hold has been given
+func (set InventorySet) Ambiguous() []AmbiguousMatch {
+	if set.vehicleIndex == nil {
+		return nil
+	}
+	return set.ambiguous
+}
+
+// Transfer brings a vehicle from another lot into the set, ready for a feed vehicle to be matched to it.
+// It returns the vehicle as it now belongs to this lot
+func (set InventorySet) Transfer(vehicle dealer.Vehicle, from dealer.Lot) dealer.Vehicle {
+	set.transfers[vehicle.ID] = transferSource{lot: from, lotID: vehicle.LotID}
+	set.persisted[vehicle.ID] = vehicle.FeedVehicle
+
+	vehicle.Lot = set.lot
+	vehicle.LotID = set.lotID
+	vehicle.State = dealer.StateTransferred
+	set.SetVehicle(vehicle)
+	return vehicle
+}
+
+// ReportDuplicate remembers a duplicate or conflicting row for LotChanges
+func (set InventorySet) ReportDuplicate(duplicate Duplicate) {
+	set.duplicates = append(set.duplicates, duplicate)
+}
+
+// Duplicates lists every row ReportDuplicate has been given
+func (set InventorySet) Duplicates() []Duplicate {
+	if set.vehicleIndex == nil {
+		return nil
+	}
+	return set.duplicates
+}
+
+// ReportSuspicious remembers a change with anomalies for LotChanges
+func (set InventorySet) ReportSuspicious(change SuspiciousChange) {
+	set.suspicious = append(set.suspicious, change)
+}
+
+// Suspicious lists every change ReportSuspicious has been given
+func (set InventorySet) Suspicious() []SuspiciousChange {
+	if set.vehicleIndex == nil {
+		return nil
+	}
+	return set.suspicious
+}
+
+// Park keeps a change the runner left out, to be written to pending_changes along with the lot
+func (set InventorySet) Park(change PendingChange) {
+	set.pending = append(set.pending, change)
+}
+
+// Pending lists every change Park has been given
+func (set InventorySet) Pending() []PendingChange {
+	if set.vehicleIndex == nil {
+		return nil
+	}
+	return set.pending
+}
+
+// slotOf finds the slot a vehicle is in: by ID if it has been persisted, otherwise by key
+func (set InventorySet) slotOf(vehicle dealer.Vehicle) (int, bool) {
+	if vehicle.ID != 0 {
+		slot, ok := set.byID[vehicle.ID]
+		return slot, ok
+	}
+	return set.slotOfKey(vehicle.VehicleKey)
+}
+
+// slotOfKey looks a key up as it is, then by VIN alone, then by stock alone
+func (set InventorySet) slotOfKey(key dealer.VehicleKey) (int, bool) {
+	if set.vehicleIndex == nil {
+		return 0, false
+	}
+	for _, candidate := range indexKeys(key) {
+		if slot, ok := set.byKey[candidate]; ok {
+			return slot, true
 		}
 	}
-	// Check for stock only
-	if len(key.Stock) != 0 {
-		if v, ok := set.vehicles[dealer.VehicleKey{VIN: "", Stock: key.Stock}]; ok {
-			return v, ok
+	return 0, false
+}
+
+// unindex forgets every index entry pointing at slot--ones pointing at other vehicles that happen to
+// share a partial key are left alone
+func (set InventorySet) unindex(slot int) {
+	vehicle := set.slots[slot]
+	if set.byID[vehicle.ID] == slot {
+		delete(set.byID, vehicle.ID)
+	}
+	for _, key := range indexKeys(vehicle.VehicleKey) {
+		if existing, ok := set.byKey[key]; ok && existing == slot {
+			delete(set.byKey, key)
 		}
 	}
-	return dealer.Vehicle{}, false
+	if vin := dealer.NormalizeVIN(vehicle.VIN); vin != "" {
+		set.byVIN[vin] = removeSlot(set.byVIN[vin], slot)
+	}
+	if vehicle.Stock != "" {
+		set.byStock[vehicle.Stock] = removeSlot(set.byStock[vehicle.Stock], slot)
+	}
+}
+
+// insertSlot adds slot to a list of them, keeping it in order, so lookups find vehicles in the order
+// they arrived--the same order a Matcher going through every vehicle would
+func insertSlot(slots []int, slot int) []int {
+	i := sort.SearchInts(slots, slot)
+	if i < len(slots) && slots[i] == slot {
+		return slots
+	}
+	slots = append(slots, 0)
+	copy(slots[i+1:], slots[i:])
+	slots[i] = slot
+	return slots
+}
+
+// removeSlot takes slot out of a list of them, if it's there
+func removeSlot(slots []int, slot int) []int {
+	i := sort.SearchInts(slots, slot)
+	if i == len(slots) || slots[i] != slot {
+		return slots
+	}
+	return append(slots[:i], slots[i+1:]...)
+}
+
+// indexKeys are the keys a vehicle is indexed by: the whole key, then the synthetically partial ones.
+// A vehicle with neither VIN nor stock has none
+func indexKeys(key dealer.VehicleKey) []dealer.VehicleKey {
+	keys := []dealer.VehicleKey{}
+	if key.VIN != "" && key.Stock != "" {
+		keys = append(keys, key)
+	}
+	if key.VIN != "" {
+		keys = append(keys, dealer.VehicleKey{VIN: key.VIN})
+	}
+	if key.Stock != "" {
+		keys = append(keys, dealer.VehicleKey{Stock: key.Stock})
+	}
+	return keys
 }
@@ -0,0 +1,89 @@
+package importer
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Logger writes structured log lines as JSON objects, one per line, carrying whatever context it was
+// built up with--run ID, feed, lot, row--so a line makes sense on its own once it's in a log aggregator.
+// The zero Logger throws everything away, so nothing has to check whether there is one
+type Logger struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	fields []interface{}
+}
+
+// NewLogger returns a Logger writing to w
+func NewLogger(w io.Writer) Logger {
+	return Logger{w: w, mu: &sync.Mutex{}}
+}
+
+// With returns a Logger that adds the given key/value pairs to every line it writes
+func (logger Logger) With(keyvals ...interface{}) Logger {
+	// Copy, so loggers derived from the same parent don't trample each other's context
+	fields := make([]interface{}, 0, len(logger.fields)+len(keyvals))
+	logger.fields = append(append(fields, logger.fields...), keyvals...)
+	return logger
+}
+
+// Info logs something worth knowing
+func (logger Logger) Info(msg string, keyvals ...interface{}) {
+	logger.log("info", msg, keyvals)
+}
+
+// Warn logs something worth looking into
+func (logger Logger) Warn(msg string, keyvals ...interface{}) {
+	logger.log("warn", msg, keyvals)
+}
+
+// Error logs something that went wrong, along with what it was
+func (logger Logger) Error(msg string, err error, keyvals ...interface{}) {
+	logger.log("error", msg, append([]interface{}{"error", err.Error()}, keyvals...))
+}
+
+// log writes a line--keys keep the order they were given in, which is why this doesn't just marshal a map
+func (logger Logger) log(level, msg string, keyvals []interface{}) {
+	if logger.w == nil {
+		return
+	}
+
+	b := []byte(`{"time":`)
+	b = appendJSON(b, time.Now().UTC().Format(time.RFC3339Nano))
+	b = append(b, `,"level":`...)
+	b = appendJSON(b, level)
+	b = append(b, `,"msg":`...)
+	b = appendJSON(b, msg)
+
+	all := append(append([]interface{}{}, logger.fields...), keyvals...)
+	for i := 0; i+1 < len(all); i += 2 {
+		key, ok := all[i].(string)
+		if !ok {
+			continue
+		}
+		b = append(b, ',')
+		b = appendJSON(b, key)
+		b = append(b, ':')
+		value := all[i+1]
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		b = appendJSON(b, value)
+	}
+	b = append(b, '}', '\n')
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.w.Write(b)
+}
+
+// appendJSON appends the JSON encoding of value, or of its string form if it won't encode
+func appendJSON(b []byte, value interface{}) []byte {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(err.Error())
+	}
+	return append(b, encoded...)
+}
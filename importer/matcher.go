@@ -0,0 +1,314 @@
+package importer
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/seamuncle/dealer"
+)
+
+// MatchStrategy is one way of deciding whether a vehicle in a feed is a vehicle we already have.
+// Score returns how confident it is that vehicle is candidate, from 0 (it isn't) to 1 (it certainly is)
+type MatchStrategy interface {
+	Name() string
+	Score(vehicle dealer.FeedVehicle, candidate dealer.Vehicle) float64
+}
+
+// Match is a candidate a MatchStrategy thinks a feed vehicle might be
+type Match struct {
+	Vehicle    dealer.Vehicle
+	Strategy   string
+	Confidence float64
+}
+
+// MatchResult is what a Matcher made of a feed vehicle: a match if it found exactly one good one,
+// or every candidate it couldn't choose between if it found several
+type MatchResult struct {
+	Match
+	Found     bool
+	Ambiguous []Match
+}
+
+// AmbiguousMatch is a feed vehicle that could have been any of several vehicles--nobody gets picked,
+// the candidates are left as they are, and it's reported for a human to sort out
+type AmbiguousMatch struct {
+	Vehicle    dealer.FeedVehicle
+	Candidates []Match
+}
+
+// Matcher tries each of its strategies in turn until one of them finds something.  A strategy finding
+// two or more candidates too close to call is reported as ambiguous rather than guessed at
+type Matcher struct {
+	Strategies []MatchStrategy
+	// MinConfidence is the least a strategy has to be sure of a candidate for it to count
+	MinConfidence float64
+	// AmbiguityMargin is how much better than the runner up the best candidate has to be--0 only
+	// calls a tie ambiguous
+	AmbiguityMargin float64
+}
+
+// DefaultMatcher is what an InventorySet matches with unless it's told otherwise: the exact keys first,
+// then the near misses, and as a last resort, a vehicle that looks the same
+func DefaultMatcher() Matcher {
+	return Matcher{
+		Strategies: []MatchStrategy{
+			VINMatch{},
+			StockMatch{},
+			VINSuffixMatch{Length: 8},
+			NormalizedStockMatch{},
+			AttributeMatch{},
+		},
+		MinConfidence:   0.5,
+		AmbiguityMargin: 0.05,
+	}
+}
+
+// Candidates is whatever a Matcher is looking through: every vehicle, for the strategies that have to look at
+// all of them, or only the ones with a given VIN or stock number, for the ones that don't
+type Candidates interface {
+	All() []dealer.Vehicle
+	WithVIN(vin string) []dealer.Vehicle
+	WithStock(stock string) []dealer.Vehicle
+}
+
+// KeyedStrategy is a MatchStrategy that only ever scores vehicles sharing a key with the feed vehicle,
+// so it can say which ones those are instead of scoring every vehicle on the lot
+type KeyedStrategy interface {
+	MatchStrategy
+	Candidates(vehicle dealer.FeedVehicle, candidates Candidates) []dealer.Vehicle
+}
+
+// VehicleList is Candidates that are only a list--every lookup is a scan
+type VehicleList []dealer.Vehicle
+
+// All is the whole list
+func (list VehicleList) All() []dealer.Vehicle { return list }
+
+// WithVIN is every vehicle in the list with the same VIN, give or take case and whitespace
+func (list VehicleList) WithVIN(vin string) []dealer.Vehicle {
+	vehicles := []dealer.Vehicle{}
+	for _, vehicle := range list {
+		if dealer.NormalizeVIN(vehicle.VIN) == dealer.NormalizeVIN(vin) {
+			vehicles = append(vehicles, vehicle)
+		}
+	}
+	return vehicles
+}
+
+// WithStock is every vehicle in the list with exactly the same stock number
+func (list VehicleList) WithStock(stock string) []dealer.Vehicle {
+	vehicles := []dealer.Vehicle{}
+	for _, vehicle := range list {
+		if vehicle.Stock == stock {
+			vehicles = append(vehicles, vehicle)
+		}
+	}
+	return vehicles
+}
+
+// Match looks for vehicle among candidates.  Keyed strategies only score what their keys look up;
+// the rest get every candidate, which is only listed if it comes to that
+func (matcher Matcher) Match(vehicle dealer.FeedVehicle, candidates Candidates) MatchResult {
+	var all []dealer.Vehicle
+	for _, strategy := range matcher.Strategies {
+		var scored []dealer.Vehicle
+		if keyed, ok := strategy.(KeyedStrategy); ok {
+			scored = keyed.Candidates(vehicle, candidates)
+		} else {
+			if all == nil {
+				all = candidates.All()
+			}
+			scored = all
+		}
+
+		matches := []Match{}
+		for _, candidate := range scored {
+			confidence := strategy.Score(vehicle, candidate)
+			if confidence > 0 && confidence >= matcher.MinConfidence {
+				matches = append(matches, Match{Vehicle: candidate, Strategy: strategy.Name(), Confidence: confidence})
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+
+		sort.SliceStable(matches, func(i, j int) bool { return matches[i].Confidence > matches[j].Confidence })
+		tied := 1
+		for tied < len(matches) && matches[0].Confidence-matches[tied].Confidence <= matcher.AmbiguityMargin {
+			tied++
+		}
+		if tied > 1 {
+			return MatchResult{Ambiguous: matches[:tied]}
+		}
+		return MatchResult{Match: matches[0], Found: true}
+	}
+	return MatchResult{}
+}
+
+// unclaimed is whether a candidate is still only what the database said--the inexact strategies
+// leave alone anything the feed has already matched or added, so two rows can't fight over a vehicle
+func unclaimed(candidate dealer.Vehicle) bool {
+	return candidate.State == dealer.StatePersisted
+}
+
+// VINMatch matches on the whole VIN, give or take case and whitespace
+type VINMatch struct{}
+
+// Name identifies the strategy in logs and metrics
+func (VINMatch) Name() string { return "vin" }
+
+// Candidates are the vehicles with the same VIN
+func (VINMatch) Candidates(vehicle dealer.FeedVehicle, candidates Candidates) []dealer.Vehicle {
+	if dealer.NormalizeVIN(vehicle.VIN) == "" {
+		return nil
+	}
+	return candidates.WithVIN(vehicle.VIN)
+}
+
+// Score is certain when the VINs are the same, and the stock numbers don't say otherwise--
+// which is what tells apart two rows that somehow ended up with the same VIN.  A vehicle that isn't in inventory
+// yet is an earlier row's, and that's for duplicate handling to sort out, not matching
+func (VINMatch) Score(vehicle dealer.FeedVehicle, candidate dealer.Vehicle) float64 {
+	vin := dealer.NormalizeVIN(vehicle.VIN)
+	if candidate.ID == 0 || vin == "" || vin != dealer.NormalizeVIN(candidate.VIN) {
+		return 0
+	}
+	if vehicle.Stock != "" && candidate.Stock != "" && vehicle.Stock != candidate.Stock {
+		return 0.9
+	}
+	return 1
+}
+
+// StockMatch matches on the stock number exactly.  Dealers do reuse stock numbers, so it's less sure
+// of itself when both vehicles have a VIN and they disagree
+type StockMatch struct{}
+
+// Name identifies the strategy in logs and metrics
+func (StockMatch) Name() string { return "stock" }
+
+// Candidates are the vehicles with the same stock number
+func (StockMatch) Candidates(vehicle dealer.FeedVehicle, candidates Candidates) []dealer.Vehicle {
+	if vehicle.Stock == "" {
+		return nil
+	}
+	return candidates.WithStock(vehicle.Stock)
+}
+
+// Score is fairly sure when the stock numbers are the same--for vehicles in inventory, same as VINMatch
+func (StockMatch) Score(vehicle dealer.FeedVehicle, candidate dealer.Vehicle) float64 {
+	if candidate.ID == 0 || vehicle.Stock == "" || vehicle.Stock != candidate.Stock {
+		return 0
+	}
+	if vinsDisagree(vehicle, candidate.FeedVehicle) {
+		return 0.6
+	}
+	return 0.9
+}
+
+// VINSuffixMatch matches on the last Length characters of the VIN--the model year, plant and serial number,
+// which is what's left when a VIN has been mistyped at the front or truncated to fit a form
+type VINSuffixMatch struct {
+	Length int
+}
+
+// Name identifies the strategy in logs and metrics
+func (VINSuffixMatch) Name() string { return "vin_suffix" }
+
+// Score is reasonably sure when the ends of the VINs are the same
+func (strategy VINSuffixMatch) Score(vehicle dealer.FeedVehicle, candidate dealer.Vehicle) float64 {
+	vin, candidateVIN := dealer.NormalizeVIN(vehicle.VIN), dealer.NormalizeVIN(candidate.VIN)
+	if !unclaimed(candidate) || len(vin) < strategy.Length || len(candidateVIN) < strategy.Length {
+		return 0
+	}
+	if vin[len(vin)-strategy.Length:] == candidateVIN[len(candidateVIN)-strategy.Length:] {
+		return 0.8
+	}
+	return 0
+}
+
+// NormalizedStockMatch matches on stock numbers that only differ in case, whitespace and leading zeros--
+// "a0124 " and "A124" are the same stock number to whoever typed them
+type NormalizedStockMatch struct{}
+
+// Name identifies the strategy in logs and metrics
+func (NormalizedStockMatch) Name() string { return "normalized_stock" }
+
+// Score is somewhat sure when the stock numbers are the same once they're cleaned up
+func (NormalizedStockMatch) Score(vehicle dealer.FeedVehicle, candidate dealer.Vehicle) float64 {
+	stock := normalizeStock(vehicle.Stock)
+	if !unclaimed(candidate) || stock == "" || stock != normalizeStock(candidate.Stock) {
+		return 0
+	}
+	if vinsDisagree(vehicle, candidate.FeedVehicle) {
+		return 0.5
+	}
+	return 0.75
+}
+
+// AttributeMatch is the last resort for a vehicle missing the keys that would have found it: same year,
+// make and model, and a colour that doesn't rule it out.  It never overrules a VIN or stock number that disagrees
+type AttributeMatch struct{}
+
+// Name identifies the strategy in logs and metrics
+func (AttributeMatch) Name() string { return "attributes" }
+
+// Score is only ever a little sure--lots have more than one white Camry
+func (AttributeMatch) Score(vehicle dealer.FeedVehicle, candidate dealer.Vehicle) float64 {
+	if !unclaimed(candidate) || vinsDisagree(vehicle, candidate.FeedVehicle) {
+		return 0
+	}
+	if vehicle.Stock != "" && candidate.Stock != "" && normalizeStock(vehicle.Stock) != normalizeStock(candidate.Stock) {
+		return 0
+	}
+	if vehicle.Year == 0 || vehicle.Year != candidate.Year ||
+		!sameWords(vehicle.Make, candidate.Make) || !sameWords(vehicle.Model, candidate.Model) ||
+		vehicle.Make == "" || vehicle.Model == "" {
+		return 0
+	}
+
+	colour, candidateColour := vehicle.ExteriorColour, candidate.ExteriorColour
+	switch {
+	case colour == "" || candidateColour == "":
+		return 0.5
+	case sameWords(colour, candidateColour):
+		return 0.6
+	}
+	return 0
+}
+
+// vinsDisagree is whether both vehicles have a VIN, and they're different
+func vinsDisagree(vehicle, other dealer.FeedVehicle) bool {
+	vin, otherVIN := dealer.NormalizeVIN(vehicle.VIN), dealer.NormalizeVIN(other.VIN)
+	return vin != "" && otherVIN != "" && vin != otherVIN
+}
+
+// normalizeStock upper-cases a stock number, drops whitespace, and drops the leading zeros of every run
+// of digits--so "a0124" becomes "A124"
+func normalizeStock(stock string) string {
+	var b strings.Builder
+	inNumber := false
+	for _, r := range strings.ToUpper(stock) {
+		switch {
+		case unicode.IsSpace(r):
+			continue
+		case r == '0' && !inNumber:
+			continue
+		case unicode.IsDigit(r):
+			inNumber = true
+		default:
+			inNumber = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 && strings.ContainsRune(stock, '0') {
+		// All zeros is still a stock number
+		return "0"
+	}
+	return b.String()
+}
+
+// sameWords compares two bits of free text without regard to case or spacing
+func sameWords(a, b string) bool {
+	return strings.EqualFold(strings.Join(strings.Fields(a), " "), strings.Join(strings.Fields(b), " "))
+}
@@ -0,0 +1,114 @@
+package importer_test
+
+import (
+	"testing"
+
+	"github.com/seamuncle/dealer"
+	"github.com/seamuncle/dealer/importer"
+)
+
+// persisted is a vehicle as inventory has it, before the feed has had anything to say about it
+func persisted(id int, stock, vin string, year int, make, model, colour string) dealer.Vehicle {
+	return dealer.Vehicle{ID: id, State: dealer.StatePersisted, FeedVehicle: dealer.FeedVehicle{
+		VehicleKey: dealer.VehicleKey{VIN: vin, Stock: stock}, Year: year, Make: make, Model: model, ExteriorColour: colour,
+	}}
+}
+
+// feedVehicle is a vehicle as a feed describes it
+func feedVehicle(stock, vin string, year int, make, model, colour string) dealer.FeedVehicle {
+	return persisted(0, stock, vin, year, make, model, colour).FeedVehicle
+}
+
+func TestMatchStrategies(t *testing.T) {
+	fusion := persisted(1, "A124", "1GCEP22T1G3329139", 2018, "Ford", "Fusion", "White")
+	// Added by an earlier row of the same feed, so it isn't in inventory yet
+	added := fusion
+	added.ID, added.State = 0, dealer.StateUnknown
+	// Matched by an earlier row already
+	claimed := fusion
+	claimed.State = dealer.StateAltered
+
+	for _, test := range []struct {
+		name      string
+		strategy  importer.MatchStrategy
+		vehicle   dealer.FeedVehicle
+		candidate dealer.Vehicle
+		want      float64
+	}{
+		{"same VIN", importer.VINMatch{}, feedVehicle("A124", "1GCEP22T1G3329139", 0, "", "", ""), fusion, 1},
+		{"same VIN, any case or spacing", importer.VINMatch{}, feedVehicle("", " 1gcep22t1g3329139", 0, "", "", ""), fusion, 1},
+		{"same VIN, other stock", importer.VINMatch{}, feedVehicle("A999", "1GCEP22T1G3329139", 0, "", "", ""), fusion, 0.9},
+		{"other VIN", importer.VINMatch{}, feedVehicle("A124", "1GCEP22T1G3329130", 0, "", "", ""), fusion, 0},
+		{"no VIN", importer.VINMatch{}, feedVehicle("A124", "", 0, "", "", ""), fusion, 0},
+		{"VIN of a vehicle not saved yet", importer.VINMatch{}, feedVehicle("A124", "1GCEP22T1G3329139", 0, "", "", ""), added, 0},
+
+		{"same stock", importer.StockMatch{}, feedVehicle("A124", "", 0, "", "", ""), fusion, 0.9},
+		{"same stock, other VIN", importer.StockMatch{}, feedVehicle("A124", "KM8SB12B02U162029", 0, "", "", ""), fusion, 0.6},
+		{"stock in another case", importer.StockMatch{}, feedVehicle("a124", "", 0, "", "", ""), fusion, 0},
+		{"stock of a vehicle not saved yet", importer.StockMatch{}, feedVehicle("A124", "", 0, "", "", ""), added, 0},
+
+		{"end of the VIN", importer.VINSuffixMatch{Length: 8}, feedVehicle("", "XXXXXXXXXG3329139", 0, "", "", ""), fusion, 0.8},
+		{"end of the VIN, other ending", importer.VINSuffixMatch{Length: 8}, feedVehicle("", "1GCEP22T1G3329130", 0, "", "", ""), fusion, 0},
+		{"too short to say", importer.VINSuffixMatch{Length: 8}, feedVehicle("", "3329139", 0, "", "", ""), fusion, 0},
+		{"end of a claimed VIN", importer.VINSuffixMatch{Length: 8}, feedVehicle("", "XXXXXXXXXG3329139", 0, "", "", ""), claimed, 0},
+
+		{"stock tidied up", importer.NormalizedStockMatch{}, feedVehicle(" a0124", "", 0, "", "", ""), fusion, 0.75},
+		{"stock tidied up, other VIN", importer.NormalizedStockMatch{}, feedVehicle("a0124", "KM8SB12B02U162029", 0, "", "", ""), fusion, 0.5},
+		{"other stock tidied up", importer.NormalizedStockMatch{}, feedVehicle("A1240", "", 0, "", "", ""), fusion, 0},
+		{"claimed stock tidied up", importer.NormalizedStockMatch{}, feedVehicle("a0124", "", 0, "", "", ""), claimed, 0},
+
+		{"looks the same", importer.AttributeMatch{}, feedVehicle("", "", 2018, "FORD", "fusion", " white"), fusion, 0.6},
+		{"looks the same, no colour", importer.AttributeMatch{}, feedVehicle("", "", 2018, "Ford", "Fusion", ""), fusion, 0.5},
+		{"other colour", importer.AttributeMatch{}, feedVehicle("", "", 2018, "Ford", "Fusion", "Red"), fusion, 0},
+		{"other year", importer.AttributeMatch{}, feedVehicle("", "", 2017, "Ford", "Fusion", "White"), fusion, 0},
+		{"looks the same, other VIN", importer.AttributeMatch{}, feedVehicle("", "KM8SB12B02U162029", 2018, "Ford", "Fusion", "White"), fusion, 0},
+		{"looks the same, other stock", importer.AttributeMatch{}, feedVehicle("B105", "", 2018, "Ford", "Fusion", "White"), fusion, 0},
+		{"looks the same, claimed", importer.AttributeMatch{}, feedVehicle("", "", 2018, "Ford", "Fusion", "White"), claimed, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.strategy.Score(test.vehicle, test.candidate); got != test.want {
+				t.Errorf("%s scores %g, want %g", test.strategy.Name(), got, test.want)
+			}
+		})
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	fusion := persisted(1, "A124", "1GCEP22T1G3329139", 2018, "Ford", "Fusion", "White")
+	focus := persisted(2, "A130", "1FADP3F20JL222222", 2018, "Ford", "Focus", "White")
+	// Another white Fusion, and the same stock number again with a different VIN
+	twin := persisted(3, "A125", "3FA6P0HD2JR000002", 2018, "Ford", "Fusion", "")
+	reused := persisted(4, "A124", "KM8SB12B02U162029", 2014, "Ford", "Mustang", "Red")
+
+	for _, test := range []struct {
+		name       string
+		vehicle    dealer.FeedVehicle
+		candidates importer.VehicleList
+		margin     float64
+		strategy   string
+		id         int
+		ambiguous  int
+	}{
+		{"VIN first", feedVehicle("A124", "1GCEP22T1G3329139", 0, "", "", ""), importer.VehicleList{focus, fusion, reused}, 0.05, "vin", 1, 0},
+		{"stock when there's no VIN", feedVehicle("A130", "", 0, "", "", ""), importer.VehicleList{fusion, focus}, 0.05, "stock", 2, 0},
+		{"nothing", feedVehicle("Z999", "2FMDK3KC0ABA00001", 2018, "Ford", "Edge", ""), importer.VehicleList{fusion, focus}, 0.05, "", 0, 0},
+		// Both white-ish Fusions score within the margin, so neither is picked
+		{"too close to call", feedVehicle("", "", 2018, "Ford", "Fusion", ""), importer.VehicleList{fusion, twin}, 0.05, "", 0, 2},
+		{"same stock twice", feedVehicle("A124", "", 0, "", "", ""), importer.VehicleList{fusion, reused}, 0.05, "", 0, 2},
+		// With no margin only a tie is ambiguous, and 0.6 beats 0.5
+		{"close, but not a tie", feedVehicle("", "", 2018, "Ford", "Fusion", "White"), importer.VehicleList{fusion, twin}, 0, "attributes", 1, 0},
+		{"close, within a wider margin", feedVehicle("", "", 2018, "Ford", "Fusion", "White"), importer.VehicleList{fusion, twin}, 0.1, "", 0, 2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			matcher := importer.DefaultMatcher()
+			matcher.AmbiguityMargin = test.margin
+			result := matcher.Match(test.vehicle, test.candidates)
+			if result.Found != (test.id != 0) || result.Vehicle.ID != test.id || result.Strategy != test.strategy {
+				t.Errorf("Matched %d by %q (found %t), want %d by %q", result.Vehicle.ID, result.Strategy, result.Found, test.id, test.strategy)
+			}
+			if len(result.Ambiguous) != test.ambiguous {
+				t.Errorf("%d ambiguous candidates, want %d", len(result.Ambiguous), test.ambiguous)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricKinds describes every metric a FullReplaceRunner records: its Prometheus type and help text
+var metricKinds = map[string][2]string{
+	"dealer_import_records_read_total":           {"counter", "Records loaded from a feed"},
+	"dealer_import_records_rejected_total":       {"counter", "Records an importer could not process"},
+	"dealer_import_vehicles_inserted_total":      {"counter", "Vehicles inserted into inventory"},
+	"dealer_import_vehicles_updated_total":       {"counter", "Vehicles updated in inventory"},
+	"dealer_import_vehicles_deleted_total":       {"counter", "Vehicles deleted from inventory"},
+	"dealer_import_vehicles_transferred_total":   {"counter", "Vehicles moved onto a lot from another"},
+	"dealer_import_vehicles_unaltered_total":     {"counter", "Vehicles in a feed matching inventory exactly"},
+	"dealer_import_runs_total":                   {"counter", "Import runs, by result"},
+	"dealer_import_phase_duration_seconds":       {"gauge", "How long each phase of the last run took"},
+	"dealer_import_lot_duration_seconds":         {"gauge", "How long the last replacement of each lot took"},
+	"dealer_import_last_run_timestamp_seconds":   {"gauge", "When the last run finished, in seconds since the epoch"},
+	"dealer_import_last_run_success":             {"gauge", "Whether the last run succeeded"},
+	"dealer_import_last_run_duration_seconds":    {"gauge", "How long the last run took from start to finish"},
+	"dealer_import_dealer_name_mismatches_total": {"counter", "Lots in a feed whose dealer name differs from the master record"},
+	"dealer_import_matches_total":                {"counter", "Feed vehicles by the strategy that matched them to inventory--none, or ambiguous"},
+	"dealer_import_duplicates_total":             {"counter", "Rows repeating an earlier row of their lot, or conflicting with inventory, by kind"},
+	"dealer_import_multi_dealer_claims_total":    {"counter", "VINs a feed lists on more than one dealer's lots"},
+	"dealer_import_lot_type_warnings_total":      {"counter", "Vehicles that don't fit what's expected of their lot type"},
+	"dealer_import_outbox_delivered_total":       {"counter", "Outbox messages delivered"},
+	"dealer_import_outbox_delivery_errors_total": {"counter", "Outbox deliveries that failed"},
+}
+
+// Metrics collects counters and gauges about imports and writes them out in the Prometheus text format--
+// served over HTTP, written to a file for node_exporter's textfile collector, or pushed to a Pushgateway.
+// A nil *Metrics records nothing, so nothing has to check whether there is one
+type Metrics struct {
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+// metricSeries is a single metric with a single set of labels
+type metricSeries struct {
+	name   string
+	labels string
+	value  float64
+}
+
+// NewMetrics returns an empty Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{series: map[string]*metricSeries{}}
+}
+
+// Add adds value to a counter.  labels are name/value pairs
+func (metrics *Metrics) Add(name string, value float64, labels ...string) {
+	if metrics == nil {
+		return
+	}
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	metrics.get(name, labels).value += value
+}
+
+// Set sets a gauge.  labels are name/value pairs
+func (metrics *Metrics) Set(name string, value float64, labels ...string) {
+	if metrics == nil {
+		return
+	}
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	metrics.get(name, labels).value = value
+}
+
+// get finds or creates a series, must be called holding the lock
+func (metrics *Metrics) get(name string, labels []string) *metricSeries {
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	formatted := ""
+	if len(pairs) > 0 {
+		formatted = "{" + strings.Join(pairs, ",") + "}"
+	}
+
+	key := name + formatted
+	series, ok := metrics.series[key]
+	if !ok {
+		series = &metricSeries{name: name, labels: formatted}
+		metrics.series[key] = series
+	}
+	return series
+}
+
+// WriteTo writes every series in the Prometheus text exposition format, grouped by metric
+func (metrics *Metrics) WriteTo(w io.Writer) (int64, error) {
+	if metrics == nil {
+		return 0, nil
+	}
+	metrics.mu.Lock()
+	keys := make([]string, 0, len(metrics.series))
+	for key := range metrics.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	last := ""
+	for _, key := range keys {
+		series := metrics.series[key]
+		if series.name != last {
+			kind := metricKinds[series.name]
+			fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", series.name, kind[1], series.name, kind[0])
+			last = series.name
+		}
+		fmt.Fprintf(&buf, "%s%s %g\n", series.name, series.labels, series.value)
+	}
+	metrics.mu.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP makes Metrics a scrape endpoint
+func (metrics *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.WriteTo(w)
+}
+
+// WriteTextfile writes the metrics to path for node_exporter's textfile collector--via a temp file
+// and a rename, since the collector might read it at any moment
+func (metrics *Metrics) WriteTextfile(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".metrics-")
+	if err != nil {
+		return fmt.Errorf("Creating metrics temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = metrics.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Writing metrics: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("Closing metrics temp file: %w", err)
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("Setting metrics file permissions: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("Moving metrics into %s: %w", path, err)
+	}
+	return nil
+}
+
+// Push replaces everything a Pushgateway at url holds for job with these metrics
+func (metrics *Metrics) Push(url, job string) error {
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+
+	target := strings.TrimSuffix(url, "/") + "/metrics/job/" + job
+	req, err := http.NewRequest(http.MethodPut, target, &buf)
+	if err != nil {
+		return fmt.Errorf("Creating push request to %s: %w", target, err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Pushing metrics to %s: %w", target, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Pushing metrics to %s: %s", target, resp.Status)
+	}
+	return nil
+}
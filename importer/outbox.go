@@ -0,0 +1,238 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// OutboxMessage is an event waiting in the inventory_outbox table to be delivered.
+// It gets written in the same transaction as the inventory change it describes, so
+// if the change happened, so did the message--and vice versa
+type OutboxMessage struct {
+	ID        int        `gorm:"column:id;primary_key"`
+	EventID   string     `gorm:"column:event_id;type:varchar(32);unique_index"`
+	EventType string     `gorm:"column:event_type;type:varchar(32)"`
+	RunID     string     `gorm:"column:run_id;type:varchar(32)"`
+	Payload   string     `gorm:"column:payload;type:text"`
+	Created   time.Time  `gorm:"column:created_time"`
+	Attempts  int        `gorm:"column:attempts"`
+	LastError string     `gorm:"column:last_error;type:varchar(255)"`
+	Delivered *time.Time `gorm:"column:delivered_time;index"`
+}
+
+// TableName overrides the default table name "outbox_messages" for the gorm library
+func (OutboxMessage) TableName() string {
+	return "inventory_outbox"
+}
+
+// WriteOutbox records events in the outbox as though emitter had emitted them.
+// db is expected to be the transaction the events' inventory changes were made in
+func WriteOutbox(db *gorm.DB, emitter Emitter, events []Event) error {
+	for _, event := range events {
+		envelope := emitter.wrap(event)
+		payload, err := json.Marshal(envelope)
+		if err != nil {
+			return fmt.Errorf("Encoding %s for outbox: %w", envelope.Type, err)
+		}
+		message := OutboxMessage{
+			EventID:   envelope.ID,
+			EventType: envelope.Type,
+			RunID:     envelope.RunID,
+			Payload:   string(payload),
+			Created:   envelope.Time,
+		}
+		if err = db.Create(&message).Error; err != nil {
+			return fmt.Errorf("Writing %s to outbox: %w", envelope.Type, err)
+		}
+	}
+	return nil
+}
+
+// ErrGaveUp is what Dispatch fails with when the next message in line has used up its MaxAttempts
+var ErrGaveUp = errors.New("Gave up delivering")
+
+// Dispatcher delivers undelivered outbox messages to an Emitter's sinks, oldest first, and marks them delivered.
+// A message is only marked once every sink has taken it, so a sink that fails halfway will see some
+// messages again--consumers get at-least-once delivery, and can use the envelope ID to spot repeats.
+// Delivery stops at the first failure so nobody sees a VehicleChanged before its VehicleAdded
+type Dispatcher struct {
+	Emitter   Emitter
+	BatchSize int
+	// MaxAttempts is how many times a message is tried before it's left for a human to look at--0 never gives up.
+	// Everything after it waits too, for the same reason delivery stops at a failure; Retry starts it up again
+	MaxAttempts int
+	Metrics     *Metrics
+}
+
+// Dispatch delivers everything it can, reporting how many messages were delivered.  With no sinks there's nowhere
+// to deliver to, and nothing is marked delivered--it'd be delivered to nobody, and lost to whatever sink comes next
+func (dispatcher Dispatcher) Dispatch(db *gorm.DB) (int, error) {
+	return dispatcher.DispatchContext(context.Background(), db)
+}
+
+// DispatchContext is Dispatch, stopping when ctx is done.  A message cut off halfway isn't counted as an attempt,
+// since it wasn't the sink that failed it
+func (dispatcher Dispatcher) DispatchContext(ctx context.Context, db *gorm.DB) (int, error) {
+	if len(dispatcher.Emitter.Sinks) == 0 {
+		return 0, nil
+	}
+	batchSize := dispatcher.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	delivered := 0
+	for {
+		var messages []OutboxMessage
+		query := db.Where("delivered_time IS NULL")
+		if err := query.Order("id").Limit(batchSize).Find(&messages).Error; err != nil {
+			return delivered, fmt.Errorf("Finding undelivered outbox messages: %w", err)
+		}
+		if len(messages) == 0 {
+			return delivered, nil
+		}
+
+		for _, message := range messages {
+			if err := ctx.Err(); err != nil {
+				return delivered, fmt.Errorf("Dispatching outbox: %w", err)
+			}
+			if dispatcher.MaxAttempts > 0 && message.Attempts >= dispatcher.MaxAttempts {
+				return delivered, fmt.Errorf("Delivering outbox message %d (%s) after %d attempts, last failing with %q: %w", message.ID, message.EventType, message.Attempts, message.LastError, ErrGaveUp)
+			}
+			if err := dispatcher.deliver(ctx, db, message); err != nil {
+				dispatcher.Metrics.Add("dealer_import_outbox_delivery_errors_total", 1, "event_type", message.EventType)
+				return delivered, err
+			}
+			dispatcher.Metrics.Add("dealer_import_outbox_delivered_total", 1, "event_type", message.EventType)
+			delivered++
+		}
+	}
+}
+
+// deliver sends a single message to every sink and records how that went
+func (dispatcher Dispatcher) deliver(ctx context.Context, db *gorm.DB, message OutboxMessage) error {
+	var envelope Envelope
+	err := json.Unmarshal([]byte(message.Payload), &envelope)
+	if err == nil {
+		for _, sink := range dispatcher.Emitter.Sinks {
+			if err = deliver(ctx, sink, envelope); err != nil {
+				break
+			}
+		}
+	}
+
+	model := db.Model(&message)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("Delivering outbox message %d (%s): %w", message.ID, message.EventType, err)
+	}
+	if err != nil {
+		lastError := err.Error()
+		if len(lastError) > 255 {
+			lastError = lastError[:255]
+		}
+		// Not counting the attempt means MaxAttempts never kicks in, which is worth hearing about
+		if updateErr := model.Updates(map[string]interface{}{"attempts": message.Attempts + 1, "last_error": lastError}).Error; updateErr != nil {
+			return fmt.Errorf("Delivering outbox message %d (%s), and recording that it failed (%v): %w", message.ID, message.EventType, updateErr, err)
+		}
+		return fmt.Errorf("Delivering outbox message %d (%s): %w", message.ID, message.EventType, err)
+	}
+
+	now := time.Now().UTC()
+	if err = model.Updates(map[string]interface{}{"attempts": message.Attempts + 1, "delivered_time": now}).Error; err != nil {
+		return fmt.Errorf("Marking outbox message %d delivered: %w", message.ID, err)
+	}
+	return nil
+}
+
+// Retry forgets the attempts of every message still waiting to be delivered, so ones Dispatch gave up on get
+// another MaxAttempts--for once whatever was wrong with the sink has been put right.  It reports how many it reset
+func (dispatcher Dispatcher) Retry(db *gorm.DB) (int, error) {
+	result := db.Model(&OutboxMessage{}).Where("delivered_time IS NULL AND attempts > 0").Update("attempts", 0)
+	if result.Error != nil {
+		return 0, fmt.Errorf("Resetting outbox attempts: %w", result.Error)
+	}
+	return int(result.RowsAffected), nil
+}
+
+// UnmarshalJSON turns an envelope back into the typed Event it started out as--which is
+// what lets the outbox hand sinks exactly what an Emitter would have
+func (envelope *Envelope) UnmarshalJSON(b []byte) error {
+	type plainEnvelope Envelope
+	var raw struct {
+		plainEnvelope
+		Event json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	var event Event
+	switch raw.Type {
+	case VehicleAdded{}.EventType():
+		event = &VehicleAdded{}
+	case VehicleChanged{}.EventType():
+		event = &VehicleChanged{}
+	case VehicleRemoved{}.EventType():
+		event = &VehicleRemoved{}
+	case VehicleTransferred{}.EventType():
+		event = &VehicleTransferred{}
+	case LotReplaced{}.EventType():
+		event = &LotReplaced{}
+	case LotHeld{}.EventType():
+		event = &LotHeld{}
+	case RunFinished{}.EventType():
+		event = &RunFinished{}
+	default:
+		return fmt.Errorf("Decoding unknown event type %s", raw.Type)
+	}
+	if err := json.Unmarshal(raw.Event, event); err != nil {
+		return fmt.Errorf("Decoding %s: %w", raw.Type, err)
+	}
+
+	*envelope = Envelope(raw.plainEnvelope)
+	// Sinks and their consumers deal in the event values, same as Emit gives them
+	switch e := event.(type) {
+	case *VehicleAdded:
+		envelope.Event = *e
+	case *VehicleChanged:
+		envelope.Event = *e
+	case *VehicleRemoved:
+		envelope.Event = *e
+	case *VehicleTransferred:
+		envelope.Event = *e
+	case *LotReplaced:
+		envelope.Event = *e
+	case *LotHeld:
+		envelope.Event = *e
+	case *RunFinished:
+		envelope.Event = *e
+	}
+	return nil
+}
+
+// inTransaction runs f in a transaction, unless db is already one--in which case whoever
+// started it gets to decide whether it's committed
+func inTransaction(db *gorm.DB, f func(tx *gorm.DB) error) error {
+	if _, ok := db.CommonDB().(*sql.Tx); ok {
+		return f(db)
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("Beginning transaction: %w", tx.Error)
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("Committing transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package importer_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/seamuncle/dealer"
+	"github.com/seamuncle/dealer/importer"
+	"github.com/seamuncle/dealer/importer/importertest"
+)
+
+// outboxOf is the outbox after events have been written to it
+func outboxOf(t *testing.T, events ...importer.Event) *gorm.DB {
+	t.Helper()
+	db := importertest.OpenDB(t)
+	if err := importer.WriteOutbox(db, importer.Emitter{RunID: "run"}, events); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// messages is everything in the outbox, oldest first
+func messages(t *testing.T, db *gorm.DB) []importer.OutboxMessage {
+	t.Helper()
+	var messages []importer.OutboxMessage
+	if err := db.Order("id").Find(&messages).Error; err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+// testEvents are a lot's worth of events, in the order they're written
+var testEvents = []importer.Event{
+	importer.VehicleAdded{Vehicle: dealer.Vehicle{FeedVehicle: dealer.FeedVehicle{VehicleKey: dealer.VehicleKey{Stock: "A124"}}}},
+	importer.VehicleRemoved{Vehicle: dealer.Vehicle{FeedVehicle: dealer.FeedVehicle{VehicleKey: dealer.VehicleKey{Stock: "B105"}}}},
+	importer.LotReplaced{Lot: dealer.Lot{DealerID: 1001, LotType: dealer.TypeNew}, Added: 1, Removed: 1},
+}
+
+func TestDispatch(t *testing.T) {
+	db := outboxOf(t, testEvents...)
+	defer db.Close()
+
+	var got []string
+	fail := map[string]bool{"VehicleRemoved": true}
+	sink := importer.SinkFunc(func(envelope importer.Envelope) error {
+		if fail[envelope.Type] {
+			return errors.New("503 Service Unavailable")
+		}
+		got = append(got, envelope.Type)
+		return nil
+	})
+	dispatcher := importer.Dispatcher{Emitter: importer.Emitter{Sinks: []importer.Sink{sink}}, MaxAttempts: 2}
+
+	// Delivery stops at the first failure, so nothing arrives out of order
+	delivered, err := dispatcher.Dispatch(db)
+	if err == nil || delivered != 1 || !reflect.DeepEqual(got, []string{"VehicleAdded"}) {
+		t.Fatalf("Delivered %d (%v), %v, want the first and an error", delivered, got, err)
+	}
+	if failed := messages(t, db)[1]; failed.Attempts != 1 || failed.LastError != "503 Service Unavailable" || failed.Delivered != nil {
+		t.Errorf("Failed message is %+v, want an attempt and the error recorded", failed)
+	}
+	if _, err = dispatcher.Dispatch(db); err == nil {
+		t.Fatal("Delivered a failing message")
+	}
+	if _, err = dispatcher.Dispatch(db); !errors.Is(err, importer.ErrGaveUp) {
+		t.Fatalf("Third try is %v, want %v", err, importer.ErrGaveUp)
+	}
+
+	// Once it's fixed, Retry lets it through--and everything behind it
+	fail = map[string]bool{}
+	if reset, err := dispatcher.Retry(db); err != nil || reset != 1 {
+		t.Fatalf("Reset %d, %v, want the failed message", reset, err)
+	}
+	if delivered, err = dispatcher.Dispatch(db); err != nil || delivered != 2 {
+		t.Fatalf("Delivered %d, %v, want the other two", delivered, err)
+	}
+	if want := []string{"VehicleAdded", "VehicleRemoved", "LotReplaced"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Delivered %v, want %v", got, want)
+	}
+	for _, message := range messages(t, db) {
+		if message.Delivered == nil {
+			t.Errorf("Message %d (%s) isn't marked delivered", message.ID, message.EventType)
+		}
+	}
+}
+
+func TestDispatchNoSinks(t *testing.T) {
+	db := outboxOf(t, testEvents...)
+	defer db.Close()
+
+	// Marking them delivered would lose them to whatever sink comes along next
+	if delivered, err := (importer.Dispatcher{}).Dispatch(db); err != nil || delivered != 0 {
+		t.Errorf("Delivered %d, %v, want nothing", delivered, err)
+	}
+	for _, message := range messages(t, db) {
+		if message.Delivered != nil || message.Attempts != 0 {
+			t.Errorf("Message %d is %+v, want it left alone", message.ID, message)
+		}
+	}
+}
+
+func TestDispatchCancelled(t *testing.T) {
+	db := outboxOf(t, testEvents...)
+	defer db.Close()
+
+	// A webhook that's down, and would be retried for an hour
+	requests := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	webhook := importer.WebhookSink{URL: server.URL, Retries: 3, Backoff: time.Hour}
+	dispatcher := importer.Dispatcher{Emitter: importer.Emitter{Sinks: []importer.Sink{webhook}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-requests
+		cancel()
+	}()
+	done := make(chan error)
+	go func() {
+		_, err := dispatcher.DispatchContext(ctx, db)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Dispatch failed with %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Dispatch is still waiting to retry")
+	}
+
+	// Cut off isn't the sink failing
+	if first := messages(t, db)[0]; first.Attempts != 0 || first.Delivered != nil {
+		t.Errorf("Message is %+v, want it waiting as it was", first)
+	}
+	if _, err := dispatcher.DispatchContext(ctx, db); !errors.Is(err, context.Canceled) {
+		t.Errorf("Dispatching when already cancelled gave %v", err)
+	}
+}
+
+func TestEmitContext(t *testing.T) {
+	var got []string
+	emitter := importer.Emitter{RunID: "run", Sinks: []importer.Sink{
+		importer.SinkFunc(func(envelope importer.Envelope) error {
+			got = append(got, fmt.Sprintf("%s %s", envelope.RunID, envelope.Type))
+			return nil
+		}),
+		importer.WebhookSink{URL: "http://127.0.0.1:1", Retries: 3, Backoff: time.Hour},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Every sink gets its go, and one that would wait gives up straight away
+	err := emitter.EmitContext(ctx, importer.RunFinished{Filename: "dealer_import.csv"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Emitting gave %v, want %v", err, context.Canceled)
+	}
+	if want := []string{"run RunFinished"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Emitted %v, want %v", got, want)
+	}
+}
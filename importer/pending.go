@@ -0,0 +1,510 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/seamuncle/dealer"
+)
+
+// Kinds of PendingChange
+const (
+	// PendingInsert is a vehicle the feed added that inventory doesn't have
+	PendingInsert = "insert"
+	// PendingUpdate is a change the feed made to a vehicle inventory has--moving it onto another lot included
+	PendingUpdate = "update"
+	// PendingDelete is a vehicle the feed left out that inventory has, kept because the feed left out too many
+	// of them at once--see Config.MaxDeletePercent
+	PendingDelete = "delete"
+	// PendingLot is everything the feed said about a lot, to replace the lot with all at once
+	PendingLot = "lot"
+)
+
+// Statuses of a PendingChange
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+	// StatusSuperseded is a change nobody got to before a later run parked another one just like it
+	StatusSuperseded = "superseded"
+	// StatusApplying is a lot somebody's approved, while it's being replaced.  It's marked approved in the same
+	// transaction that replaces the lot, so one that's still applying after an approval was cut off never got replaced
+	StatusApplying = "applying"
+)
+
+// ErrNotPending is what deciding on a change that's already been decided fails with
+var ErrNotPending = errors.New("Change is not pending")
+
+// ErrDryRun is what deciding on a change fails with when the Config says it's a dry run.  A decision is only ever
+// for real--approving a lot goes through the runner a lot at a time, so there's no one transaction to roll back
+var ErrDryRun = errors.New("Changes can't be decided on in a dry run")
+
+// PendingChange is a change a safeguard kept out of inventory until somebody's had a look at it, waiting in the
+// pending_changes table.  Approving it applies it to inventory as inventory is then--not as it was when the change
+// was parked--and rejecting it throws it away.  Either way it stays in the table, so there's a record of who decided
+type PendingChange struct {
+	ID    int    `gorm:"column:pending_id;primary_key"`
+	RunID string `gorm:"column:run_id;type:varchar(32);index"`
+	Kind  string `gorm:"column:kind;type:varchar(16)"`
+	LotID int    `gorm:"column:lot_id;index"`
+	// VehicleID is the vehicle an update or delete is to--0 for an insert or a lot
+	VehicleID int    `gorm:"column:v_id"`
+	VIN       string `gorm:"column:vin"`
+	Stock     string `gorm:"column:stock_id"`
+	// Reason is why it was kept out
+	Reason string `gorm:"column:reason;type:text"`
+	// Payload is the FeedVehicle an insert or update would write, the one a delete would remove, or every
+	// FeedVehicle of a lot, as JSON
+	Payload   string     `gorm:"column:payload;type:text"`
+	Status    string     `gorm:"column:status;type:varchar(16);index"`
+	Created   time.Time  `gorm:"column:created_time"`
+	Decided   *time.Time `gorm:"column:decided_time"`
+	DecidedBy string     `gorm:"column:decided_by"`
+}
+
+// TableName overrides the default table name "pending_changes" for the gorm library--which happens to be
+// what we want, but it's nice to see it spelled out
+func (PendingChange) TableName() string {
+	return "pending_changes"
+}
+
+// ParkVehicle is a PendingChange to insert, update or delete a single vehicle on a lot.  vehicleID is the vehicle in
+// inventory an update or delete is to, and feed what an insert or update would make of it--or what a delete would
+// remove, so whoever decides can see what it was
+func ParkVehicle(kind string, lotID, vehicleID int, feed dealer.FeedVehicle, reason string) (PendingChange, error) {
+	payload, err := json.Marshal(feed)
+	if err != nil {
+		return PendingChange{}, fmt.Errorf("Encoding pending %s of %s: %w", kind, feed.VIN, err)
+	}
+	return PendingChange{
+		Kind:      kind,
+		LotID:     lotID,
+		VehicleID: vehicleID,
+		VIN:       feed.VIN,
+		Stock:     feed.Stock,
+		Reason:    reason,
+		Payload:   string(payload),
+		Status:    StatusPending,
+	}, nil
+}
+
+// ParkLot is a PendingChange to replace a whole lot with vehicles
+func ParkLot(lotID int, vehicles []dealer.FeedVehicle, reason string) (PendingChange, error) {
+	payload, err := json.Marshal(vehicles)
+	if err != nil {
+		return PendingChange{}, fmt.Errorf("Encoding pending lot %d: %w", lotID, err)
+	}
+	return PendingChange{Kind: PendingLot, LotID: lotID, Reason: reason, Payload: string(payload), Status: StatusPending}, nil
+}
+
+// WritePending parks changes in the pending_changes table for runID, filling in their IDs.  A feed that keeps sending
+// the same thing gets it parked every run, so anything still pending for the same vehicle--or lot--is superseded
+// rather than piling up; the latest is the one worth deciding on.  db is expected to be the transaction the rest
+// of the lot was replaced in, so a lot that doesn't get replaced doesn't leave anything waiting either
+func WritePending(db *gorm.DB, runID string, changes []PendingChange) error {
+	for i := range changes {
+		change := &changes[i]
+		err := db.Model(&PendingChange{}).
+			Where("status = ? AND kind = ? AND lot_id = ? AND v_id = ? AND vin = ? AND stock_id = ?", StatusPending, change.Kind, change.LotID, change.VehicleID, change.VIN, change.Stock).
+			Update("status", StatusSuperseded).Error
+		if err != nil {
+			return fmt.Errorf("Superseding pending %s: %w", change.Kind, err)
+		}
+		change.RunID = runID
+		change.Created = time.Now()
+		if err = db.Create(change).Error; err != nil {
+			return fmt.Errorf("Parking pending %s: %w", change.Kind, err)
+		}
+	}
+	return nil
+}
+
+// PendingChanges lists changes by status, oldest first--every one of them if status is empty
+func PendingChanges(db *gorm.DB, status string) ([]PendingChange, error) {
+	query := db
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var changes []PendingChange
+	if err := query.Order("pending_id").Find(&changes).Error; err != nil {
+		return nil, fmt.Errorf("Finding pending changes: %w", err)
+	}
+	return changes, nil
+}
+
+// Reviewer decides on pending changes.  Approving a lot replaces it the same way a feed would, so it gets the
+// Config a feed would--less anything that would hold it again, since somebody's just said not to
+type Reviewer struct {
+	Config Config
+	// By is who's deciding, for decided_by
+	By string
+}
+
+// Reject throws a pending change away
+func (reviewer Reviewer) Reject(db *gorm.DB, id int) error {
+	return inTransaction(db, func(tx *gorm.DB) error {
+		change, err := reviewer.pending(tx, id)
+		if err != nil {
+			return err
+		}
+		if reviewer.Config.DryRun {
+			return fmt.Errorf("Rejecting pending change %d: %w", id, ErrDryRun)
+		}
+		reviewer.Config.Logger.Info("Pending change rejected", "pending_id", id, "kind", change.Kind, "lot_id", change.LotID, "by", reviewer.By)
+		return reviewer.decide(tx, change, StatusRejected)
+	})
+}
+
+// Approve applies a pending change to inventory as it is now.  An insert of a vehicle the lot has since got, or an
+// update or delete of one that's since gone, fails and stays pending--inventory has moved on, and it's worth a look.
+// Whatever it changes gets the same events a run would have emitted, through the outbox if the Config says so
+func (reviewer Reviewer) Approve(db *gorm.DB, id int) error {
+	var change PendingChange
+	var err error
+	if change, err = reviewer.pending(db, id); err != nil {
+		return err
+	}
+	if reviewer.Config.DryRun {
+		return fmt.Errorf("Approving pending change %d: %w", id, ErrDryRun)
+	}
+	if change.Kind == PendingLot {
+		return reviewer.approveLot(db, change)
+	}
+
+	emitter := Emitter{}
+	if reviewer.Config.Emitter != nil {
+		emitter = *reviewer.Config.Emitter
+	}
+	emitter.RunID = newID()
+
+	var event Event
+	err = inTransaction(db, func(tx *gorm.DB) error {
+		if change, err = reviewer.pending(tx, id); err != nil {
+			return err
+		}
+		var feed dealer.FeedVehicle
+		if err = json.Unmarshal([]byte(change.Payload), &feed); err != nil {
+			return fmt.Errorf("Decoding pending change %d: %w", id, err)
+		}
+
+		switch change.Kind {
+		case PendingInsert:
+			event, err = reviewer.insert(tx, change, feed)
+		case PendingUpdate:
+			event, err = reviewer.update(tx, change, feed)
+		case PendingDelete:
+			event, err = reviewer.delete(tx, change)
+		default:
+			err = fmt.Errorf("Approving pending change %d: unknown kind %q", id, change.Kind)
+		}
+		if err != nil {
+			return err
+		}
+		if err = reviewer.decide(tx, change, StatusApproved); err != nil {
+			return err
+		}
+		if reviewer.Config.Outbox && event != nil {
+			return WriteOutbox(tx, emitter, []Event{event})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	reviewer.Config.Logger.Info("Pending change approved", "pending_id", id, "kind", change.Kind, "lot_id", change.LotID, "v_id", change.VehicleID, "by", reviewer.By)
+	if reviewer.Config.Outbox || event == nil {
+		return nil
+	}
+	if err = emitter.Emit(event); err != nil {
+		return fmt.Errorf("Emitting events: %w", err)
+	}
+	return nil
+}
+
+// pending finds a change that's still waiting on a decision
+func (reviewer Reviewer) pending(db *gorm.DB, id int) (PendingChange, error) {
+	var change PendingChange
+	if err := db.Where("pending_id = ?", id).First(&change).Error; err != nil {
+		return change, fmt.Errorf("Finding pending change %d: %w", id, err)
+	}
+	if change.Status != StatusPending {
+		return change, fmt.Errorf("Deciding on change %d, which is %s: %w", id, change.Status, ErrNotPending)
+	}
+	return change, nil
+}
+
+// decide marks a change decided, as long as it's still in the status it was found in.  If it isn't, somebody else
+// decided on it in the meantime, and whatever this decision did has to be rolled back
+func (reviewer Reviewer) decide(db *gorm.DB, change PendingChange, status string) error {
+	now := time.Now()
+	result := db.Model(&PendingChange{}).Where("pending_id = ? AND status = ?", change.ID, change.Status).
+		Updates(map[string]interface{}{"status": status, "decided_time": now, "decided_by": reviewer.By})
+	if result.Error != nil {
+		return fmt.Errorf("Marking pending change %d %s: %w", change.ID, status, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("Marking pending change %d %s, which is no longer %s: %w", change.ID, status, change.Status, ErrNotPending)
+	}
+	return nil
+}
+
+// claim marks a pending lot applying, as long as it's still pending--so of two approvals of the same lot at once,
+// only one gets to replace it
+func (reviewer Reviewer) claim(db *gorm.DB, change PendingChange) (PendingChange, error) {
+	result := db.Model(&PendingChange{}).Where("pending_id = ? AND status = ?", change.ID, StatusPending).Update("status", StatusApplying)
+	if result.Error != nil {
+		return change, fmt.Errorf("Claiming pending change %d: %w", change.ID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return change, fmt.Errorf("Deciding on change %d, which is no longer pending: %w", change.ID, ErrNotPending)
+	}
+	change.Status = StatusApplying
+	return change, nil
+}
+
+// release puts a lot claim took back to pending, unless it got approved after all
+func (reviewer Reviewer) release(db *gorm.DB, change PendingChange) error {
+	err := db.Model(&PendingChange{}).Where("pending_id = ? AND status = ?", change.ID, StatusApplying).Update("status", StatusPending).Error
+	if err != nil {
+		return fmt.Errorf("Releasing pending change %d: %w", change.ID, err)
+	}
+	return nil
+}
+
+// insert adds a parked vehicle to its lot, unless the lot has got it some other way since
+func (reviewer Reviewer) insert(db *gorm.DB, change PendingChange, feed dealer.FeedVehicle) (Event, error) {
+	lot, err := reviewer.lot(db, change.LotID)
+	if err != nil {
+		return nil, err
+	}
+	set := NewInventorySet(lot, change.LotID, db)
+	if match := set.Match(feed); match.Found {
+		return nil, fmt.Errorf("Approving pending insert %d: lot already has it as v_id %d", change.ID, match.Vehicle.ID)
+	}
+	// A rule rejects a row before the runner looks for it on the dealer's other lots, so this might be a transfer--
+	// and inserting it would leave the dealer with two of it.  VINs are compared the way matching compares them
+	if vin := dealer.NormalizeVIN(feed.VIN); vin != "" {
+		dealerLots, err := dealer.ListLots(db, lot.DealerID)
+		if err != nil {
+			return nil, err
+		}
+		lotIDs := make([]int, len(dealerLots))
+		for i, dealerLot := range dealerLots {
+			lotIDs[i] = dealerLot.ID
+		}
+		var others []dealer.Vehicle
+		if err = db.Where("vin LIKE ? AND lot_id IN (?)", vinLike(vin), lotIDs).Order("v_id").Find(&others).Error; err != nil {
+			return nil, fmt.Errorf("Approving pending insert %d: %w", change.ID, err)
+		}
+		for _, other := range others {
+			if dealer.NormalizeVIN(other.VIN) == vin {
+				return nil, fmt.Errorf("Approving pending insert %d: v_id %d has its VIN on lot %d", change.ID, other.ID, other.LotID)
+			}
+		}
+	}
+
+	now := time.Now()
+	vehicle := dealer.Vehicle{LotID: change.LotID, Created: now, LastModified: now, TheGuilty: "REVIEW", Lot: lot, FeedVehicle: feed}
+	if err = db.Create(&vehicle).Error; err != nil {
+		return nil, fmt.Errorf("Approving pending insert %d: %w", change.ID, err)
+	}
+	return VehicleAdded{Vehicle: vehicle}, nil
+}
+
+// update applies a parked change to a vehicle as it is now--moving it to the change's lot, if it isn't on it
+func (reviewer Reviewer) update(db *gorm.DB, change PendingChange, feed dealer.FeedVehicle) (Event, error) {
+	var vehicle dealer.Vehicle
+	if err := db.Where("v_id = ?", change.VehicleID).First(&vehicle).Error; err != nil {
+		return nil, fmt.Errorf("Approving pending update %d of v_id %d: %w", change.ID, change.VehicleID, err)
+	}
+	lots, err := dealer.Lots(db)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := vehicle.Diff(feed)
+	from := vehicle.LotID
+	vehicle.TheGuilty, vehicle.LastModified = "REVIEW", time.Now()
+	columns := vehicle.Updates(changes)
+	if from != change.LotID {
+		columns["lot_id"] = change.LotID
+		transfer := dealer.Transfer{
+			VehicleID:   vehicle.ID,
+			VIN:         feed.VIN,
+			FromLotID:   from,
+			ToLotID:     change.LotID,
+			Transferred: vehicle.LastModified,
+			TheGuilty:   "REVIEW",
+		}
+		if err = db.Create(&transfer).Error; err != nil {
+			return nil, fmt.Errorf("Approving pending update %d: recording transfer: %w", change.ID, err)
+		}
+	}
+	if err = db.Model(&vehicle).Updates(columns).Error; err != nil {
+		return nil, fmt.Errorf("Approving pending update %d: %w", change.ID, err)
+	}
+
+	vehicle.FeedVehicle, vehicle.LotID, vehicle.Lot = feed, change.LotID, lots[change.LotID]
+	if from != change.LotID {
+		return VehicleTransferred{Vehicle: vehicle, From: lots[from], Changes: changes}, nil
+	}
+	if len(changes) == 0 {
+		// The feed has since caught up with it
+		return nil, nil
+	}
+	return VehicleChanged{Vehicle: vehicle, Changes: changes}, nil
+}
+
+// delete removes a parked vehicle from inventory, as long as it's still on the lot that left it out
+func (reviewer Reviewer) delete(db *gorm.DB, change PendingChange) (Event, error) {
+	var vehicle dealer.Vehicle
+	if err := db.Where("v_id = ?", change.VehicleID).First(&vehicle).Error; err != nil {
+		return nil, fmt.Errorf("Approving pending delete %d of v_id %d: %w", change.ID, change.VehicleID, err)
+	}
+	// A feed that's since listed it somewhere else has the last word on where it is
+	if vehicle.LotID != change.LotID {
+		return nil, fmt.Errorf("Approving pending delete %d: v_id %d has since moved to lot %d", change.ID, vehicle.ID, vehicle.LotID)
+	}
+	if err := db.Delete(&vehicle).Error; err != nil {
+		return nil, fmt.Errorf("Approving pending delete %d: %w", change.ID, err)
+	}
+	lot, err := reviewer.lot(db, vehicle.LotID)
+	if err != nil {
+		return nil, err
+	}
+	vehicle.Lot = lot
+	return VehicleRemoved{Vehicle: vehicle}, nil
+}
+
+// approveLot replaces a lot with its parked vehicles, by running them through a FullReplaceRunner as a feed of their
+// own--so matching, transfers, events and everything else happen exactly as they would have
+func (reviewer Reviewer) approveLot(db *gorm.DB, change PendingChange) error {
+	var feed []dealer.FeedVehicle
+	if err := json.Unmarshal([]byte(change.Payload), &feed); err != nil {
+		return fmt.Errorf("Decoding pending change %d: %w", change.ID, err)
+	}
+	// An empty feed doesn't replace anything, so there'd be nothing to approve
+	if len(feed) == 0 {
+		return fmt.Errorf("Approving pending lot %d: no vehicles to replace lot %d with", change.ID, change.LotID)
+	}
+	lot, err := reviewer.lot(db, change.LotID)
+	if err != nil {
+		return err
+	}
+
+	// Somebody else approving it at the same time finds it isn't pending any more, rather than replacing it too
+	if change, err = reviewer.claim(db, change); err != nil {
+		return err
+	}
+
+	config := reviewer.Config
+	config.Filename = fmt.Sprintf("pending_changes/%d", change.ID)
+	config.DoProcessing = true
+	config.Archive, config.Rules, config.HoldAnomalies, config.Review, config.MaxDeletePercent = nil, nil, false, false, 0
+	// It's approved in the transaction that replaces the lot, so the lot and the decision commit or roll back together
+	replaced := false
+	runner := FullReplaceRunner{Config: config, onReplace: func(tx *gorm.DB, changes LotChanges) error {
+		replaced = true
+		return reviewer.decide(tx, change, StatusApproved)
+	}}
+	err = runner.Run(parkedImporter{lot: lot, feed: feed}, db)
+	if err == nil && !replaced {
+		err = fmt.Errorf("lot %d was never replaced", change.LotID)
+	}
+	if err != nil {
+		if released := reviewer.release(db, change); released != nil {
+			return fmt.Errorf("Approving pending lot %d: %v, and %w", change.ID, err, released)
+		}
+		// Events are emitted after the lot commits, so failing to emit them doesn't take the approval back
+		var now PendingChange
+		if found := db.Where("pending_id = ?", change.ID).First(&now).Error; found == nil && now.Status == StatusApproved {
+			return fmt.Errorf("Pending lot %d approved, but: %w", change.ID, err)
+		}
+		return fmt.Errorf("Approving pending lot %d: %w", change.ID, err)
+	}
+
+	reviewer.Config.Logger.Info("Pending change approved", "pending_id", change.ID, "kind", change.Kind, "lot_id", change.LotID, "by", reviewer.By)
+	return nil
+}
+
+// lot is the Lot of a lot ID, as the master tables have it
+func (reviewer Reviewer) lot(db *gorm.DB, lotID int) (dealer.Lot, error) {
+	lots, err := dealer.Lots(db)
+	if err != nil {
+		return dealer.Lot{}, err
+	}
+	lot, ok := lots[lotID]
+	if !ok {
+		return lot, fmt.Errorf("Finding lot %d: no such lot", lotID)
+	}
+	return lot, nil
+}
+
+// parkedImporter is an Importer whose feed is a parked lot's vehicles
+type parkedImporter struct {
+	lot  dealer.Lot
+	feed []dealer.FeedVehicle
+}
+
+// parkedRecord is one of a parked lot's vehicles
+type parkedRecord struct {
+	number  int
+	source  Location
+	vehicle dealer.Vehicle
+}
+
+// Row is the vehicle's place in the parked lot
+func (record parkedRecord) Row() int {
+	return record.number
+}
+
+// Location is the pending change the vehicle is part of
+func (record parkedRecord) Location() Location {
+	return record.source
+}
+
+// Fields is none--a parked vehicle has already been processed
+func (record parkedRecord) Fields() []string {
+	return nil
+}
+
+// Field is nothing, for the same reason
+func (record parkedRecord) Field(name string) (string, bool) {
+	return "", false
+}
+
+// AquireRecords has nothing to aquire
+func (importer parkedImporter) AquireRecords(filename string) error {
+	return nil
+}
+
+// HasAquired always has
+func (importer parkedImporter) HasAquired(filename string) bool {
+	return true
+}
+
+// LoadRecords is the parked vehicles
+func (importer parkedImporter) LoadRecords(filename string) ([]Record, error) {
+	records := make([]Record, len(importer.feed))
+	for i, feed := range importer.feed {
+		records[i] = parkedRecord{
+			number:  i + 1,
+			source:  Location{File: filename},
+			vehicle: dealer.Vehicle{Lot: importer.lot, FeedVehicle: feed},
+		}
+	}
+	return records, nil
+}
+
+// ProcessRecord hands back the parked vehicle
+func (importer parkedImporter) ProcessRecord(record Record) (dealer.Vehicle, error) {
+	parked, ok := record.(parkedRecord)
+	if !ok {
+		return dealer.Vehicle{}, fmt.Errorf("Processing %T: not a parked vehicle", record)
+	}
+	return parked.vehicle, nil
+}
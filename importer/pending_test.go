@@ -0,0 +1,270 @@
+package importer_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/seamuncle/dealer"
+	"github.com/seamuncle/dealer/importer"
+	"github.com/seamuncle/dealer/importer/importertest"
+)
+
+// park writes change to pending_changes the way a run would, and hands back its ID
+func park(t *testing.T, db *gorm.DB, change importer.PendingChange, err error) int {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := []importer.PendingChange{change}
+	if err := importer.WritePending(db, "parked", changes); err != nil {
+		t.Fatal(err)
+	}
+	return changes[0].ID
+}
+
+// priced is what a feed would make of vehicle at another price
+func priced(vehicle dealer.Vehicle, price float64) dealer.FeedVehicle {
+	feed := vehicle.FeedVehicle
+	feed.Price = price
+	return feed
+}
+
+// statusOf is the status change id is in now
+func statusOf(t *testing.T, db *gorm.DB, id int) string {
+	t.Helper()
+	var change importer.PendingChange
+	if err := db.Where("pending_id = ?", id).First(&change).Error; err != nil {
+		t.Fatal(err)
+	}
+	return change.Status
+}
+
+// stockOn is the stock numbers of a lot's vehicles, and their prices
+func stockOn(t *testing.T, db *gorm.DB, lotID int) map[string]float64 {
+	t.Helper()
+	var vehicles []dealer.Vehicle
+	if err := db.Where("lot_id = ?", lotID).Find(&vehicles).Error; err != nil {
+		t.Fatal(err)
+	}
+	stock := map[string]float64{}
+	for _, vehicle := range vehicles {
+		stock[vehicle.Stock] = vehicle.Price
+	}
+	return stock
+}
+
+func TestReviewerApprove(t *testing.T) {
+	newLot, usedLot := importertest.Lot(1001, dealer.TypeNew), importertest.Lot(1001, dealer.TypeUsed)
+	fusion := importertest.Vehicle(newLot, "A124", "1GCEP22T1G3329139")
+	mustang := importertest.Vehicle(newLot, "B105", "KM8SB12B02U162029").Described(2014, "Ford", "Mustang", "GT")
+	edge := importertest.Vehicle(newLot, "N900", "2FMDK3KC0ABA00001").Described(2018, "Ford", "Edge", "SE")
+
+	// Each test seeds the fusion on the new lot and the mustang on the used one, so both lots exist
+	for _, test := range []struct {
+		name string
+		// change is what to park, given the seeded fusion and mustang
+		change  func(fusion, mustang dealer.Vehicle) (importer.PendingChange, error)
+		before  func(t *testing.T, db *gorm.DB, fusion, mustang dealer.Vehicle)
+		wantErr bool
+		// newLot and usedLot are what's on each lot afterwards, by stock number and price
+		newLot, usedLot map[string]float64
+	}{{
+		name: "insert",
+		change: func(fusion, mustang dealer.Vehicle) (importer.PendingChange, error) {
+			return importer.ParkVehicle(importer.PendingInsert, fusion.LotID, 0, edge.Priced(31).FeedVehicle, "Price below 500")
+		},
+		newLot:  map[string]float64{"A124": 29999, "N900": 31},
+		usedLot: map[string]float64{"B105": 29999},
+	}, {
+		name: "insert of a vehicle the lot has since got",
+		change: func(fusion, mustang dealer.Vehicle) (importer.PendingChange, error) {
+			return importer.ParkVehicle(importer.PendingInsert, fusion.LotID, 0, fusion.FeedVehicle, "Price below 500")
+		},
+		wantErr: true,
+		newLot:  map[string]float64{"A124": 29999},
+		usedLot: map[string]float64{"B105": 29999},
+	}, {
+		// The dealer has it on another lot, with its VIN written differently--approving it would make two of it
+		name: "insert of a VIN on another of the dealer's lots",
+		change: func(fusion, mustang dealer.Vehicle) (importer.PendingChange, error) {
+			feed := mustang.FeedVehicle
+			feed.Stock, feed.VIN = "N105", "km8sb-12b02 u162029"
+			return importer.ParkVehicle(importer.PendingInsert, fusion.LotID, 0, feed, "Price below 500")
+		},
+		wantErr: true,
+		newLot:  map[string]float64{"A124": 29999},
+		usedLot: map[string]float64{"B105": 29999},
+	}, {
+		name: "update",
+		change: func(fusion, mustang dealer.Vehicle) (importer.PendingChange, error) {
+			return importer.ParkVehicle(importer.PendingUpdate, fusion.LotID, fusion.ID, priced(fusion, 25999), "Odometer rolled back")
+		},
+		newLot:  map[string]float64{"A124": 25999},
+		usedLot: map[string]float64{"B105": 29999},
+	}, {
+		name: "update that moves a vehicle",
+		change: func(fusion, mustang dealer.Vehicle) (importer.PendingChange, error) {
+			return importer.ParkVehicle(importer.PendingUpdate, fusion.LotID, mustang.ID, priced(mustang, 19999), "Odometer rolled back")
+		},
+		newLot:  map[string]float64{"A124": 29999, "B105": 19999},
+		usedLot: map[string]float64{},
+	}, {
+		name: "update of a vehicle that's gone",
+		change: func(fusion, mustang dealer.Vehicle) (importer.PendingChange, error) {
+			return importer.ParkVehicle(importer.PendingUpdate, fusion.LotID, fusion.ID, priced(fusion, 25999), "Odometer rolled back")
+		},
+		before: func(t *testing.T, db *gorm.DB, fusion, mustang dealer.Vehicle) {
+			if err := db.Delete(&fusion).Error; err != nil {
+				t.Fatal(err)
+			}
+		},
+		wantErr: true,
+		newLot:  map[string]float64{},
+		usedLot: map[string]float64{"B105": 29999},
+	}, {
+		name: "delete",
+		change: func(fusion, mustang dealer.Vehicle) (importer.PendingChange, error) {
+			return importer.ParkVehicle(importer.PendingDelete, mustang.LotID, mustang.ID, mustang.FeedVehicle, "Feed left out too many")
+		},
+		newLot:  map[string]float64{"A124": 29999},
+		usedLot: map[string]float64{},
+	}, {
+		// Another feed has listed it since, on another lot, and it gets the last word
+		name: "delete of a vehicle that's moved",
+		change: func(fusion, mustang dealer.Vehicle) (importer.PendingChange, error) {
+			return importer.ParkVehicle(importer.PendingDelete, mustang.LotID, mustang.ID, mustang.FeedVehicle, "Feed left out too many")
+		},
+		before: func(t *testing.T, db *gorm.DB, fusion, mustang dealer.Vehicle) {
+			if err := db.Model(&mustang).Update("lot_id", fusion.LotID).Error; err != nil {
+				t.Fatal(err)
+			}
+		},
+		wantErr: true,
+		newLot:  map[string]float64{"A124": 29999, "B105": 29999},
+		usedLot: map[string]float64{},
+	}, {
+		name: "lot",
+		change: func(fusion, mustang dealer.Vehicle) (importer.PendingChange, error) {
+			return importer.ParkLot(fusion.LotID, []dealer.FeedVehicle{edge.Priced(31).FeedVehicle, priced(mustang, 19999)}, "Price below 500")
+		},
+		newLot:  map[string]float64{"N900": 31, "B105": 19999},
+		usedLot: map[string]float64{},
+	}, {
+		name: "lot of nothing",
+		change: func(fusion, mustang dealer.Vehicle) (importer.PendingChange, error) {
+			return importer.ParkLot(fusion.LotID, nil, "Price below 500")
+		},
+		wantErr: true,
+		newLot:  map[string]float64{"A124": 29999},
+		usedLot: map[string]float64{"B105": 29999},
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			db := importertest.OpenDB(t)
+			defer db.Close()
+			seeded := importertest.Seed(t, db, fusion.Build(), mustang.OnLot(usedLot).Build())
+			change, err := test.change(seeded[0], seeded[1])
+			id := park(t, db, change, err)
+			if test.before != nil {
+				test.before(t, db, seeded[0], seeded[1])
+			}
+
+			reviewer := importer.Reviewer{By: "tester"}
+			err = reviewer.Approve(db, id)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("Approve succeeded, and it should have failed")
+				}
+				if status := statusOf(t, db, id); status != importer.StatusPending {
+					t.Errorf("Change is %s after failing, want it still pending", status)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Approve failed: %v", err)
+				}
+				if status := statusOf(t, db, id); status != importer.StatusApproved {
+					t.Errorf("Change is %s, want approved", status)
+				}
+				// Deciding is once only
+				if err = reviewer.Approve(db, id); !errors.Is(err, importer.ErrNotPending) {
+					t.Errorf("Approving twice failed with %v, want ErrNotPending", err)
+				}
+			}
+
+			if got := stockOn(t, db, seeded[0].LotID); !reflect.DeepEqual(got, test.newLot) {
+				t.Errorf("New lot has %v, want %v", got, test.newLot)
+			}
+			if got := stockOn(t, db, seeded[1].LotID); !reflect.DeepEqual(got, test.usedLot) {
+				t.Errorf("Used lot has %v, want %v", got, test.usedLot)
+			}
+		})
+	}
+}
+
+func TestReviewerApproveLotApplying(t *testing.T) {
+	db := importertest.OpenDB(t)
+	defer db.Close()
+	seeded := importertest.Seed(t, db, importertest.Vehicle(importertest.Lot(1001, dealer.TypeNew), "A124", "1GCEP22T1G3329139").Build())
+	change, err := importer.ParkLot(seeded[0].LotID, []dealer.FeedVehicle{priced(seeded[0], 25999)}, "Price below 500")
+	id := park(t, db, change, err)
+
+	// Somebody else's approval is partway through replacing the lot
+	if err := db.Model(&importer.PendingChange{}).Where("pending_id = ?", id).Update("status", importer.StatusApplying).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := (importer.Reviewer{By: "tester"}).Approve(db, id); !errors.Is(err, importer.ErrNotPending) {
+		t.Errorf("Approving a lot that's applying failed with %v, want ErrNotPending", err)
+	}
+	if got := stockOn(t, db, seeded[0].LotID); got["A124"] != 29999 {
+		t.Errorf("Lot has %v, want it left for the other approval", got)
+	}
+}
+
+func TestReviewerReject(t *testing.T) {
+	db := importertest.OpenDB(t)
+	defer db.Close()
+	seeded := importertest.Seed(t, db, importertest.Vehicle(importertest.Lot(1001, dealer.TypeNew), "A124", "1GCEP22T1G3329139").Build())
+	change, err := importer.ParkVehicle(importer.PendingUpdate, seeded[0].LotID, seeded[0].ID, priced(seeded[0], 25999), "Odometer rolled back")
+	id := park(t, db, change, err)
+
+	reviewer := importer.Reviewer{By: "tester"}
+	if err := reviewer.Reject(db, id); err != nil {
+		t.Fatalf("Reject failed: %v", err)
+	}
+	if status := statusOf(t, db, id); status != importer.StatusRejected {
+		t.Errorf("Change is %s, want rejected", status)
+	}
+	if got := stockOn(t, db, seeded[0].LotID); got["A124"] != 29999 {
+		t.Errorf("Lot has %v, want it left as it was", got)
+	}
+	if err := reviewer.Reject(db, id); !errors.Is(err, importer.ErrNotPending) {
+		t.Errorf("Rejecting twice failed with %v, want ErrNotPending", err)
+	}
+	if err := reviewer.Approve(db, id); !errors.Is(err, importer.ErrNotPending) {
+		t.Errorf("Approving a rejected change failed with %v, want ErrNotPending", err)
+	}
+}
+
+func TestReviewerDryRun(t *testing.T) {
+	db := importertest.OpenDB(t)
+	defer db.Close()
+	seeded := importertest.Seed(t, db, importertest.Vehicle(importertest.Lot(1001, dealer.TypeNew), "A124", "1GCEP22T1G3329139").Build())
+	change, err := importer.ParkVehicle(importer.PendingUpdate, seeded[0].LotID, seeded[0].ID, priced(seeded[0], 25999), "Odometer rolled back")
+	update := park(t, db, change, err)
+	change, err = importer.ParkLot(seeded[0].LotID, []dealer.FeedVehicle{priced(seeded[0], 25999)}, "Price below 500")
+	lot := park(t, db, change, err)
+
+	reviewer := importer.Reviewer{Config: importer.Config{DryRun: true}, By: "tester"}
+	for _, id := range []int{update, lot} {
+		if err := reviewer.Approve(db, id); !errors.Is(err, importer.ErrDryRun) {
+			t.Errorf("Approving %d in a dry run failed with %v, want ErrDryRun", id, err)
+		}
+		if err := reviewer.Reject(db, id); !errors.Is(err, importer.ErrDryRun) {
+			t.Errorf("Rejecting %d in a dry run failed with %v, want ErrDryRun", id, err)
+		}
+		if status := statusOf(t, db, id); status != importer.StatusPending {
+			t.Errorf("Change %d is %s, want it still pending", id, status)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Record is a single row of a feed, whatever shape the feed comes in.  It's what LoadRecords hands out and
+// ProcessRecord takes back--and since every importer's records look the same from out here, anything that wants
+// a peek at a feed before it's imported can have one without knowing which importer it came from
+type Record interface {
+	// Row is the record's place in the feed, counting from 1, not counting headings
+	Row() int
+	// Location is where in the feed the record came from
+	Location() Location
+	// Fields names every field the record has, in the order the feed gives them
+	Fields() []string
+	// Field is the raw value of the named field, exactly as the feed has it, and whether there is one
+	Field(name string) (string, bool)
+}
+
+// Location is where a record is in a feed: the file, and the line it starts on--0 when lines don't mean much
+type Location struct {
+	File string
+	Line int
+}
+
+// String is file:line, the way compilers and editors like it
+func (location Location) String() string {
+	if location.Line == 0 {
+		return location.File
+	}
+	return fmt.Sprintf("%s:%d", location.File, location.Line)
+}
+
+// RecordError is something wrong with a record--or with a single field of it--that knows where the record is
+type RecordError struct {
+	Location Location
+	Row      int
+	// Field is the name of the field at fault, and Column its place in the record counting from 1--both empty
+	// when it's the whole record
+	Field  string
+	Column int
+	Err    error
+}
+
+// Error points at the file, line and column, then says what's wrong
+func (err *RecordError) Error() string {
+	if err.Column == 0 {
+		return fmt.Sprintf("%s: row %d: %v", err.Location, err.Row, err.Err)
+	}
+	return fmt.Sprintf("%s:%d: row %d, %s: %v", err.Location, err.Column, err.Row, err.Field, err.Err)
+}
+
+// Unwrap is whatever went wrong, without the where
+func (err *RecordError) Unwrap() error {
+	return err.Err
+}
+
+// RecordErrorf is a RecordError about the whole of record
+func RecordErrorf(record Record, format string, args ...interface{}) error {
+	return &RecordError{Location: record.Location(), Row: record.Row(), Err: fmt.Errorf(format, args...)}
+}
+
+// FieldError is a RecordError about a single field of record.  An err that already knows where it is is left alone
+func FieldError(record Record, field string, err error) error {
+	var recordErr *RecordError
+	if err == nil || errors.As(err, &recordErr) {
+		return err
+	}
+	column := 0
+	for i, name := range record.Fields() {
+		if name == field {
+			column = i + 1
+			break
+		}
+	}
+	return &RecordError{Location: record.Location(), Row: record.Row(), Field: field, Column: column, Err: err}
+}
+
+// FieldRecord is a Record that's a list of named fields--a row of a CSV file with its headings, or anything
+// else that can be made to look like one.  Names and Values are paired by position; a value without a name
+// is nobody's, and a name without a value has none
+type FieldRecord struct {
+	Number int
+	Source Location
+	Names  []string
+	Values []string
+}
+
+// Row is the record's place in the feed
+func (record FieldRecord) Row() int {
+	return record.Number
+}
+
+// Location is where the record came from
+func (record FieldRecord) Location() Location {
+	return record.Source
+}
+
+// Fields are the record's names
+func (record FieldRecord) Fields() []string {
+	return record.Names
+}
+
+// Field is the value paired with name
+func (record FieldRecord) Field(name string) (string, bool) {
+	for i, field := range record.Names {
+		if field == name {
+			if i < len(record.Values) {
+				return record.Values[i], true
+			}
+			return "", false
+		}
+	}
+	return "", false
+}
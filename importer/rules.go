@@ -0,0 +1,251 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/seamuncle/dealer"
+)
+
+// Severity decides what happens to a vehicle that breaks a Rule
+type Severity int
+
+const (
+	// Warn logs it and carries on--the feed still gets its way
+	Warn Severity = iota
+	// RejectRow leaves the row out, as though it had been ambiguous: a vehicle inventory already has is left as it is,
+	// and one it doesn't isn't added
+	RejectRow
+	// HoldLot leaves the row's whole lot as inventory has it, until a feed comes along that doesn't break the rule
+	HoldLot
+)
+
+// ParseSeverity turns "warn", "reject" or "hold" into a Severity
+func ParseSeverity(value string) (Severity, error) {
+	for _, severity := range []Severity{Warn, RejectRow, HoldLot} {
+		if severity.String() == value {
+			return severity, nil
+		}
+	}
+	return Warn, fmt.Errorf("Parsing severity %q: expected warn, reject or hold", value)
+}
+
+// String is the name ParseSeverity knows a severity by
+func (severity Severity) String() string {
+	switch severity {
+	case RejectRow:
+		return "reject"
+	case HoldLot:
+		return "hold"
+	}
+	return "warn"
+}
+
+// MarshalText writes a Severity by name, in JSON and anywhere else
+func (severity Severity) MarshalText() ([]byte, error) {
+	return []byte(severity.String()), nil
+}
+
+// UnmarshalText reads a Severity by name
+func (severity *Severity) UnmarshalText(text []byte) error {
+	parsed, err := ParseSeverity(string(text))
+	if err != nil {
+		return err
+	}
+	*severity = parsed
+	return nil
+}
+
+// Rule is a business rule a vehicle in a feed has to keep to--something that parses fine but doesn't make sense.
+// Check describes what's wrong, or returns nil if nothing is.  It's given the vehicle the feed says, and the one
+// inventory has that it matched--nil for a vehicle inventory doesn't have on its lot yet
+type Rule struct {
+	Name     string
+	Severity Severity
+	Check    func(vehicle dealer.Vehicle, persisted *dealer.Vehicle) error
+}
+
+// Names of the rules that come with the importer
+const (
+	RulePriceFloor       = "price_floor"
+	RulePriceOverMSRP    = "price_over_msrp"
+	RuleYearRange        = "year_range"
+	RuleDoors            = "doors"
+	RuleOdometerDecrease = "odometer_decrease"
+)
+
+// PriceFloor is broken by a price under floor.  No price at all is "call for price", which is a different problem
+func PriceFloor(floor float64, severity Severity) Rule {
+	return Rule{Name: RulePriceFloor, Severity: severity, Check: func(vehicle dealer.Vehicle, persisted *dealer.Vehicle) error {
+		if vehicle.Price > 0 && vehicle.Price < floor {
+			return fmt.Errorf("Price %.2f is under %.2f", vehicle.Price, floor)
+		}
+		return nil
+	}}
+}
+
+// PriceOverMSRP is broken by a price more than percent over MSRP--when there's an MSRP to go by
+func PriceOverMSRP(percent float64, severity Severity) Rule {
+	return Rule{Name: RulePriceOverMSRP, Severity: severity, Check: func(vehicle dealer.Vehicle, persisted *dealer.Vehicle) error {
+		if vehicle.MSRP > 0 && vehicle.Price > vehicle.MSRP*(1+percent/100) {
+			return fmt.Errorf("Price %.2f is more than %g%% over MSRP %.2f", vehicle.Price, percent, vehicle.MSRP)
+		}
+		return nil
+	}}
+}
+
+// YearRange is broken by a year before first, or more than ahead years after this one
+func YearRange(first, ahead int, severity Severity) Rule {
+	return Rule{Name: RuleYearRange, Severity: severity, Check: func(vehicle dealer.Vehicle, persisted *dealer.Vehicle) error {
+		last := time.Now().Year() + ahead
+		if vehicle.Year < first || vehicle.Year > last {
+			return fmt.Errorf("Year %d is outside %d..%d", vehicle.Year, first, last)
+		}
+		return nil
+	}}
+}
+
+// Doors is broken by a number of doors outside min..max.  No doors at all is a feed that didn't say
+func Doors(min, max int, severity Severity) Rule {
+	return Rule{Name: RuleDoors, Severity: severity, Check: func(vehicle dealer.Vehicle, persisted *dealer.Vehicle) error {
+		if vehicle.Doors != 0 && (vehicle.Doors < min || vehicle.Doors > max) {
+			return fmt.Errorf("%d doors is outside %d..%d", vehicle.Doors, min, max)
+		}
+		return nil
+	}}
+}
+
+// OdometerDecrease is broken by an odometer reading less than inventory has--odometers don't go backwards,
+// so either the feed has it wrong or it's not the same vehicle.  It's the same comparison as the odometer anomaly;
+// the rule is for when a rollback should reject a row or hold a lot on its own, without -hold-anomalies
+func OdometerDecrease(severity Severity) Rule {
+	return Rule{Name: RuleOdometerDecrease, Severity: severity, Check: func(vehicle dealer.Vehicle, persisted *dealer.Vehicle) error {
+		if persisted != nil && persisted.OdometerRolledBack(vehicle.FeedVehicle) {
+			return fmt.Errorf("Odometer went from %d to %d", persisted.Odometer, vehicle.Odometer)
+		}
+		return nil
+	}}
+}
+
+// DefaultRules are the rules that come with the importer, with the limits most dealers will want--every one of them
+// only a warning, so nothing changes about what gets imported until somebody decides it should.  OdometerDecrease
+// isn't one of them, since every rollback is already logged as a suspicious change without it
+func DefaultRules() []Rule {
+	return []Rule{
+		PriceFloor(500, Warn),
+		PriceOverMSRP(20, Warn),
+		YearRange(1981, 1, Warn),
+		Doors(2, 5, Warn),
+	}
+}
+
+// RuleConfig is a rule as a rules file describes it: which rule, how severe, and whichever limits it takes.
+// The limits are pointers so a limit that was left out can't pass for one that's 0
+type RuleConfig struct {
+	Name     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	// Min is price_floor's floor, and the first year and fewest doors year_range and doors allow
+	Min *float64 `json:"min,omitempty"`
+	// Max is the most doors allowed
+	Max *float64 `json:"max,omitempty"`
+	// Percent is how far over MSRP a price can go
+	Percent *float64 `json:"percent,omitempty"`
+	// Ahead is how many years after this one a year can be--0 if it's left out, so nothing from next year
+	Ahead int `json:"ahead,omitempty"`
+}
+
+// Rule makes the configured rule, as long as it has the limits it needs.  A rule missing one would otherwise
+// quietly never fire, or fire on everything
+func (config RuleConfig) Rule() (Rule, error) {
+	switch config.Name {
+	case RulePriceFloor:
+		if config.Min == nil || *config.Min <= 0 {
+			return Rule{}, config.needs("a min over 0")
+		}
+		return PriceFloor(*config.Min, config.Severity), nil
+	case RulePriceOverMSRP:
+		if config.Percent == nil || *config.Percent < 0 {
+			return Rule{}, config.needs("a percent of 0 or more")
+		}
+		return PriceOverMSRP(*config.Percent, config.Severity), nil
+	case RuleYearRange:
+		if config.Min == nil || *config.Min <= 0 || config.Ahead < 0 {
+			return Rule{}, config.needs("a min year, and an ahead of 0 or more")
+		}
+		return YearRange(int(*config.Min), config.Ahead, config.Severity), nil
+	case RuleDoors:
+		if config.Min == nil || config.Max == nil || *config.Min < 1 || *config.Max < *config.Min {
+			return Rule{}, config.needs("a min of at least 1, and a max no less than it")
+		}
+		return Doors(int(*config.Min), int(*config.Max), config.Severity), nil
+	case RuleOdometerDecrease:
+		return OdometerDecrease(config.Severity), nil
+	}
+	return Rule{}, fmt.Errorf("Configuring rule: unknown rule %q", config.Name)
+}
+
+// needs is the error for a rule that's missing a limit, or has one that makes no sense
+func (config RuleConfig) needs(limits string) error {
+	return fmt.Errorf("Configuring rule %s: needs %s", config.Name, limits)
+}
+
+// LoadRules reads a rules file: a JSON array of RuleConfigs, like
+//
+//	[{"rule": "price_floor", "severity": "reject", "min": 1000},
+//	 {"rule": "year_range", "severity": "warn", "min": 1981, "ahead": 1},
+//	 {"rule": "doors", "severity": "hold", "min": 2, "max": 5},
+//	 {"rule": "odometer_decrease", "severity": "reject"}]
+func LoadRules(reader io.Reader) ([]Rule, error) {
+	var configs []RuleConfig
+	if err := json.NewDecoder(reader).Decode(&configs); err != nil {
+		return nil, fmt.Errorf("Reading rules: %w", err)
+	}
+	rules := make([]Rule, 0, len(configs))
+	for _, config := range configs {
+		rule, err := config.Rule()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Violation is a rule a row of a feed broke
+type Violation struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Row      int      `json:"row"`
+	VIN      string   `json:"vin"`
+	Stock    string   `json:"stock"`
+	Problem  string   `json:"problem"`
+}
+
+// checkRules runs every rule in the run's Config over a vehicle, logging and counting whatever it breaks, and
+// returns the violations along with the worst of their severities
+func (run *runState) checkRules(row int, vehicle dealer.Vehicle, persisted *dealer.Vehicle, log Logger) ([]Violation, Severity) {
+	var violations []Violation
+	worst := Warn
+	for _, rule := range run.Config.Rules {
+		err := rule.Check(vehicle, persisted)
+		if err == nil {
+			continue
+		}
+		log.Warn("Rule broken", "row", row, "vin", vehicle.VIN, "stock", vehicle.Stock, "rule", rule.Name, "severity", rule.Severity.String(), "problem", err.Error())
+		run.Config.Metrics.Add("dealer_import_rule_violations_total", 1, "feed", run.Config.Filename, "rule", rule.Name, "severity", rule.Severity.String())
+		violations = append(violations, Violation{
+			Rule:     rule.Name,
+			Severity: rule.Severity,
+			Row:      row,
+			VIN:      vehicle.VIN,
+			Stock:    vehicle.Stock,
+			Problem:  err.Error(),
+		})
+		if rule.Severity > worst {
+			worst = rule.Severity
+		}
+	}
+	return violations, worst
+}
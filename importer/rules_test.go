@@ -0,0 +1,68 @@
+package importer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/seamuncle/dealer"
+	"github.com/seamuncle/dealer/importer"
+)
+
+func TestLoadRules(t *testing.T) {
+	rules, err := importer.LoadRules(strings.NewReader(`
+		[{"rule": "price_floor", "severity": "reject", "min": 1000},
+		 {"rule": "price_over_msrp", "severity": "warn", "percent": 20},
+		 {"rule": "year_range", "severity": "warn", "min": 1981, "ahead": 1},
+		 {"rule": "doors", "severity": "warn", "min": 2, "max": 5},
+		 {"rule": "odometer_decrease", "severity": "hold"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rule := range rules {
+		got = append(got, rule.Name+" "+rule.Severity.String())
+	}
+	if want := "price_floor reject,price_over_msrp warn,year_range warn,doors warn,odometer_decrease hold"; strings.Join(got, ",") != want {
+		t.Errorf("Rules are %v, want %s", got, want)
+	}
+
+	for _, broken := range []string{
+		`[{"rule": "price_floor", "severity": "reject"}]`,
+		`[{"rule": "doors", "severity": "warn", "min": 5, "max": 2}]`,
+		`[{"rule": "odometer_decrease", "severity": "sometimes"}]`,
+		`[{"rule": "mileage", "severity": "warn"}]`,
+	} {
+		if _, err := importer.LoadRules(strings.NewReader(broken)); err == nil {
+			t.Errorf("Loaded %s", broken)
+		}
+	}
+}
+
+func TestOdometerDecrease(t *testing.T) {
+	rule := importer.OdometerDecrease(importer.RejectRow)
+	persisted := dealer.Vehicle{FeedVehicle: dealer.FeedVehicle{Odometer: 14575}}
+	for _, test := range []struct {
+		name      string
+		odometer  int
+		persisted *dealer.Vehicle
+		broken    bool
+	}{
+		{"went up", 15020, &persisted, false},
+		{"stayed put", 14575, &persisted, false},
+		{"went down", 1457, &persisted, true},
+		// Nothing to go backwards from
+		{"new to inventory", 0, nil, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			vehicle := dealer.Vehicle{FeedVehicle: dealer.FeedVehicle{Odometer: test.odometer}}
+			err := rule.Check(vehicle, test.persisted)
+			if (err != nil) != test.broken {
+				t.Errorf("Check gave %v, want broken %t", err, test.broken)
+			}
+			// The rule and the anomaly are the one comparison, so they always agree
+			if test.persisted != nil && (err != nil) != (len(test.persisted.Anomalies(vehicle.FeedVehicle)) > 0) {
+				t.Errorf("Rule gave %v, and anomalies %v", err, test.persisted.Anomalies(vehicle.FeedVehicle))
+			}
+		})
+	}
+}
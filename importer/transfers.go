@@ -0,0 +1,168 @@
+package importer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/seamuncle/dealer"
+)
+
+// lotKey is a lot the way a feed knows it
+type lotKey struct {
+	dealerID int
+	lotType  dealer.LotType
+}
+
+func keyOf(lot dealer.Lot) lotKey {
+	return lotKey{dealerID: lot.DealerID, lotType: lot.LotType}
+}
+
+// feedClaims is every lot in a feed listing each VIN, by normalized VIN.  It's what lets a lot tell the difference
+// between a vehicle that's gone, and one that's turned up on another lot and shouldn't be deleted on the way
+type feedClaims map[string][]lotKey
+
+// newFeedClaims collects the lots listing each VIN in a feed
+func newFeedClaims(vehicles []dealer.Vehicle) feedClaims {
+	claims := feedClaims{}
+	for _, vehicle := range vehicles {
+		vin := dealer.NormalizeVIN(vehicle.VIN)
+		if vin == "" || claims.claimedBy(vin, keyOf(vehicle.Lot)) {
+			continue
+		}
+		claims[vin] = append(claims[vin], keyOf(vehicle.Lot))
+	}
+	return claims
+}
+
+// claimedBy is whether lot lists the VIN
+func (claims feedClaims) claimedBy(vin string, lot lotKey) bool {
+	for _, claimant := range claims[dealer.NormalizeVIN(vin)] {
+		if claimant == lot {
+			return true
+		}
+	}
+	return false
+}
+
+// claimedElsewhere is whether any lot other than lot lists the VIN
+func (claims feedClaims) claimedElsewhere(vin string, lot lotKey) bool {
+	for _, claimant := range claims[dealer.NormalizeVIN(vin)] {
+		if claimant != lot {
+			return true
+		}
+	}
+	return false
+}
+
+// reportMultiDealer logs every VIN the feed puts on more than one dealer's lots at once--it can't be on both,
+// so one of them is wrong, and nobody gets a transfer out of it
+func (claims feedClaims) reportMultiDealer(log Logger, metrics *Metrics, filename string) {
+	vins := []string{}
+	for vin, claimants := range claims {
+		for _, claimant := range claimants[1:] {
+			if claimant.dealerID != claimants[0].dealerID {
+				vins = append(vins, vin)
+				break
+			}
+		}
+	}
+	sort.Strings(vins)
+
+	for _, vin := range vins {
+		lots := []string{}
+		for _, claimant := range claims[vin] {
+			lots = append(lots, fmt.Sprintf("%d:%s", claimant.dealerID, claimant.lotType))
+		}
+		log.Warn("VIN claimed by more than one dealer", "vin", vin, "lots", strings.Join(lots, " "))
+		metrics.Add("dealer_import_multi_dealer_claims_total", 1, "feed", filename)
+	}
+}
+
+// holdTransfers keeps vehicles the feed didn't list on their own lot, but did list on another, from being
+// deleted--whichever lot they're going to picks them up from here, whether it comes before or after this one
+func (run *runState) holdTransfers(set InventorySet, log Logger) {
+	lot := keyOf(set.Lot())
+	for _, vehicle := range set.Vehicles() {
+		if vehicle.State != dealer.StatePersisted || !run.claims.claimedElsewhere(vehicle.VIN, lot) {
+			continue
+		}
+		log.Info("Vehicle held for transfer", "v_id", vehicle.ID, "vin", vehicle.VIN)
+		vehicle.State = dealer.StateHeld
+		set.SetVehicle(vehicle)
+	}
+}
+
+// transferFrom looks for a vehicle on some other lot with the same VIN as a feed vehicle nothing on its own lot matched.
+// A vehicle the feed still lists on its current lot stays put; so does one on more than one other lot, since
+// there's no telling which of them is moving
+func (run *runState) transferFrom(db *gorm.DB, set InventorySet, vehicle dealer.FeedVehicle, log Logger) (dealer.Vehicle, dealer.Lot, bool, error) {
+	vin := dealer.NormalizeVIN(vehicle.VIN)
+	if vin == "" {
+		return dealer.Vehicle{}, dealer.Lot{}, false, nil
+	}
+
+	// LIKE narrows it down to VINs with the same letters and digits in the same order--and whatever else in between,
+	// which is why they're compared properly after
+	var others []dealer.Vehicle
+	if err := db.Where("vin LIKE ? AND lot_id <> ?", vinLike(vin), set.LotID()).Find(&others).Error; err != nil {
+		return dealer.Vehicle{}, dealer.Lot{}, false, fmt.Errorf("Looking for VIN %s on other lots: %w", vehicle.VIN, err)
+	}
+
+	candidates := []dealer.Vehicle{}
+	lots := []dealer.Lot{}
+	for _, other := range others {
+		if dealer.NormalizeVIN(other.VIN) != vin {
+			continue
+		}
+		lot, err := run.lotOf(db, other.LotID)
+		if err != nil {
+			return dealer.Vehicle{}, dealer.Lot{}, false, err
+		}
+		if run.claims.claimedBy(vin, keyOf(lot)) {
+			continue
+		}
+		other.Lot = lot
+		candidates = append(candidates, other)
+		lots = append(lots, lot)
+	}
+
+	switch len(candidates) {
+	case 0:
+		return dealer.Vehicle{}, dealer.Lot{}, false, nil
+	case 1:
+		return candidates[0], lots[0], true, nil
+	}
+	ids := []string{}
+	for _, candidate := range candidates {
+		ids = append(ids, fmt.Sprint(candidate.ID))
+	}
+	log.Warn("VIN on more than one other lot, not transferred", "vin", vehicle.VIN, "v_ids", strings.Join(ids, " "))
+	return dealer.Vehicle{}, dealer.Lot{}, false, nil
+}
+
+// vinLike is a LIKE pattern matching any VIN that normalizes to vin, and a few that don't.  A normalized VIN is
+// only letters and digits, so there's nothing in it to escape, and SQLite's LIKE takes care of case
+func vinLike(vin string) string {
+	var b strings.Builder
+	b.WriteString("%")
+	for _, r := range vin {
+		b.WriteRune(r)
+		b.WriteString("%")
+	}
+	return b.String()
+}
+
+// lotOf describes a lot by its master record
+func (run *runState) lotOf(db *gorm.DB, lotID int) (dealer.Lot, error) {
+	dealerLot, err := dealer.FindLot(db, lotID)
+	if err != nil {
+		return dealer.Lot{}, err
+	}
+	master, err := dealer.FindDealer(db, dealerLot.DealerID)
+	if err != nil {
+		return dealer.Lot{}, err
+	}
+	return dealerLot.Lot(master), nil
+}
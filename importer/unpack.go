@@ -0,0 +1,218 @@
+package importer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// DefaultMaxUnpackedSize is how much an Unpacker will decompress out of a single delivery unless it's told otherwise--
+// a lot more than any inventory file, and a lot less than a zip bomb
+const DefaultMaxUnpackedSize = 512 << 20
+
+// ErrNoFeedFiles is what unpacking an archive with nothing in it matching the patterns fails with
+var ErrNoFeedFiles = errors.New("No feed files in archive")
+
+// FeedFile is a single inventory file, out of whatever was delivered
+type FeedFile struct {
+	// Name is what the file's called: the delivery itself when it wasn't an archive, or delivery/entry when it was
+	Name string
+	Data []byte
+}
+
+// Unpacker gets inventory files out of what partners deliver: a file as it is, gzipped, or a bundle of them zipped
+// or tarred and gzipped.  It goes by what's in the file rather than what it's called, so a feed.csv that's really
+// a zip file still works
+type Unpacker struct {
+	// Patterns pick which entries in an archive are inventory files, matched against the entry's name and its path
+	// in the archive, the way path.Match does.  None means every file that isn't hidden
+	Patterns []string
+	// MaxSize is the most it'll decompress altogether--0 is DefaultMaxUnpackedSize
+	MaxSize int64
+}
+
+// Unpack turns a delivery into the inventory files in it
+func (unpacker Unpacker) Unpack(name string, reader io.Reader) ([]FeedFile, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Reading %s: %w", name, err)
+	}
+
+	switch {
+	case isXLSX(data):
+		// A workbook is a zip file too, but it's one feed file, not a bundle of them
+		return []FeedFile{{Name: name, Data: data}}, nil
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")) || bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		return unpacker.unzip(name, data)
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		inner, innerName, err := unpacker.gunzip(name, data)
+		if err != nil {
+			return nil, err
+		}
+		if isTar(inner) {
+			return unpacker.untar(name, inner)
+		}
+		return []FeedFile{{Name: innerName, Data: inner}}, nil
+	case isTar(data):
+		return unpacker.untar(name, data)
+	}
+	return []FeedFile{{Name: name, Data: data}}, nil
+}
+
+// gunzip decompresses a gzipped delivery, naming what's inside after the name it was gzipped with--or failing that,
+// the delivery without its .gz
+func (unpacker Unpacker) gunzip(name string, data []byte) ([]byte, string, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("Decompressing %s: %w", name, err)
+	}
+	defer reader.Close()
+	inner, err := unpacker.readLimited(name, reader, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	innerName := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".gzip")
+	if reader.Name != "" {
+		innerName = name + "/" + path.Base(reader.Name)
+	}
+	return inner, innerName, nil
+}
+
+// unzip pulls the inventory files out of a zip archive, in the order the archive has them
+func (unpacker Unpacker) unzip(name string, data []byte) ([]FeedFile, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("Opening zip archive %s: %w", name, err)
+	}
+
+	files := []FeedFile{}
+	var unpacked int64
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() || !unpacker.matches(entry.Name) {
+			continue
+		}
+		reader, err := entry.Open()
+		if err != nil {
+			return nil, fmt.Errorf("Opening %s in %s: %w", entry.Name, name, err)
+		}
+		contents, err := unpacker.readLimited(name, reader, unpacked)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		unpacked += int64(len(contents))
+		files = append(files, FeedFile{Name: name + "/" + entry.Name, Data: contents})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("Unpacking %s: %w", name, ErrNoFeedFiles)
+	}
+	return files, nil
+}
+
+// untar pulls the inventory files out of a tar archive, in the order the archive has them
+func (unpacker Unpacker) untar(name string, data []byte) ([]FeedFile, error) {
+	archive := tar.NewReader(bytes.NewReader(data))
+	files := []FeedFile{}
+	var unpacked int64
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Reading tar archive %s: %w", name, err)
+		}
+		if header.Typeflag != tar.TypeReg || !unpacker.matches(header.Name) {
+			continue
+		}
+		contents, err := unpacker.readLimited(name, archive, unpacked)
+		if err != nil {
+			return nil, err
+		}
+		unpacked += int64(len(contents))
+		files = append(files, FeedFile{Name: name + "/" + strings.TrimPrefix(header.Name, "./"), Data: contents})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("Unpacking %s: %w", name, ErrNoFeedFiles)
+	}
+	return files, nil
+}
+
+// matches is whether an entry in an archive is an inventory file
+func (unpacker Unpacker) matches(entry string) bool {
+	entry = strings.TrimPrefix(entry, "./")
+	base := path.Base(entry)
+	if len(unpacker.Patterns) == 0 {
+		// Hidden files, and the resource forks the Finder likes to zip up along with everything else
+		return !strings.HasPrefix(base, ".") && !strings.HasPrefix(entry, "__MACOSX/")
+	}
+	for _, pattern := range unpacker.Patterns {
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, entry); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readLimited reads everything from reader, so long as it and what's already been unpacked stay under MaxSize
+func (unpacker Unpacker) readLimited(name string, reader io.Reader, unpacked int64) ([]byte, error) {
+	max := unpacker.MaxSize
+	if max <= 0 {
+		max = DefaultMaxUnpackedSize
+	}
+	contents, err := ioutil.ReadAll(io.LimitReader(reader, max-unpacked+1))
+	if err != nil {
+		return nil, fmt.Errorf("Decompressing %s: %w", name, err)
+	}
+	if unpacked+int64(len(contents)) > max {
+		return nil, fmt.Errorf("Decompressing %s: more than %d bytes", name, max)
+	}
+	return contents, nil
+}
+
+// isTar is whether data looks like a tar archive--POSIX and GNU ones both say so 257 bytes in
+func isTar(data []byte) bool {
+	return len(data) >= 262 && string(data[257:262]) == "ustar"
+}
+
+// ReadFeed unpacks a delivery and reads every inventory file in it as CSV--or as a workbook, from wherever options
+// says, for any that turn out to be XLSX--one after another.  Rows are numbered right through from the first file to
+// the last; each record's Location says which file it's in
+func (format CSVFormat) ReadFeed(name string, reader io.Reader, unpacker Unpacker, options XLSXOptions) ([]Record, error) {
+	files, err := unpacker.Unpack(name, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []Record{}
+	for _, file := range files {
+		var read []Record
+		if isXLSX(file.Data) {
+			read, err = format.ReadXLSX(file.Name, bytes.NewReader(file.Data), options)
+		} else {
+			read, _, err = format.ReadCSV(file.Name, bytes.NewReader(file.Data))
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range read {
+			if fieldRecord, ok := record.(FieldRecord); ok {
+				fieldRecord.Number = len(records) + 1
+				record = fieldRecord
+			}
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
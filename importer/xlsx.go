@@ -0,0 +1,480 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XLSXOptions say where in a workbook the inventory is
+type XLSXOptions struct {
+	// Sheet is the sheet's name, or its place in the workbook counting from 1--empty is the first sheet
+	Sheet string
+	// HeaderRow is the row the headings are on, counting from 1.  0 looks for the first row with every heading the
+	// format requires, a few rows down at most, since people like a title or two above a table
+	HeaderRow int
+}
+
+// headerSearchRows is how far down a sheet ReadXLSX looks for headings
+const headerSearchRows = 20
+
+// ReadXLSX reads inventory out of an Excel workbook the way ReadCSV reads it out of a CSV file, so the same importer can
+// process either.  Cells come out as the text a CSV export would have: numbers without their formatting, dates as
+// 2006-01-02, and booleans as TRUE or FALSE.  Each record's Location is the workbook and sheet, and the row number
+// Excel shows
+func (format CSVFormat) ReadXLSX(name string, reader io.Reader, options XLSXOptions) ([]Record, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Reading %s: %w", name, err)
+	}
+	book, err := openWorkbook(data)
+	if err != nil {
+		return nil, fmt.Errorf("Opening workbook %s: %w", name, err)
+	}
+	sheet, err := book.sheet(options.Sheet)
+	if err != nil {
+		return nil, fmt.Errorf("Opening workbook %s: %w", name, err)
+	}
+	rows, err := book.rows(sheet)
+	if err != nil {
+		return nil, fmt.Errorf("Reading sheet %s of %s: %w", sheet.Name, name, err)
+	}
+	file := fmt.Sprintf("%s[%s]", name, sheet.Name)
+
+	header := -1
+	for i, row := range rows {
+		if options.HeaderRow > 0 {
+			if row.line == options.HeaderRow {
+				header = i
+				break
+			}
+			continue
+		}
+		if i >= headerSearchRows {
+			break
+		}
+		if header < 0 {
+			// Failing anything better, the first row is the headings
+			header = i
+		}
+		if format.hasRequired(row.fields) {
+			header = i
+			break
+		}
+	}
+	if header < 0 {
+		return nil, fmt.Errorf("Reading %s: no headings", file)
+	}
+
+	headings, err := format.headings(file, rows[header])
+	if err != nil {
+		return nil, err
+	}
+	records := []Record{}
+	var ragged RowErrors
+	for _, row := range rows[header+1:] {
+		record := FieldRecord{
+			Number: len(records) + len(ragged) + 1,
+			Source: Location{File: file, Line: row.line},
+			Names:  headings,
+			Values: row.fields,
+		}
+		// Spreadsheets leave off empty cells at the end of a row, which is fine; cells past the last heading aren't
+		for len(record.Values) > len(headings) && record.Values[len(record.Values)-1] == "" {
+			record.Values = record.Values[:len(record.Values)-1]
+		}
+		if len(record.Values) > len(headings) {
+			ragged = append(ragged, &RecordError{
+				Location: record.Source,
+				Row:      record.Number,
+				Err:      fmt.Errorf("%d cells, and %d headings", len(record.Values), len(headings)),
+			})
+			continue
+		}
+		for len(record.Values) < len(headings) {
+			record.Values = append(record.Values, "")
+		}
+		records = append(records, record)
+	}
+	if len(ragged) > 0 {
+		return nil, ragged
+	}
+	return records, nil
+}
+
+// hasRequired is whether a row has every heading format requires
+func (format CSVFormat) hasRequired(fields []string) bool {
+	if len(format.Required) == 0 {
+		return false
+	}
+	have := map[string]bool{}
+	for _, field := range fields {
+		have[strings.ToLower(strings.TrimSpace(field))] = true
+	}
+	for _, heading := range format.Required {
+		if !have[strings.ToLower(heading)] {
+			return false
+		}
+	}
+	return true
+}
+
+// isXLSX is whether data is an Excel workbook rather than a zip of something else
+func isXLSX(data []byte) bool {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return false
+	}
+	for _, entry := range archive.File {
+		if entry.Name == "xl/workbook.xml" {
+			return true
+		}
+	}
+	return false
+}
+
+// workbook is the bits of an XLSX file it takes to read cells out of it
+type workbook struct {
+	files   map[string]*zip.File
+	sheets  []workbookSheet
+	strings []string
+	// dates are which cell styles, by index, format numbers as dates
+	dates    map[int]bool
+	date1904 bool
+}
+
+// workbookSheet is a sheet, and the part of the file it's in
+type workbookSheet struct {
+	Name string
+	part string
+}
+
+func openWorkbook(data []byte) (workbook, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return workbook{}, err
+	}
+	book := workbook{files: map[string]*zip.File{}, dates: map[int]bool{}}
+	for _, entry := range archive.File {
+		book.files[entry.Name] = entry
+	}
+
+	var root struct {
+		Properties struct {
+			Date1904 bool `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err = book.decode("xl/workbook.xml", &root); err != nil {
+		return book, err
+	}
+	book.date1904 = root.Properties.Date1904
+
+	var relationships struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err = book.decode("xl/_rels/workbook.xml.rels", &relationships); err != nil {
+		return book, err
+	}
+	targets := map[string]string{}
+	for _, relationship := range relationships.Relationships {
+		// Targets are relative to xl/, unless they start with a slash
+		target := relationship.Target
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+		targets[relationship.ID] = target
+	}
+	for _, sheet := range root.Sheets {
+		book.sheets = append(book.sheets, workbookSheet{Name: sheet.Name, part: targets[sheet.ID]})
+	}
+
+	// A workbook with nothing but numbers in it needn't have any shared strings, or styles
+	if _, ok := book.files["xl/sharedStrings.xml"]; ok {
+		var shared struct {
+			Items []struct {
+				Text string `xml:"t"`
+				Runs []struct {
+					Text string `xml:"t"`
+				} `xml:"r"`
+			} `xml:"si"`
+		}
+		if err = book.decode("xl/sharedStrings.xml", &shared); err != nil {
+			return book, err
+		}
+		for _, item := range shared.Items {
+			text := item.Text
+			for _, run := range item.Runs {
+				text += run.Text
+			}
+			book.strings = append(book.strings, text)
+		}
+	}
+	if _, ok := book.files["xl/styles.xml"]; ok {
+		var styles struct {
+			Formats []struct {
+				ID   int    `xml:"numFmtId,attr"`
+				Code string `xml:"formatCode,attr"`
+			} `xml:"numFmts>numFmt"`
+			Cells []struct {
+				Format int `xml:"numFmtId,attr"`
+			} `xml:"cellXfs>xf"`
+		}
+		if err = book.decode("xl/styles.xml", &styles); err != nil {
+			return book, err
+		}
+		custom := map[int]string{}
+		for _, format := range styles.Formats {
+			custom[format.ID] = format.Code
+		}
+		for i, cell := range styles.Cells {
+			if code, ok := custom[cell.Format]; ok {
+				book.dates[i] = isDateFormat(code)
+			} else {
+				book.dates[i] = isBuiltinDateFormat(cell.Format)
+			}
+		}
+	}
+	return book, nil
+}
+
+// decode unmarshals a part of the workbook
+func (book workbook) decode(part string, value interface{}) error {
+	entry, ok := book.files[part]
+	if !ok {
+		return fmt.Errorf("Finding %s: not in workbook", part)
+	}
+	reader, err := entry.Open()
+	if err != nil {
+		return fmt.Errorf("Opening %s: %w", part, err)
+	}
+	defer reader.Close()
+	if err = xml.NewDecoder(reader).Decode(value); err != nil {
+		return fmt.Errorf("Decoding %s: %w", part, err)
+	}
+	return nil
+}
+
+// sheet finds a sheet by name or by its place in the workbook
+func (book workbook) sheet(which string) (workbookSheet, error) {
+	if len(book.sheets) == 0 {
+		return workbookSheet{}, fmt.Errorf("Finding sheet: workbook has none")
+	}
+	if which == "" {
+		return book.sheets[0], nil
+	}
+	for _, sheet := range book.sheets {
+		if strings.EqualFold(sheet.Name, which) {
+			return sheet, nil
+		}
+	}
+	if index, err := strconv.Atoi(which); err == nil && index >= 1 && index <= len(book.sheets) {
+		return book.sheets[index-1], nil
+	}
+	names := make([]string, len(book.sheets))
+	for i, sheet := range book.sheets {
+		names[i] = sheet.Name
+	}
+	return workbookSheet{}, fmt.Errorf("Finding sheet %s: workbook only has %s", which, strings.Join(names, ", "))
+}
+
+// rows reads every row with anything in it out of a sheet, with each cell where its column says it goes
+func (book workbook) rows(sheet workbookSheet) ([]csvRow, error) {
+	var data struct {
+		Rows []struct {
+			Number int `xml:"r,attr"`
+			Cells  []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Style  int    `xml:"s,attr"`
+				Value  string `xml:"v"`
+				Inline struct {
+					Text string `xml:"t"`
+					Runs []struct {
+						Text string `xml:"t"`
+					} `xml:"r"`
+				} `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := book.decode(sheet.part, &data); err != nil {
+		return nil, err
+	}
+
+	rows := []csvRow{}
+	for i, row := range data.Rows {
+		line := row.Number
+		if line == 0 {
+			// Rows don't have to say which they are, in which case they come in order
+			line = i + 1
+			if len(rows) > 0 && rows[len(rows)-1].line >= line {
+				line = rows[len(rows)-1].line + 1
+			}
+		}
+		fields := []string{}
+		empty := true
+		for j, cell := range row.Cells {
+			column := j
+			if cell.Ref != "" {
+				column = columnIndex(cell.Ref)
+			}
+			var value string
+			switch cell.Type {
+			case "s":
+				index, err := strconv.Atoi(cell.Value)
+				if err != nil || index < 0 || index >= len(book.strings) {
+					return nil, fmt.Errorf("Reading cell %s: no shared string %s", cell.Ref, cell.Value)
+				}
+				value = book.strings[index]
+			case "inlineStr":
+				value = cell.Inline.Text
+				for _, run := range cell.Inline.Runs {
+					value += run.Text
+				}
+			case "b":
+				value = "FALSE"
+				if cell.Value == "1" {
+					value = "TRUE"
+				}
+			case "e":
+				// #N/A and friends are as good as nothing
+			case "d":
+				// An ISO 8601 date, and maybe a time nobody wants
+				value = strings.SplitN(cell.Value, "T", 2)[0]
+			case "str":
+				value = cell.Value
+			default:
+				value = book.number(cell.Value, cell.Style)
+			}
+			for len(fields) < column {
+				fields = append(fields, "")
+			}
+			if column < len(fields) {
+				fields[column] = value
+			} else {
+				fields = append(fields, value)
+			}
+			if strings.TrimSpace(value) != "" {
+				empty = false
+			}
+		}
+		if !empty {
+			rows = append(rows, csvRow{line: line, fields: fields})
+		}
+	}
+	return rows, nil
+}
+
+// number is a numeric cell as text: a date if its style says so, otherwise the number without Excel's
+// floating point fuzz
+func (book workbook) number(value string, style int) string {
+	if value == "" {
+		return ""
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value
+	}
+	if book.dates[style] {
+		epoch := time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)
+		if book.date1904 {
+			epoch = time.Date(1904, time.January, 1, 0, 0, 0, 0, time.UTC)
+		}
+		return epoch.AddDate(0, 0, int(math.Floor(f))).Format("2006-01-02")
+	}
+	return strconv.FormatFloat(math.Round(f*1e9)/1e9, 'f', -1, 64)
+}
+
+// columnIndex is where a cell reference like AB12 goes in a row, counting from 0
+func columnIndex(ref string) int {
+	column := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = column*26 + int(r-'A') + 1
+	}
+	return column - 1
+}
+
+// isBuiltinDateFormat is whether one of the number formats every workbook has without saying so is a date.  18 to 21
+// and 45 to 47 are times of day with no date to them, which a CSV export leaves as a number
+func isBuiltinDateFormat(id int) bool {
+	return (id >= 14 && id <= 17) || id == 22 || (id >= 27 && id <= 36) || (id >= 50 && id <= 58)
+}
+
+// isDateFormat is whether a custom number format shows a date: it has days, months or years in it, outside
+// of quotes, escapes and [colour]s.  An m is months, except right after hours or right before seconds where it's
+// minutes--so h:mm:ss is a time and not a date, the same as Excel decides it
+func isDateFormat(code string) bool {
+	parts := dateTimeParts(code)
+	for i, part := range parts {
+		switch part {
+		case 'd', 'y':
+			return true
+		case 'm':
+			minutes := (i > 0 && parts[i-1] == 'h') || (i+1 < len(parts) && parts[i+1] == 's')
+			if !minutes {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dateTimeParts is the letters of a number format that stand for a part of a date or time--d, m, y, h or s, once for
+// each run of them--in order.  Quotes, escapes, [colour]s and AM/PM don't count, but an elapsed [h], [mm] or [ss] does
+func dateTimeParts(code string) []rune {
+	code = strings.ToLower(code)
+	parts := []rune{}
+	add := func(r rune) {
+		if len(parts) == 0 || parts[len(parts)-1] != r {
+			parts = append(parts, r)
+		}
+	}
+	quoted, escaped := false, false
+	for i := 0; i < len(code); i++ {
+		c := rune(code[i])
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[':
+			end := strings.IndexByte(code[i:], ']')
+			if end < 0 {
+				return parts
+			}
+			if elapsed := code[i+1 : i+end]; elapsed != "" && strings.Trim(elapsed, "hms") == "" && strings.Trim(elapsed, elapsed[:1]) == "" {
+				add(rune(elapsed[0]))
+			}
+			i += end
+		case strings.HasPrefix(code[i:], "am/pm"):
+			i += len("am/pm") - 1
+		case strings.HasPrefix(code[i:], "a/p"):
+			i += len("a/p") - 1
+		case c == 'd' || c == 'm' || c == 'y' || c == 'h' || c == 's':
+			add(c)
+		}
+	}
+	return parts
+}
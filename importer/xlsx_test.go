@@ -0,0 +1,234 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testWorkbook is an XLSX file with a sheet for each of sheets, in order, and the shared strings and cell styles
+// their XML refers to.  Style 1 is a built-in date format, and style 2 a custom one
+func testWorkbook(t *testing.T, shared []string, sheets ...[2]string) []byte {
+	t.Helper()
+	var workbook, relationships strings.Builder
+	parts := map[string]string{}
+	for i, sheet := range sheets {
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, sheet[0], i+1, i+1)
+		fmt.Fprintf(&relationships, `<Relationship Id="rId%d" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+		parts[fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)] = `<worksheet><sheetData>` + sheet[1] + `</sheetData></worksheet>`
+	}
+	parts["xl/workbook.xml"] = `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` +
+		workbook.String() + `</sheets></workbook>`
+	parts["xl/_rels/workbook.xml.rels"] = `<Relationships>` + relationships.String() + `</Relationships>`
+	var sharedStrings strings.Builder
+	for _, text := range shared {
+		fmt.Fprintf(&sharedStrings, `<si><t>%s</t></si>`, text)
+	}
+	parts["xl/sharedStrings.xml"] = `<sst>` + sharedStrings.String() + `</sst>`
+	parts["xl/styles.xml"] = `<styleSheet><numFmts><numFmt numFmtId="164" formatCode="yyyy\-mm\-dd"/></numFmts>` +
+		`<cellXfs><xf numFmtId="0"/><xf numFmtId="14"/><xf numFmtId="164"/></cellXfs></styleSheet>`
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for name, content := range parts {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// headingRow is the headings testFormat needs, and a few it doesn't, as shared strings 0 to 5
+const headingRow = `<c r="A%[1]d" t="s"><v>0</v></c><c r="B%[1]d" t="s"><v>1</v></c><c r="C%[1]d" t="s"><v>2</v></c>` +
+	`<c r="D%[1]d" t="s"><v>3</v></c><c r="E%[1]d" t="s"><v>4</v></c><c r="F%[1]d" t="s"><v>5</v></c>`
+
+// testShared are the workbooks' shared strings
+var testShared = []string{"DealerID", "Stock", "VIN", "Price", "DateInStock", "Certified", "Inventory for March"}
+
+func TestReadXLSX(t *testing.T) {
+	sheet := `<row r="1"><c r="A1" t="s"><v>6</v></c></row>` +
+		`<row r="3">` + fmt.Sprintf(headingRow, 3) + `</row>` +
+		// Numbers come out the way a CSV export would have them, dates and booleans too
+		`<row r="4"><c r="A4"><v>1001</v></c><c r="B4" t="inlineStr"><is><t>A124</t></is></c><c r="C4" t="str"><v>1GCEP22T1G3329139</v></c>` +
+		`<c r="D4"><v>31509.000000000004</v></c><c r="E4" s="1"><v>43101</v></c><c r="F4" t="b"><v>1</v></c></row>` +
+		// Cells left out are empty, and so are errors
+		`<row r="6"><c r="A6"><v>1001</v></c><c r="B6" t="inlineStr"><is><r><t>A</t></r><r><t>130</t></r></is></c>` +
+		`<c r="D6" t="e"><v>#N/A</v></c><c r="E6" s="2"><v>43102.5</v></c></row>` +
+		// A row with nothing in it isn't a row
+		`<row r="7"><c r="A7" t="inlineStr"><is><t> </t></is></c></row>`
+	data := testWorkbook(t, testShared, [2]string{"Cover", `<row r="1"><c r="A1" t="s"><v>6</v></c></row>`}, [2]string{"Inventory", sheet})
+
+	records, err := testFormat.ReadXLSX("feed.xlsx", bytes.NewReader(data), XLSXOptions{Sheet: "inventory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]string{
+		{"DealerID": "1001", "Stock": "A124", "VIN": "1GCEP22T1G3329139", "Price": "31509", "DateInStock": "2018-01-01", "Certified": "TRUE"},
+		{"DealerID": "1001", "Stock": "A130", "VIN": "", "Price": "", "DateInStock": "2018-01-02", "Certified": ""},
+	}
+	if got := fieldsOf(t, records); !reflect.DeepEqual(got, want) {
+		t.Errorf("Records are %v, want %v", got, want)
+	}
+	for i, line := range []int{4, 6} {
+		if got, want := records[i].Location(), (Location{File: "feed.xlsx[Inventory]", Line: line}); got != want {
+			t.Errorf("Record %d is at %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestReadXLSXSheetsAndHeadings(t *testing.T) {
+	first := `<row r="1">` + fmt.Sprintf(headingRow, 1) + `</row><row r="2"><c r="A2"><v>1001</v></c><c r="B2" t="s"><v>6</v></c></row>`
+	// Headings a user said were on row 2, under a row that only looks like them--left to itself, ReadXLSX would
+	// take the first
+	second := `<row r="1">` + fmt.Sprintf(headingRow, 1) + `</row><row r="2">` + fmt.Sprintf(headingRow, 2) + `</row>` +
+		`<row r="3"><c r="A3"><v>1022</v></c></row>`
+	data := testWorkbook(t, testShared, [2]string{"Sheet1", first}, [2]string{"Sheet2", second})
+
+	for _, test := range []struct {
+		name    string
+		options XLSXOptions
+		dealer  string
+		line    int
+	}{
+		{"first sheet", XLSXOptions{}, "1001", 2},
+		{"sheet by number", XLSXOptions{Sheet: "2", HeaderRow: 2}, "1022", 3},
+		{"sheet by name", XLSXOptions{Sheet: "sheet2", HeaderRow: 2}, "1022", 3},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := testFormat.ReadXLSX("feed.xlsx", bytes.NewReader(data), test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 {
+				t.Fatalf("%d records, want 1", len(records))
+			}
+			if dealer, _ := records[0].Field("DealerID"); dealer != test.dealer {
+				t.Errorf("DealerID is %s, want %s", dealer, test.dealer)
+			}
+			if line := records[0].Location().Line; line != test.line {
+				t.Errorf("Record is on line %d, want %d", line, test.line)
+			}
+		})
+	}
+}
+
+func TestDateFormats(t *testing.T) {
+	for id, want := range map[int]bool{
+		0: false, 2: false, 14: true, 17: true, 22: true, 30: true,
+		// Times of day are left as the number they are
+		18: false, 20: false, 21: false, 45: false, 46: false, 47: false,
+	} {
+		if got := isBuiltinDateFormat(id); got != want {
+			t.Errorf("Built-in format %d is a date: %t, want %t", id, got, want)
+		}
+	}
+
+	for code, want := range map[string]bool{
+		"General":               false,
+		"0.00":                  false,
+		"yyyy-mm-dd":            true,
+		"d-mmm":                 true,
+		"mmm yyyy":              true,
+		"mm/dd/yyyy h:mm AM/PM": true,
+		// An m after hours, or before seconds, is minutes
+		"h:mm":       false,
+		"hh:mm:ss":   false,
+		"mm:ss":      false,
+		"mm:ss.0":    false,
+		"[h]:mm:ss":  false,
+		"[mm]:ss":    false,
+		"h:mm AM/PM": false,
+		"h:mm a/p":   false,
+		// ...and anything else is months
+		"h:mm dd/mm":  true,
+		"mm":          true,
+		"[Red]0.00":   false,
+		`"days" 0`:    false,
+		`0 \d`:        false,
+		"[$-409]h:mm": false,
+	} {
+		if got := isDateFormat(code); got != want {
+			t.Errorf("%q is a date: %t, want %t", code, got, want)
+		}
+	}
+}
+
+func TestReadXLSXErrors(t *testing.T) {
+	tooWide := `<row r="1">` + fmt.Sprintf(headingRow, 1) + `</row><row r="2"><c r="A2"><v>1001</v></c><c r="H2"><v>1</v></c></row>`
+	badString := `<row r="1">` + fmt.Sprintf(headingRow, 1) + `</row><row r="2"><c r="A2" t="s"><v>99</v></c></row>`
+	for _, test := range []struct {
+		name    string
+		data    []byte
+		options XLSXOptions
+	}{
+		{"not a workbook", []byte("DealerID,Stock,VIN\n"), XLSXOptions{}},
+		{"no such sheet", testWorkbook(t, testShared, [2]string{"Sheet1", tooWide}), XLSXOptions{Sheet: "Sheet9"}},
+		{"cells past the last heading", testWorkbook(t, testShared, [2]string{"Sheet1", tooWide}), XLSXOptions{}},
+		{"no such shared string", testWorkbook(t, testShared, [2]string{"Sheet1", badString}), XLSXOptions{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if records, err := testFormat.ReadXLSX("feed.xlsx", bytes.NewReader(test.data), test.options); err == nil {
+				t.Errorf("Read %d records, want an error", len(records))
+			}
+		})
+	}
+}
+
+func TestReadFeedXLSXInZip(t *testing.T) {
+	first := `<row r="1">` + fmt.Sprintf(headingRow, 1) + `</row><row r="2"><c r="A2"><v>1001</v></c></row>`
+	second := `<row r="1"><c r="A1" t="s"><v>6</v></c></row><row r="2">` + fmt.Sprintf(headingRow, 2) + `</row>` +
+		`<row r="3"><c r="A3"><v>1022</v></c><c r="B3" t="inlineStr"><is><t>Z205</t></is></c></row>`
+	workbook := testWorkbook(t, testShared, [2]string{"Cover", first}, [2]string{"Inventory", second})
+
+	var delivery bytes.Buffer
+	archive := zip.NewWriter(&delivery)
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{
+		{"inventory/lot_a.xlsx", workbook},
+		{"inventory/lot_b.csv", []byte("DealerID,Stock,VIN\n1033,C300,KM8SB12B02U162029\n")},
+	} {
+		w, err := archive.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sheet and heading row the caller asked for hold inside an archive too, not just for a bare workbook
+	records, err := testFormat.ReadFeed("feed.zip", &delivery, Unpacker{}, XLSXOptions{Sheet: "Inventory", HeaderRow: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]string{
+		{"DealerID": "1022", "Stock": "Z205", "VIN": "", "Price": "", "DateInStock": "", "Certified": ""},
+		{"DealerID": "1033", "Stock": "C300", "VIN": "KM8SB12B02U162029"},
+	}
+	if got := fieldsOf(t, records); !reflect.DeepEqual(got, want) {
+		t.Errorf("Records are %v, want %v", got, want)
+	}
+	for i, location := range []Location{{File: "feed.zip/inventory/lot_a.xlsx[Inventory]", Line: 3}, {File: "feed.zip/inventory/lot_b.csv", Line: 2}} {
+		if got := records[i].Location(); got.File != location.File || got.Line != location.Line {
+			t.Errorf("Record %d is at %v, want %v", i+1, got, location)
+		}
+		if row := records[i].Row(); row != i+1 {
+			t.Errorf("Record %d is row %d", i+1, row)
+		}
+	}
+}
@@ -1,5 +1,13 @@
 package dealer
 
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
 // LotType represents the type of lot a bit of inventory is addressing
 type LotType string
 
@@ -8,12 +16,161 @@ const (
 	TypeNew LotType = "NEW"
 	// TypeUsed represents an inventorySet or Vehicle attached to a "lot of" used vehicles
 	TypeUsed = "USED"
+	// TypeCertified represents a "lot of" certified pre-owned vehicles
+	TypeCertified LotType = "CPO"
+	// TypeDemo represents a "lot of" demonstrators--new, but they've been driven
+	TypeDemo LotType = "DEMO"
+	// TypeWholesale represents a "lot of" vehicles headed to auction or other dealers, not retail customers
+	TypeWholesale LotType = "WHOLESALE"
+	// TypeLoaner represents a "lot of" service loaners
+	TypeLoaner LotType = "LOANER"
+	// TypeInTransit represents a "lot of" vehicles ordered but not yet delivered to the dealer
+	TypeInTransit LotType = "IN_TRANSIT"
 )
 
+// ErrUnknownLotType is returned when a feed uses a lot type nobody has registered
+var ErrUnknownLotType = errors.New("unknown lot type")
+
+// LotTypeInfo is what we know about a lot type: what feeds call it, and what to expect of vehicles on it
+type LotTypeInfo struct {
+	Type LotType
+	// FeedValues are what feeds call this lot type--matched without regard to case or spacing.
+	// The first is what we call it when we're the ones writing a feed
+	FeedValues []string
+	// MaxOdometer is the most a vehicle on this lot should have on it--0 expects nothing in particular
+	MaxOdometer int
+	// MinOdometer is the least a vehicle on this lot should have on it
+	MinOdometer int
+}
+
+// lotTypes is the registry of lot types, keyed by type and by normalized feed value
+var lotTypes = struct {
+	sync.RWMutex
+	byType      map[LotType]LotTypeInfo
+	byFeedValue map[string]LotType
+}{
+	byType:      map[LotType]LotTypeInfo{},
+	byFeedValue: map[string]LotType{},
+}
+
+func init() {
+	// A few hundred delivery miles is normal on a new vehicle; any more and it's been somewhere
+	builtins := []LotTypeInfo{
+		{Type: TypeNew, FeedValues: []string{"New"}, MaxOdometer: 500},
+		{Type: TypeUsed, FeedValues: []string{"Used", "Pre-Owned", "Preowned"}},
+		{Type: TypeCertified, FeedValues: []string{"Certified", "CPO", "Certified Pre-Owned", "Certified Used"}},
+		{Type: TypeDemo, FeedValues: []string{"Demo", "Demonstrator"}, MaxOdometer: 15000},
+		{Type: TypeWholesale, FeedValues: []string{"Wholesale"}},
+		{Type: TypeLoaner, FeedValues: []string{"Loaner", "Service Loaner", "Courtesy"}, MaxOdometer: 25000},
+		{Type: TypeInTransit, FeedValues: []string{"In Transit", "In-Transit", "Transit", "On Order"}, MaxOdometer: 500},
+	}
+	for _, info := range builtins {
+		if err := RegisterLotType(info); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterLotType adds a lot type to the registry, or replaces what we know about one already there.
+// A feed value can only belong to one lot type
+func RegisterLotType(info LotTypeInfo) error {
+	lotTypes.Lock()
+	defer lotTypes.Unlock()
+
+	for _, value := range info.FeedValues {
+		if existing, ok := lotTypes.byFeedValue[normalizeFeedValue(value)]; ok && existing != info.Type {
+			return fmt.Errorf("Registering lot type %s: feed value %q already belongs to %s", info.Type, value, existing)
+		}
+	}
+
+	if previous, ok := lotTypes.byType[info.Type]; ok {
+		for _, value := range previous.FeedValues {
+			delete(lotTypes.byFeedValue, normalizeFeedValue(value))
+		}
+	}
+	lotTypes.byType[info.Type] = info
+	for _, value := range info.FeedValues {
+		lotTypes.byFeedValue[normalizeFeedValue(value)] = info.Type
+	}
+	return nil
+}
+
+// ParseLotType turns what a feed calls a lot type into a LotType, with an ErrUnknownLotType in the chain
+// if it's nothing we've heard of.  A feed giving us the LotType itself ("CPO", "IN_TRANSIT") is fine too
+func ParseLotType(value string) (LotType, error) {
+	lotTypes.RLock()
+	defer lotTypes.RUnlock()
+
+	if lotType, ok := lotTypes.byFeedValue[normalizeFeedValue(value)]; ok {
+		return lotType, nil
+	}
+	if _, ok := lotTypes.byType[LotType(strings.ToUpper(strings.TrimSpace(value)))]; ok {
+		return LotType(strings.ToUpper(strings.TrimSpace(value))), nil
+	}
+	return "", fmt.Errorf("Parsing lot type %q: %w", value, ErrUnknownLotType)
+}
+
+// LotTypes lists every registered lot type, in order of type
+func LotTypes() []LotTypeInfo {
+	lotTypes.RLock()
+	defer lotTypes.RUnlock()
+
+	infos := make([]LotTypeInfo, 0, len(lotTypes.byType))
+	for _, info := range lotTypes.byType {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Type < infos[j].Type })
+	return infos
+}
+
+// Info returns what the registry knows about a lot type
+func (lotType LotType) Info() (LotTypeInfo, bool) {
+	lotTypes.RLock()
+	defer lotTypes.RUnlock()
+
+	info, ok := lotTypes.byType[lotType]
+	return info, ok
+}
+
+// FeedValue is what to call a lot type when writing a feed
+func (lotType LotType) FeedValue() string {
+	if info, ok := lotType.Info(); ok && len(info.FeedValues) > 0 {
+		return info.FeedValues[0]
+	}
+	return string(lotType)
+}
+
+// Check compares a vehicle against what's expected of vehicles on this type of lot, and
+// describes anything that doesn't fit.  None of it's fatal--a NEW unit with 2000km on it
+// happens--but it's usually worth a look
+func (lotType LotType) Check(vehicle FeedVehicle) []error {
+	info, ok := lotType.Info()
+	if !ok {
+		return []error{fmt.Errorf("Checking lot type %s: %w", lotType, ErrUnknownLotType)}
+	}
+
+	problems := []error{}
+	if info.MaxOdometer > 0 && vehicle.Odometer > info.MaxOdometer {
+		problems = append(problems, fmt.Errorf("Odometer %d is more than the %d expected of a %s vehicle", vehicle.Odometer, info.MaxOdometer, lotType))
+	}
+	if vehicle.Odometer < info.MinOdometer {
+		problems = append(problems, fmt.Errorf("Odometer %d is less than the %d expected of a %s vehicle", vehicle.Odometer, info.MinOdometer, lotType))
+	}
+	return problems
+}
+
+// normalizeFeedValue is how feed values get compared--"In-Transit", "in transit" and "IN  TRANSIT" are all the same thing
+func normalizeFeedValue(value string) string {
+	value = strings.ToLower(strings.Replace(strings.Replace(value, "-", " ", -1), "_", " ", -1))
+	return strings.Join(strings.Fields(value), " ")
+}
+
 // Lot represents an abstraction for where a vehicle belongs.
 // It will belong to a dealer--with an ID and Name, and a lot type
+// This is the lot as a feed describes it; the dealers and lots tables hold the master records
+// (see Dealer and DealerLot) and are what gets believed when the two disagree
 type Lot struct {
-	DealerID   int     `gorm:"column:d_id"`
-	DealerName string  `gorm:"column:d_name"` // Third normal brain is screaming at me
-	LotType    LotType `gorm:"column:stock_type"`
+	DealerID   int
+	DealerName string
+	LotType    LotType
 }
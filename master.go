@@ -0,0 +1,202 @@
+package dealer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrInUse is returned when deleting a master record something else still refers to
+var ErrInUse = errors.New("still in use")
+
+// Dealer is the master record for a dealer--the one place its name lives.
+// The ID is the dealer's ID as feeds know it, not one we made up
+type Dealer struct {
+	ID           int       `gorm:"column:d_id;primary_key;auto_increment:false"`
+	Name         string    `gorm:"column:d_name;type:varchar(32)"`
+	Created      time.Time `gorm:"column:created_time"`
+	LastModified time.Time `gorm:"column:last_modified_time"`
+}
+
+// TableName overrides the default table name "dealers" for the gorm library--which happens to be
+// what we want, but it's nice to see it spelled out next to "inventory" and "lots"
+func (Dealer) TableName() string {
+	return "dealers"
+}
+
+// DealerLot is the master record for one of a dealer's lots, and what inventory rows refer to
+type DealerLot struct {
+	ID       int     `gorm:"column:lot_id;primary_key"`
+	DealerID int     `gorm:"column:d_id;unique_index:idx_lots_dealer_type"`
+	LotType  LotType `gorm:"column:stock_type;type:varchar(16);unique_index:idx_lots_dealer_type"`
+}
+
+// TableName overrides the default table name "dealer_lots" for the gorm library
+func (DealerLot) TableName() string {
+	return "lots"
+}
+
+// Lot puts a DealerLot and its Dealer back together into the Lot a feed would describe
+func (lot DealerLot) Lot(dealer Dealer) Lot {
+	return Lot{DealerID: dealer.ID, DealerName: dealer.Name, LotType: lot.LotType}
+}
+
+// CreateDealer adds a dealer to the master table
+func CreateDealer(db *gorm.DB, dealer *Dealer) error {
+	now := time.Now()
+	dealer.Created = now
+	dealer.LastModified = now
+	if err := db.Create(dealer).Error; err != nil {
+		return fmt.Errorf("Creating dealer %d: %w", dealer.ID, err)
+	}
+	return nil
+}
+
+// FindDealer looks up a dealer by ID--gorm.ErrRecordNotFound is in the chain when there's no such dealer
+func FindDealer(db *gorm.DB, id int) (Dealer, error) {
+	var dealer Dealer
+	if err := db.Where("d_id = ?", id).First(&dealer).Error; err != nil {
+		return dealer, fmt.Errorf("Finding dealer %d: %w", id, err)
+	}
+	return dealer, nil
+}
+
+// ListDealers returns every dealer, by ID
+func ListDealers(db *gorm.DB) ([]Dealer, error) {
+	var dealers []Dealer
+	if err := db.Order("d_id").Find(&dealers).Error; err != nil {
+		return nil, fmt.Errorf("Listing dealers: %w", err)
+	}
+	return dealers, nil
+}
+
+// RenameDealer changes a dealer's name--the only thing about a dealer there is to change
+func RenameDealer(db *gorm.DB, id int, name string) error {
+	result := db.Model(&Dealer{}).Where("d_id = ?", id).
+		Updates(map[string]interface{}{"d_name": name, "last_modified_time": time.Now()})
+	if result.Error != nil {
+		return fmt.Errorf("Renaming dealer %d: %w", id, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("Renaming dealer %d: %w", id, gorm.ErrRecordNotFound)
+	}
+	return nil
+}
+
+// DeleteDealer removes a dealer, so long as it has no lots left
+func DeleteDealer(db *gorm.DB, id int) error {
+	var count int
+	if err := db.Model(&DealerLot{}).Where("d_id = ?", id).Count(&count).Error; err != nil {
+		return fmt.Errorf("Counting lots of dealer %d: %w", id, err)
+	}
+	if count > 0 {
+		return fmt.Errorf("Deleting dealer %d with %d lots: %w", id, count, ErrInUse)
+	}
+	if err := db.Where("d_id = ?", id).Delete(&Dealer{}).Error; err != nil {
+		return fmt.Errorf("Deleting dealer %d: %w", id, err)
+	}
+	return nil
+}
+
+// CreateLot adds a lot to the master table--its dealer has to exist already
+func CreateLot(db *gorm.DB, lot *DealerLot) error {
+	if _, err := FindDealer(db, lot.DealerID); err != nil {
+		return fmt.Errorf("Creating %s lot: %w", lot.LotType, err)
+	}
+	if err := db.Create(lot).Error; err != nil {
+		return fmt.Errorf("Creating %s lot for dealer %d: %w", lot.LotType, lot.DealerID, err)
+	}
+	return nil
+}
+
+// FindLot looks up a lot by its ID
+func FindLot(db *gorm.DB, id int) (DealerLot, error) {
+	var lot DealerLot
+	if err := db.Where("lot_id = ?", id).First(&lot).Error; err != nil {
+		return lot, fmt.Errorf("Finding lot %d: %w", id, err)
+	}
+	return lot, nil
+}
+
+// FindDealerLot looks up a lot by the dealer and lot type a feed would know it by
+func FindDealerLot(db *gorm.DB, dealerID int, lotType LotType) (DealerLot, error) {
+	var lot DealerLot
+	if err := db.Where("d_id = ? AND stock_type = ?", dealerID, lotType).First(&lot).Error; err != nil {
+		return lot, fmt.Errorf("Finding %s lot for dealer %d: %w", lotType, dealerID, err)
+	}
+	return lot, nil
+}
+
+// ListLots returns every lot belonging to a dealer--or every lot, given a dealerID of 0
+func ListLots(db *gorm.DB, dealerID int) ([]DealerLot, error) {
+	query := db.Order("d_id, stock_type")
+	if dealerID != 0 {
+		query = query.Where("d_id = ?", dealerID)
+	}
+	var lots []DealerLot
+	if err := query.Find(&lots).Error; err != nil {
+		return nil, fmt.Errorf("Listing lots: %w", err)
+	}
+	return lots, nil
+}
+
+// DeleteLot removes a lot, so long as there's no inventory left on it
+func DeleteLot(db *gorm.DB, id int) error {
+	var count int
+	if err := db.Model(&Vehicle{}).Where("lot_id = ?", id).Count(&count).Error; err != nil {
+		return fmt.Errorf("Counting vehicles on lot %d: %w", id, err)
+	}
+	if count > 0 {
+		return fmt.Errorf("Deleting lot %d with %d vehicles: %w", id, count, ErrInUse)
+	}
+	if err := db.Where("lot_id = ?", id).Delete(&DealerLot{}).Error; err != nil {
+		return fmt.Errorf("Deleting lot %d: %w", id, err)
+	}
+	return nil
+}
+
+// ResolveLot finds the master records for a Lot a feed described, creating whichever of them
+// don't exist yet--a feed is how we find out about new dealers and lots.
+// The Dealer returned is the master record, which is the one to believe if its name doesn't match the feed's
+func ResolveLot(db *gorm.DB, lot Lot) (DealerLot, Dealer, error) {
+	dealer, err := FindDealer(db, lot.DealerID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		dealer = Dealer{ID: lot.DealerID, Name: lot.DealerName}
+		err = CreateDealer(db, &dealer)
+	}
+	if err != nil {
+		return DealerLot{}, dealer, err
+	}
+
+	dealerLot, err := FindDealerLot(db, lot.DealerID, lot.LotType)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		dealerLot = DealerLot{DealerID: lot.DealerID, LotType: lot.LotType}
+		err = CreateLot(db, &dealerLot)
+	}
+	return dealerLot, dealer, err
+}
+
+// Lots returns every lot as a feed would describe it, by lot ID--handy for filling in the Lot
+// on vehicles loaded from inventory, which only know their lot ID
+func Lots(db *gorm.DB) (map[int]Lot, error) {
+	dealers, err := ListDealers(db)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[int]Dealer{}
+	for _, dealer := range dealers {
+		byID[dealer.ID] = dealer
+	}
+
+	dealerLots, err := ListLots(db, 0)
+	if err != nil {
+		return nil, err
+	}
+	lots := map[int]Lot{}
+	for _, lot := range dealerLots {
+		lots[lot.ID] = lot.Lot(byID[lot.DealerID])
+	}
+	return lots, nil
+}
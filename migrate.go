@@ -0,0 +1,174 @@
+package dealer
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Migrate brings a database up to date with the models in this package.  It's safe to run every time
+// a program starts--anything already done is left alone
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&Dealer{}, &DealerLot{}).Error; err != nil {
+		return fmt.Errorf("Migrating dealers and lots: %w", err)
+	}
+
+	columns, err := tableColumns(db, Vehicle{}.TableName())
+	if err != nil {
+		return err
+	}
+	if _, ok := columns.byName["d_id"]; ok {
+		if err = normalizeInventory(db); err != nil {
+			return fmt.Errorf("Normalizing inventory: %w", err)
+		}
+	}
+
+	if err = db.AutoMigrate(&Vehicle{}, &Transfer{}, &LotQuality{}).Error; err != nil {
+		return fmt.Errorf("Migrating inventory: %w", err)
+	}
+	return nil
+}
+
+// normalizeInventory moves the dealer and lot columns every inventory row used to carry into the dealers
+// and lots tables, and points each row at its lot instead.  SQLite can't drop a column, so the inventory
+// table is rebuilt without them--in a transaction, so it either all happens or none of it does.
+// A row without a dealer or a lot type has no lot to point at, and rather than quietly leave it behind
+// the whole thing is called off until somebody decides what it should be
+func normalizeInventory(db *gorm.DB) error {
+	columns, err := tableColumns(db, "inventory")
+	if err != nil {
+		return err
+	}
+
+	var orphans int
+	if err = db.Raw(`SELECT COUNT(*) FROM inventory WHERE d_id IS NULL OR stock_type IS NULL`).Row().Scan(&orphans); err != nil {
+		return fmt.Errorf("Counting inventory without a lot: %w", err)
+	}
+	if orphans > 0 {
+		return fmt.Errorf("Moving inventory onto lots: %d rows have no d_id or stock_type", orphans)
+	}
+
+	// Keep every column the table has, in its current order and with whatever constraints it has, but the ones
+	// that are moving out.  Every row has a lot by the time this is done, so lot_id can say it always will
+	definitions := []string{`"v_id" integer primary key autoincrement`, `"lot_id" integer NOT NULL`}
+	kept := []string{}
+	for _, column := range columns.ordered {
+		switch column.name {
+		case "v_id", "d_id", "d_name", "stock_type":
+			continue
+		}
+		definitions = append(definitions, column.definition())
+		kept = append(kept, `"`+column.name+`"`)
+	}
+	keptList := strings.Join(kept, ", ")
+
+	statements := []string{
+		// If a dealer's name varies between rows, the most recently modified row gets the final say.  SQLite
+		// picks a bare column's value from any row it likes when there's more than one aggregate, so it's asked for
+		`INSERT OR IGNORE INTO dealers (d_id, d_name, created_time, last_modified_time)
+			SELECT d_id, (
+				SELECT latest.d_name FROM inventory latest WHERE latest.d_id = inventory.d_id
+				ORDER BY latest.last_modified_time DESC, latest.v_id DESC LIMIT 1
+			), MIN(created_time), MAX(last_modified_time) FROM inventory GROUP BY d_id`,
+		`INSERT OR IGNORE INTO lots (d_id, stock_type) SELECT DISTINCT d_id, stock_type FROM inventory`,
+		`CREATE TABLE "inventory_normalized"(` + strings.Join(definitions, ", ") + `)`,
+		`INSERT INTO inventory_normalized (v_id, lot_id, ` + keptList + `)
+			SELECT inventory.v_id, lots.lot_id, ` + prefixed("inventory", kept) + ` FROM inventory
+			JOIN lots ON lots.d_id = inventory.d_id AND lots.stock_type = inventory.stock_type`,
+		// Carry the autoincrement sequence over, so deleted v_ids don't get handed out again
+		`DELETE FROM sqlite_sequence WHERE name = 'inventory_normalized'`,
+		`INSERT INTO sqlite_sequence (name, seq) SELECT 'inventory_normalized', seq FROM sqlite_sequence WHERE name = 'inventory'`,
+		`DROP TABLE inventory`,
+		`ALTER TABLE inventory_normalized RENAME TO inventory`,
+		`CREATE INDEX idx_inventory_lot_id ON inventory(lot_id)`,
+	}
+
+	tx := db.Begin()
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		// Every row should have made it across; if one didn't, the inventory table is still there to say so
+		if strings.HasPrefix(statement, "INSERT INTO inventory_normalized") {
+			if err := sameRowCount(tx, "inventory", "inventory_normalized"); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	return tx.Commit().Error
+}
+
+// sameRowCount fails unless to has as many rows as from
+func sameRowCount(db *gorm.DB, from, to string) error {
+	var before, after int
+	if err := db.Raw(fmt.Sprintf(`SELECT (SELECT COUNT(*) FROM %q), (SELECT COUNT(*) FROM %q)`, from, to)).Row().Scan(&before, &after); err != nil {
+		return fmt.Errorf("Counting rows of %s and %s: %w", from, to, err)
+	}
+	if before != after {
+		return fmt.Errorf("Copying %s to %s: %d of %d rows didn't make it", from, to, before-after, before)
+	}
+	return nil
+}
+
+// tableColumn is a column as SQLite describes it
+type tableColumn struct {
+	name         string
+	kind         string
+	notNull      bool
+	defaultValue sql.NullString
+}
+
+// definition is the column as CREATE TABLE would have it, constraints and all
+func (column tableColumn) definition() string {
+	definition := fmt.Sprintf(`"%s" %s`, column.name, column.kind)
+	if column.notNull {
+		definition += " NOT NULL"
+	}
+	// PRAGMA table_info gives the default as it was written, quotes and all, so it goes back the same way
+	if column.defaultValue.Valid {
+		definition += " DEFAULT " + column.defaultValue.String
+	}
+	return definition
+}
+
+// tableColumnSet is every column in a table, by name and in order
+type tableColumnSet struct {
+	ordered []tableColumn
+	byName  map[string]tableColumn
+}
+
+// tableColumns asks SQLite what columns a table has.  gorm's HasColumn does a LIKE over the CREATE
+// statement, which is close enough for adding columns but not for deciding which ones to throw away
+func tableColumns(db *gorm.DB, table string) (tableColumnSet, error) {
+	set := tableColumnSet{byName: map[string]tableColumn{}}
+	rows, err := db.Raw(fmt.Sprintf("PRAGMA table_info(%q)", table)).Rows()
+	if err != nil {
+		return set, fmt.Errorf("Describing table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var column tableColumn
+		if err := rows.Scan(&cid, &column.name, &column.kind, &notNull, &column.defaultValue, &pk); err != nil {
+			return set, fmt.Errorf("Describing table %s: %w", table, err)
+		}
+		column.notNull = notNull != 0
+		set.ordered = append(set.ordered, column)
+		set.byName[column.name] = column
+	}
+	return set, rows.Err()
+}
+
+// prefixed qualifies each of columns with table, for a SELECT that joins
+func prefixed(table string, columns []string) string {
+	qualified := make([]string, len(columns))
+	for i, column := range columns {
+		qualified[i] = table + "." + column
+	}
+	return strings.Join(qualified, ", ")
+}
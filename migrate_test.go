@@ -0,0 +1,152 @@
+package dealer
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	// The sample database is SQLite, so the migration only has to work there
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// baselineInventory is the inventory table as the sample database first had it, every row carrying its dealer
+// and lot--with a NOT NULL and a DEFAULT on price that weren't there, to see they survive
+const baselineInventory = `CREATE TABLE "inventory"(d_id int, d_name varchar(32), v_id integer primary key autoincrement, stock_type varchar(4), stock_id varchar(4), vin varchar(16), year int, make varchar(16), model varchar(16), body_style varchar(16), trim varchar(16), doors int, exterior_colour varchar(255), interior_colour varchar(255), exterior_colour_generic varchar(16), interior_colour_generic varchar(16), configuration varchar(1), cylinders int, displacement double, fuel_type varchar(8), transmission_type varchar(16), transmission_speeds int, transmission_description varchar(32), drivetrain varchar(4), odometer int, price double NOT NULL DEFAULT '0', msrp double, description varchar(255), passengers int, created_time datetime, last_modified_by varchar(16), last_modified_time datetime)`
+
+// migrateDatabases keeps each test's in-memory database apart from the others
+var migrateDatabases int64
+
+// baselineDB is an in-memory database with the baseline inventory table holding rows, each of which is
+// d_id, d_name, stock_type, stock_id, vin and last_modified_time
+func baselineDB(t *testing.T, rows ...string) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open("sqlite3", fmt.Sprintf("file:migrate%d?mode=memory&cache=shared", atomic.AddInt64(&migrateDatabases, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB().SetMaxIdleConns(1)
+	db.LogMode(false)
+	if err = db.Exec(baselineInventory).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		statement := `INSERT INTO inventory (d_id, d_name, stock_type, stock_id, vin, last_modified_time, price) VALUES (` + row + `, 1)`
+		if err = db.Exec(statement).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// inventoryLots is each vehicle's dealer, name and lot type after the migration, by stock number
+func inventoryLots(t *testing.T, db *gorm.DB) map[string]string {
+	t.Helper()
+	lots, err := Lots(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vehicles []Vehicle
+	if err = db.Find(&vehicles).Error; err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, vehicle := range vehicles {
+		lot := lots[vehicle.LotID]
+		got[vehicle.Stock] = fmt.Sprintf("%d %s %s", lot.DealerID, lot.DealerName, lot.LotType)
+	}
+	return got
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	db := baselineDB(t,
+		`1001, 'Strathcom', 'NEW', 'A124', '1GCEP22T1G3329139', '2019-12-01'`,
+		`1001, 'Strathcom Motors', 'USED', 'B105', 'KM8SB12B02U162029', '2019-12-05'`,
+		`1001, 'Strathcom', 'NEW', 'A120', 'KH4DC4365XG056153', '2019-12-03'`,
+		`1022, 'Fine Cars Inc', 'NEW', 'Z205', '3VW4S7AT3EM654568', '2019-12-01'`,
+		`1022, 'Fine Cars Inc', 'USED', 'Z999', 'DELETEDLATER00000', '2019-12-01'`,
+	)
+	defer db.Close()
+	// The last vehicle was sold; its v_id shouldn't turn up again
+	if err := db.Exec(`DELETE FROM inventory WHERE stock_id = 'Z999'`).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each dealer's name is the one on its most recently modified row
+	want := map[string]string{
+		"A124": "1001 Strathcom Motors NEW",
+		"B105": "1001 Strathcom Motors USED",
+		"A120": "1001 Strathcom Motors NEW",
+		"Z205": "1022 Fine Cars Inc NEW",
+	}
+	if got := inventoryLots(t, db); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Vehicles are on %v, want %v", got, want)
+	}
+	var lots int
+	if err := db.Table("lots").Count(&lots).Error; err != nil || lots != 3 {
+		t.Errorf("%d lots, %v, want one per dealer and type in inventory", lots, err)
+	}
+
+	columns, err := tableColumns(db, "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, gone := range []string{"d_id", "d_name", "stock_type"} {
+		if _, ok := columns.byName[gone]; ok {
+			t.Errorf("inventory still has %s", gone)
+		}
+	}
+	if lotID := columns.byName["lot_id"]; !lotID.notNull {
+		t.Errorf("lot_id is %+v, want NOT NULL", lotID)
+	}
+	if price := columns.byName["price"]; !price.notNull || price.defaultValue.String != "'0'" {
+		t.Errorf("price is %+v, want its constraints kept", price)
+	}
+
+	// The sequence came across, so the next vehicle is 6 and not the sold one's 5
+	added := Vehicle{LotID: 1, FeedVehicle: FeedVehicle{VehicleKey: VehicleKey{VIN: "2FMDK3KC0ABA00001", Stock: "N900"}}}
+	if err = db.Create(&added).Error; err != nil {
+		t.Fatal(err)
+	}
+	if added.ID != 6 {
+		t.Errorf("Next vehicle is %d, want 6", added.ID)
+	}
+
+	// Anything already done is left alone
+	if err = Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if got := inventoryLots(t, db); len(got) != 5 {
+		t.Errorf("Migrating again left %v", got)
+	}
+}
+
+func TestMigrateOrphans(t *testing.T) {
+	db := baselineDB(t,
+		`1001, 'Strathcom Motors', 'NEW', 'A124', '1GCEP22T1G3329139', '2019-12-01'`,
+		`NULL, NULL, 'USED', 'B105', 'KM8SB12B02U162029', '2019-12-05'`,
+	)
+	defer db.Close()
+
+	err := Migrate(db)
+	if err == nil || !strings.Contains(err.Error(), "1 rows have no d_id or stock_type") {
+		t.Fatalf("Migrating gave %v, want the orphan reported", err)
+	}
+	// Nothing moved, so nothing's lost
+	columns, err := tableColumns(db, "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := columns.byName["d_id"]; !ok {
+		t.Error("inventory lost its d_id column anyway")
+	}
+	var rows int
+	if err = db.Table("inventory").Count(&rows).Error; err != nil || rows != 2 {
+		t.Errorf("%d rows, %v, want both still there", rows, err)
+	}
+}
@@ -0,0 +1,301 @@
+package dealer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Every feed describes what makes a vehicle go in its own words.  These turn the usual ones into the same few
+// values every time, along with a confidence from 0 to 1 in how well they understood it--0 meaning
+// not at all, in which case the value is empty and it's up to the caller what to do with the raw description
+
+// Transmission types
+const (
+	TransmissionAutomatic       = "Automatic"
+	TransmissionManual          = "Manual"
+	TransmissionCVT             = "CVT"
+	TransmissionECVT            = "e-CVT"
+	TransmissionDCT             = "DCT"
+	TransmissionAutomatedManual = "Automated Manual"
+	TransmissionSingleSpeed     = "Single-Speed"
+)
+
+// Transmission is what ParseTransmission made of a description
+type Transmission struct {
+	Type string
+	// Speeds is 0 when the description doesn't say
+	Speeds int
+	// ManualMode is an automatic that'll let the driver pick gears--Tiptronic, paddles, "Manual Shift" and so on
+	ManualMode bool
+	Confidence float64
+}
+
+// transmissionPatterns are tried in order, so the more specific kinds of automatic come before automatic
+var transmissionPatterns = []struct {
+	kind       string
+	pattern    *regexp.Regexp
+	confidence float64
+}{
+	{TransmissionECVT, regexp.MustCompile(`\be-?cvt\b|electronic(ally)?[ -]controlled continuously variable|power split`), 1},
+	{TransmissionCVT, regexp.MustCompile(`\bcvt\b|continuously variable|xtronic|lineartronic|\bivt\b`), 1},
+	{TransmissionCVT, regexp.MustCompile(`^variable$`), 0.7},
+	{TransmissionDCT, regexp.MustCompile(`\bdct\b|dual[ -]clutch|double[ -]clutch|\bdsg\b|\bpdk\b|s[ -]tronic|powershift|\bddct\b`), 1},
+	{TransmissionSingleSpeed, regexp.MustCompile(`single[ -]speed|\b1[ -]speed|direct drive|single[ -]gear|reduction gear`), 1},
+	{TransmissionAutomatedManual, regexp.MustCompile(`automated manual|\bamt\b|\bsmg\b|e-gear|selespeed`), 1},
+	{TransmissionAutomatic, regexp.MustCompile(`\bautomatic\b|\bauto\b`), 1},
+	{TransmissionAutomatic, regexp.MustCompile(`\ba/t\b|\bat\b|\b\d{1,2} ?at?\b|tiptronic|steptronic|geartronic|sportmatic|\bshiftronic`), 0.8},
+	{TransmissionManual, regexp.MustCompile(`\bmanual\b|\bstick\b`), 1},
+	{TransmissionManual, regexp.MustCompile(`\bm/t\b|\bmt\b|\b\d{1,2} ?mt?\b`), 0.8},
+}
+
+// transmissionSpeeds finds how many speeds: 6-Speed, 10 spd, 8AT, 6M and the like
+var transmissionSpeeds = regexp.MustCompile(`\b(\d{1,2})[ -]?(?:speed|spd|sp|at|a|mt|m)\b`)
+
+// manualMode finds an automatic that lets the driver choose
+var manualMode = regexp.MustCompile(`manual (mode|shift)|manumatic|tiptronic|steptronic|geartronic|sportmatic|shiftronic|paddle|select ?shift|sport ?shift|auto[ -]?stick|with manual`)
+
+// ParseTransmission understands the usual ways of describing a transmission: "6-Speed Automatic", "10-speed
+// automatic with manual mode", "7-Speed DCT", "e-CVT", "6MT" and so on
+func ParseTransmission(description string) Transmission {
+	text := strings.ToLower(strings.TrimSpace(description))
+	if text == "" {
+		return Transmission{}
+	}
+
+	transmission := Transmission{}
+	if match := transmissionSpeeds.FindStringSubmatch(text); match != nil {
+		transmission.Speeds, _ = strconv.Atoi(match[1])
+	}
+	for _, candidate := range transmissionPatterns {
+		if candidate.pattern.MatchString(text) {
+			transmission.Type = candidate.kind
+			transmission.Confidence = candidate.confidence
+			break
+		}
+	}
+
+	switch {
+	case transmission.Type == "" && transmission.Speeds > 0:
+		// A number of speeds and nothing else is something, just not much
+		transmission.Confidence = 0.3
+	case transmission.Type == TransmissionAutomatic || transmission.Type == TransmissionDCT || transmission.Type == TransmissionCVT:
+		transmission.ManualMode = manualMode.MatchString(text)
+	}
+	// Single speed is one speed, whether it says so or not
+	if transmission.Type == TransmissionSingleSpeed {
+		transmission.Speeds = 1
+	}
+	return transmission
+}
+
+// Engine configurations--a letter, the way EngType has always had them
+const (
+	EngineInline = "I"
+	EngineV      = "V"
+	EngineFlat   = "H"
+	EngineW      = "W"
+	EngineRotary = "R"
+)
+
+// Engine inductions
+const (
+	InductionTurbo        = "Turbo"
+	InductionTwinTurbo    = "Twin Turbo"
+	InductionSupercharged = "Supercharged"
+)
+
+// Engine is what ParseEngine made of a description
+type Engine struct {
+	Configuration string
+	Cylinders     int
+	// Displacement is in litres
+	Displacement float64
+	// Induction is empty for a naturally aspirated engine, or one the description didn't say about
+	Induction  string
+	Confidence float64
+}
+
+var (
+	engineLayout       = regexp.MustCompile(`(?:^|[^a-z0-9.])(v|w|h|i|l)[ -]?(\d{1,2})\b`)
+	engineInline       = regexp.MustCompile(`\b(inline|in-line|straight)\b[ -]?(\d{1,2})?`)
+	engineFlat         = regexp.MustCompile(`\b(flat|boxer|horizontally opposed)\b[ -]?(\d{1,2})?`)
+	engineRotary       = regexp.MustCompile(`\b(rotary|wankel)\b`)
+	engineCylinders    = regexp.MustCompile(`\b(\d{1,2})[ -]?(?:cyl|cylinders?)\b`)
+	engineLitres       = regexp.MustCompile(`(\d{1,2}(?:\.\d)?)\s*(?:l|liter|litre)\b`)
+	engineCC           = regexp.MustCompile(`\b(\d{3,4})\s*cc\b`)
+	engineBareLitres   = regexp.MustCompile(`\b(\d\.\d)\b`)
+	engineTwinTurbo    = regexp.MustCompile(`twin[ -]?turbo|bi-?turbo|biturbo|dual turbo`)
+	engineTurbo        = regexp.MustCompile(`turbo|\btsi\b|\btfsi\b|ecoboost`)
+	engineSupercharged = regexp.MustCompile(`supercharge|\bsc\b`)
+)
+
+// ParseEngine understands the usual ways of describing an engine: "2.0L I4 Turbo", "V6 3.5", "3.5L V-6",
+// "1.5L 4-Cylinder", "Flat-6", "W12", and a bare configuration letter like the EngType column has
+func ParseEngine(description string) Engine {
+	text := strings.ToLower(strings.TrimSpace(description))
+	engine := Engine{}
+	if text == "" {
+		return engine
+	}
+
+	// A configuration letter on its own is what EngType has, and all anything exports back out
+	switch text {
+	case "i", "l", "inline", "straight":
+		return Engine{Configuration: EngineInline, Confidence: 1}
+	case "v":
+		return Engine{Configuration: EngineV, Confidence: 1}
+	case "h", "flat", "boxer":
+		return Engine{Configuration: EngineFlat, Confidence: 1}
+	case "w":
+		return Engine{Configuration: EngineW, Confidence: 1}
+	case "r", "rotary":
+		return Engine{Configuration: EngineRotary, Confidence: 1}
+	}
+
+	if match := engineLayout.FindStringSubmatch(text); match != nil {
+		engine.Configuration = strings.ToUpper(match[1])
+		if engine.Configuration == "L" {
+			engine.Configuration = EngineInline
+		}
+		engine.Cylinders, _ = strconv.Atoi(match[2])
+	} else if match := engineInline.FindStringSubmatch(text); match != nil {
+		engine.Configuration = EngineInline
+		engine.Cylinders, _ = strconv.Atoi(match[2])
+	} else if match := engineFlat.FindStringSubmatch(text); match != nil {
+		engine.Configuration = EngineFlat
+		engine.Cylinders, _ = strconv.Atoi(match[2])
+	} else if engineRotary.MatchString(text) {
+		engine.Configuration = EngineRotary
+	}
+	if engine.Cylinders == 0 {
+		if match := engineCylinders.FindStringSubmatch(text); match != nil {
+			engine.Cylinders, _ = strconv.Atoi(match[1])
+		}
+	}
+
+	if match := engineLitres.FindStringSubmatch(text); match != nil {
+		engine.Displacement, _ = strconv.ParseFloat(match[1], 64)
+	} else if match := engineCC.FindStringSubmatch(text); match != nil {
+		cc, _ := strconv.ParseFloat(match[1], 64)
+		engine.Displacement = float64(int(cc/100+0.5)) / 10
+	} else if match := engineBareLitres.FindStringSubmatch(text); match != nil {
+		engine.Displacement, _ = strconv.ParseFloat(match[1], 64)
+	}
+
+	switch {
+	case engineTwinTurbo.MatchString(text):
+		engine.Induction = InductionTwinTurbo
+	case engineTurbo.MatchString(text):
+		engine.Induction = InductionTurbo
+	case engineSupercharged.MatchString(text):
+		engine.Induction = InductionSupercharged
+	}
+
+	// However much of configuration, cylinders and displacement it found
+	found := 0
+	if engine.Configuration != "" {
+		found++
+	}
+	if engine.Cylinders > 0 {
+		found++
+	}
+	if engine.Displacement > 0 {
+		found++
+	}
+	engine.Confidence = []float64{0, 0.5, 0.8, 1}[found]
+	if found == 0 && engine.Induction != "" {
+		engine.Confidence = 0.2
+	}
+	return engine
+}
+
+// Fuel types
+const (
+	FuelGasoline = "Gasoline"
+	FuelDiesel   = "Diesel"
+	FuelHybrid   = "Hybrid"
+	FuelPHEV     = "PHEV"
+	FuelElectric = "Electric"
+	FuelFlex     = "FlexFuel"
+	FuelHydrogen = "Hydrogen"
+)
+
+// fuelPatterns are tried in order, so a plug-in hybrid isn't just a hybrid, and a gas/electric hybrid isn't electric
+var fuelPatterns = []struct {
+	fuel       string
+	pattern    *regexp.Regexp
+	confidence float64
+}{
+	{FuelPHEV, regexp.MustCompile(`plug[ -]?in|\bphev\b`), 1},
+	{FuelHydrogen, regexp.MustCompile(`hydrogen|fuel cell|\bfcev\b`), 1},
+	{FuelHybrid, regexp.MustCompile(`hybrid|\bhev\b|\bmhev\b`), 1},
+	{FuelElectric, regexp.MustCompile(`^(electric|electricity|ev|bev|battery electric)$`), 1},
+	{FuelElectric, regexp.MustCompile(`electric|\bbev\b|\bev\b`), 0.8},
+	{FuelFlex, regexp.MustCompile(`flex|\be85\b|\bffv\b`), 1},
+	{FuelDiesel, regexp.MustCompile(`diesel|\btdi\b|bluetec|\bcrdi?\b`), 1},
+	{FuelGasoline, regexp.MustCompile(`^(gasoline|gas|petrol)$`), 1},
+	{FuelGasoline, regexp.MustCompile(`gasoline|\bgas\b|petrol|unleaded|premium|regular`), 0.9},
+}
+
+// ParseFuel understands the usual ways of saying what a vehicle runs on: gasoline, diesel, hybrid, plug-in hybrid,
+// electric, flex fuel and hydrogen
+func ParseFuel(value string) (string, float64) {
+	text := strings.ToLower(strings.TrimSpace(value))
+	if text == "" {
+		return "", 0
+	}
+	for _, candidate := range fuelPatterns {
+		if candidate.pattern.MatchString(text) {
+			return candidate.fuel, candidate.confidence
+		}
+	}
+	return "", 0
+}
+
+// Drivetrains
+const (
+	DriveFront = "FWD"
+	DriveRear  = "RWD"
+	DriveAll   = "AWD"
+	DriveFour  = "4WD"
+)
+
+// drivetrains are the usual names for each drivetrain, with everything but letters and digits taken out
+var drivetrains = map[string]struct {
+	drive      string
+	confidence float64
+}{
+	"fwd": {DriveFront, 1}, "frontwheeldrive": {DriveFront, 1}, "front": {DriveFront, 0.8}, "ff": {DriveFront, 0.7},
+	"rwd": {DriveRear, 1}, "rearwheeldrive": {DriveRear, 1}, "rear": {DriveRear, 0.8}, "fr": {DriveRear, 0.7},
+	"awd": {DriveAll, 1}, "allwheeldrive": {DriveAll, 1}, "shawd": {DriveAll, 1}, "symmetricalawd": {DriveAll, 1},
+	"4wd": {DriveFour, 1}, "4x4": {DriveFour, 1}, "fourwheeldrive": {DriveFour, 1}, "4wheeldrive": {DriveFour, 1},
+	"parttime4wd": {DriveFour, 1}, "fulltime4wd": {DriveFour, 1},
+	// Trucks say 4x2 when they mean the back wheels, usually.  2WD is just as likely a front wheel drive car,
+	// so it's left as the feed has it
+	"4x2": {DriveRear, 0.6},
+}
+
+// driveBrands are what manufacturers call all wheel drive
+var driveBrands = regexp.MustCompile(`quattro|xdrive|4matic|4motion|all4|\bq4\b|sh-awd|awd|all[ -]wheel`)
+
+// ParseDrivetrain understands the usual names for a drivetrain, manufacturer's brand names for all wheel drive included
+func ParseDrivetrain(value string) (string, float64) {
+	text := strings.ToLower(strings.TrimSpace(value))
+	if text == "" {
+		return "", 0
+	}
+	var compact strings.Builder
+	for _, r := range text {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			compact.WriteRune(r)
+		}
+	}
+	if known, ok := drivetrains[compact.String()]; ok {
+		return known.drive, known.confidence
+	}
+	if driveBrands.MatchString(text) {
+		return DriveAll, 0.8
+	}
+	return "", 0
+}
@@ -0,0 +1,106 @@
+package dealer
+
+import "testing"
+
+func TestParseTransmission(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		want        Transmission
+	}{
+		{"6-Speed Automatic", Transmission{Type: TransmissionAutomatic, Speeds: 6, Confidence: 1}},
+		{"10-speed automatic with manual mode", Transmission{Type: TransmissionAutomatic, Speeds: 10, ManualMode: true, Confidence: 1}},
+		{"7-Speed DCT", Transmission{Type: TransmissionDCT, Speeds: 7, Confidence: 1}},
+		{"e-CVT", Transmission{Type: TransmissionECVT, Confidence: 1}},
+		{"Xtronic CVT", Transmission{Type: TransmissionCVT, Confidence: 1}},
+		{"6MT", Transmission{Type: TransmissionManual, Speeds: 6, Confidence: 0.8}},
+		{"Single Speed", Transmission{Type: TransmissionSingleSpeed, Speeds: 1, Confidence: 1}},
+		// The demo feed's idea of a transmission
+		{"Variable", Transmission{Type: TransmissionCVT, Confidence: 0.7}},
+		{"", Transmission{}},
+		{"Yes", Transmission{}},
+	} {
+		if got := ParseTransmission(test.description); got != test.want {
+			t.Errorf("ParseTransmission(%q) = %+v, want %+v", test.description, got, test.want)
+		}
+	}
+}
+
+func TestParseEngine(t *testing.T) {
+	for _, test := range []struct {
+		description   string
+		configuration string
+		cylinders     int
+		displacement  float64
+		induction     string
+	}{
+		{"2.0L I4 Turbo", EngineInline, 4, 2.0, InductionTurbo},
+		{"3.5L V-6", EngineV, 6, 3.5, ""},
+		{"V6 3.5", EngineV, 6, 3.5, ""},
+		{"1.5L 4-Cylinder", "", 4, 1.5, ""},
+		{"Flat-6", EngineFlat, 6, 0, ""},
+		{"W12", EngineW, 12, 0, ""},
+		{"4.0L V8 Biturbo", EngineV, 8, 4.0, InductionTwinTurbo},
+		{"V", EngineV, 0, 0, ""},
+	} {
+		got := ParseEngine(test.description)
+		if got.Configuration != test.configuration || got.Cylinders != test.cylinders ||
+			got.Displacement != test.displacement || got.Induction != test.induction {
+			t.Errorf("ParseEngine(%q) = %+v, want %s %d cylinders %gL %q", test.description, got,
+				test.configuration, test.cylinders, test.displacement, test.induction)
+		}
+		if got.Confidence == 0 {
+			t.Errorf("ParseEngine(%q) has no confidence", test.description)
+		}
+	}
+	if got := ParseEngine(""); got.Confidence != 0 {
+		t.Errorf("ParseEngine(\"\") = %+v, want nothing", got)
+	}
+}
+
+func TestParseFuel(t *testing.T) {
+	for _, test := range []struct {
+		value      string
+		fuel       string
+		confidence float64
+	}{
+		{"Gasoline", FuelGasoline, 1},
+		{"Premium Unleaded", FuelGasoline, 0.9},
+		{"Diesel", FuelDiesel, 1},
+		{"Gas/Electric Hybrid", FuelHybrid, 1},
+		{"Plug-In Hybrid", FuelPHEV, 1},
+		{"Electric", FuelElectric, 1},
+		{"Electric Fuel System", FuelElectric, 0.8},
+		{"Flex Fuel", FuelFlex, 1},
+		{"Hydrogen Fuel Cell", FuelHydrogen, 1},
+		{"", "", 0},
+		{"Steam", "", 0},
+	} {
+		fuel, confidence := ParseFuel(test.value)
+		if fuel != test.fuel || confidence != test.confidence {
+			t.Errorf("ParseFuel(%q) = %q, %g, want %q, %g", test.value, fuel, confidence, test.fuel, test.confidence)
+		}
+	}
+}
+
+func TestParseDrivetrain(t *testing.T) {
+	for _, test := range []struct {
+		value      string
+		drive      string
+		confidence float64
+	}{
+		{"AWD", DriveAll, 1},
+		{"All-Wheel Drive", DriveAll, 1},
+		{"Front Wheel Drive", DriveFront, 1},
+		{"4x4", DriveFour, 1},
+		{"quattro", DriveAll, 0.8},
+		{"4x2", DriveRear, 0.6},
+		{"2WD", "", 0},
+		{"", "", 0},
+		{"Sideways", "", 0},
+	} {
+		drive, confidence := ParseDrivetrain(test.value)
+		if drive != test.drive || confidence != test.confidence {
+			t.Errorf("ParseDrivetrain(%q) = %q, %g, want %q, %g", test.value, drive, confidence, test.drive, test.confidence)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package dealer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// QualityIssue is something about a vehicle that makes for a worse listing--nothing wrong enough to turn it away,
+// but the kind of thing a dealer fixing their feed would want to know about
+type QualityIssue string
+
+// Quality issues
+const (
+	IssueMissingVIN      QualityIssue = "missing_vin"
+	IssueMissingPrice    QualityIssue = "missing_price"
+	IssueZeroOdometer    QualityIssue = "zero_odometer"
+	IssueNoDescription   QualityIssue = "no_description"
+	IssueMissingColours  QualityIssue = "missing_colours"
+	IssueImplausibleYear QualityIssue = "implausible_year"
+)
+
+const (
+	// perfectQuality is the score of a vehicle without any issues
+	perfectQuality = 100
+	// firstPlausibleYear is older than anything a dealer would be selling, short of a museum
+	firstPlausibleYear = 1900
+)
+
+// QualityIssues are every issue, in the order they're reported
+var QualityIssues = []QualityIssue{
+	IssueMissingVIN, IssueMissingPrice, IssueZeroOdometer, IssueNoDescription, IssueMissingColours, IssueImplausibleYear,
+}
+
+// QualityWeights are how many points out of 100 each issue costs a vehicle.  They add up to more than 100 on purpose--
+// a vehicle with most of them is as bad as it gets, and its score stops at 0
+var QualityWeights = map[QualityIssue]int{
+	IssueMissingVIN:      30,
+	IssueMissingPrice:    25,
+	IssueZeroOdometer:    15,
+	IssueNoDescription:   10,
+	IssueMissingColours:  10,
+	IssueImplausibleYear: 20,
+}
+
+// VehicleQuality is how a single vehicle scored: 100 less the weight of every issue it has, no less than 0
+type VehicleQuality struct {
+	Score  int
+	Issues []QualityIssue
+}
+
+// String lists the issues, or says there aren't any
+func (quality VehicleQuality) String() string {
+	if len(quality.Issues) == 0 {
+		return "none"
+	}
+	issues := make([]string, len(quality.Issues))
+	for i, issue := range quality.Issues {
+		issues[i] = string(issue)
+	}
+	return strings.Join(issues, ",")
+}
+
+// ScoreVehicle scores a vehicle on a lot of lotType.  asOf is when it's being scored, since a year that's
+// implausible now--next year's models, say--won't be forever
+func ScoreVehicle(vehicle FeedVehicle, lotType LotType, asOf time.Time) VehicleQuality {
+	quality := VehicleQuality{Score: perfectQuality, Issues: []QualityIssue{}}
+	flag := func(issue QualityIssue) {
+		quality.Issues = append(quality.Issues, issue)
+		quality.Score -= QualityWeights[issue]
+	}
+
+	if strings.TrimSpace(vehicle.VIN) == "" {
+		flag(IssueMissingVIN)
+	}
+	if vehicle.Price <= 0 {
+		flag(IssueMissingPrice)
+	}
+	// Nothing but a new vehicle, or one that isn't here yet, should have no miles on it at all
+	if vehicle.Odometer == 0 && lotType != TypeNew && lotType != TypeInTransit {
+		flag(IssueZeroOdometer)
+	}
+	if strings.TrimSpace(vehicle.Description) == "" {
+		flag(IssueNoDescription)
+	}
+	if strings.TrimSpace(vehicle.ExteriorColour) == "" || strings.TrimSpace(vehicle.InteriorColour) == "" {
+		flag(IssueMissingColours)
+	}
+	// Next year's models are on lots well before next year
+	if vehicle.Year < firstPlausibleYear || vehicle.Year > asOf.Year()+1 {
+		flag(IssueImplausibleYear)
+	}
+
+	if quality.Score < 0 {
+		quality.Score = 0
+	}
+	return quality
+}
+
+// LotQuality is how a lot's vehicles scored in a single import run, kept so a dealer can see whether their feed
+// is getting any better.  Score is the average of its vehicles' scores; the rest count how many had each issue
+type LotQuality struct {
+	ID              int       `gorm:"column:quality_id;primary_key"`
+	RunID           string    `gorm:"column:run_id;type:varchar(32);index"`
+	LotID           int       `gorm:"column:lot_id;index"`
+	Scored          time.Time `gorm:"column:scored_time"`
+	Vehicles        int       `gorm:"column:vehicles"`
+	Score           float64   `gorm:"column:score"`
+	MissingVIN      int       `gorm:"column:missing_vin"`
+	MissingPrice    int       `gorm:"column:missing_price"`
+	ZeroOdometer    int       `gorm:"column:zero_odometer"`
+	NoDescription   int       `gorm:"column:no_description"`
+	MissingColours  int       `gorm:"column:missing_colours"`
+	ImplausibleYear int       `gorm:"column:implausible_year"`
+}
+
+// TableName overrides the default table name "lot_qualities" for the gorm library
+func (LotQuality) TableName() string {
+	return "lot_quality"
+}
+
+// Add counts another vehicle's score into the lot's
+func (quality *LotQuality) Add(vehicle VehicleQuality) {
+	quality.Score = (quality.Score*float64(quality.Vehicles) + float64(vehicle.Score)) / float64(quality.Vehicles+1)
+	quality.Vehicles++
+	for _, issue := range vehicle.Issues {
+		*quality.count(issue)++
+	}
+}
+
+// merge counts the vehicles of another score of the same lot in the same run into this one
+func (quality *LotQuality) merge(other LotQuality) {
+	if quality.Vehicles+other.Vehicles > 0 {
+		quality.Score = (quality.Score*float64(quality.Vehicles) + other.Score*float64(other.Vehicles)) / float64(quality.Vehicles+other.Vehicles)
+	}
+	quality.Vehicles += other.Vehicles
+	for _, issue := range QualityIssues {
+		*quality.count(issue) += other.Count(issue)
+	}
+}
+
+// Count is how many of the lot's vehicles had issue
+func (quality LotQuality) Count(issue QualityIssue) int {
+	return *quality.count(issue)
+}
+
+// count is where the count for issue is kept
+func (quality *LotQuality) count(issue QualityIssue) *int {
+	switch issue {
+	case IssueMissingVIN:
+		return &quality.MissingVIN
+	case IssueMissingPrice:
+		return &quality.MissingPrice
+	case IssueZeroOdometer:
+		return &quality.ZeroOdometer
+	case IssueNoDescription:
+		return &quality.NoDescription
+	case IssueMissingColours:
+		return &quality.MissingColours
+	case IssueImplausibleYear:
+		return &quality.ImplausibleYear
+	}
+	// Every issue has a column, so this is a bug--but a wrong count is better than a panic halfway through an import
+	var nowhere int
+	return &nowhere
+}
+
+// LatestLotQuality finds the most recent score of every lot--or just the one dealer's lots, if dealerID isn't 0--
+// along with the score before it, when there is one.  They're in order of dealer and lot type
+func LatestLotQuality(db *gorm.DB, dealerID int) ([]LotQualityTrend, error) {
+	lots, err := Lots(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// The scores from the two most recent runs that replaced each lot.  A feed that comes back to a lot after moving
+	// on to another has the lot replaced--and scored--twice in the one run, so those get put back together
+	var scores []LotQuality
+	query := db.Where("(SELECT COUNT(DISTINCT later.run_id) FROM lot_quality later WHERE later.lot_id = lot_quality.lot_id AND later.quality_id > lot_quality.quality_id AND later.run_id <> lot_quality.run_id) < 2")
+	if dealerID != 0 {
+		query = query.Where("lot_id IN (?)", db.Model(&DealerLot{}).Select("lot_id").Where("d_id = ?", dealerID).SubQuery())
+	}
+	if err = query.Order("lot_id, quality_id DESC").Find(&scores).Error; err != nil {
+		return nil, fmt.Errorf("Finding lot quality: %w", err)
+	}
+
+	trends := []LotQualityTrend{}
+	for _, score := range scores {
+		if len(trends) == 0 || trends[len(trends)-1].LotID != score.LotID {
+			trends = append(trends, LotQualityTrend{LotQuality: score, Lot: lots[score.LotID]})
+			continue
+		}
+		trend := &trends[len(trends)-1]
+		switch {
+		case trend.RunID == score.RunID:
+			trend.LotQuality.merge(score)
+		case trend.Previous == nil:
+			previous := score
+			trend.Previous = &previous
+		default:
+			trend.Previous.merge(score)
+		}
+	}
+	sort.SliceStable(trends, func(i, j int) bool {
+		if trends[i].DealerID != trends[j].DealerID {
+			return trends[i].DealerID < trends[j].DealerID
+		}
+		return trends[i].LotType < trends[j].LotType
+	})
+	return trends, nil
+}
+
+// LotQualityTrend is a lot's latest score, and the one before it--nil when there's only been the one
+type LotQualityTrend struct {
+	LotQuality
+	Lot
+	Previous *LotQuality
+}
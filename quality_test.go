@@ -0,0 +1,135 @@
+package dealer_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/seamuncle/dealer"
+	"github.com/seamuncle/dealer/importer/importertest"
+)
+
+func TestScoreVehicle(t *testing.T) {
+	asOf := time.Date(2019, time.December, 1, 0, 0, 0, 0, time.UTC)
+	listed := dealer.FeedVehicle{
+		VehicleKey: dealer.VehicleKey{VIN: "1GCEP22T1G3329139", Stock: "A124"}, Year: 2018, Odometer: 14575, Price: 29999,
+		Description: "One owner", ExteriorColour: "Oxford White", InteriorColour: "Ebony Black",
+	}
+	for _, test := range []struct {
+		name    string
+		lotType dealer.LotType
+		change  func(*dealer.FeedVehicle)
+		score   int
+		issues  string
+	}{
+		{"nothing wrong", dealer.TypeUsed, func(*dealer.FeedVehicle) {}, 100, "none"},
+		{"no VIN", dealer.TypeUsed, func(vehicle *dealer.FeedVehicle) { vehicle.VIN = " " }, 70, "missing_vin"},
+		{"no price", dealer.TypeUsed, func(vehicle *dealer.FeedVehicle) { vehicle.Price = 0 }, 75, "missing_price"},
+		{"no miles on a used car", dealer.TypeUsed, func(vehicle *dealer.FeedVehicle) { vehicle.Odometer = 0 }, 85, "zero_odometer"},
+		// ...is what a new one, or one that isn't here yet, should have
+		{"no miles on a new car", dealer.TypeNew, func(vehicle *dealer.FeedVehicle) { vehicle.Odometer = 0 }, 100, "none"},
+		{"no miles in transit", dealer.TypeInTransit, func(vehicle *dealer.FeedVehicle) { vehicle.Odometer = 0 }, 100, "none"},
+		{"no description", dealer.TypeUsed, func(vehicle *dealer.FeedVehicle) { vehicle.Description = "" }, 90, "no_description"},
+		{"one colour", dealer.TypeUsed, func(vehicle *dealer.FeedVehicle) { vehicle.InteriorColour = "" }, 90, "missing_colours"},
+		{"next year's model", dealer.TypeNew, func(vehicle *dealer.FeedVehicle) { vehicle.Year = 2020 }, 100, "none"},
+		{"the year after", dealer.TypeNew, func(vehicle *dealer.FeedVehicle) { vehicle.Year = 2021 }, 80, "implausible_year"},
+		{"a museum piece", dealer.TypeUsed, func(vehicle *dealer.FeedVehicle) { vehicle.Year = 1899 }, 80, "implausible_year"},
+		// Worth more than 100 points altogether, and the score stops at 0
+		{"everything", dealer.TypeUsed, func(vehicle *dealer.FeedVehicle) { *vehicle = dealer.FeedVehicle{} }, 0,
+			"missing_vin,missing_price,zero_odometer,no_description,missing_colours,implausible_year"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			vehicle := listed
+			test.change(&vehicle)
+			quality := dealer.ScoreVehicle(vehicle, test.lotType, asOf)
+			if quality.Score != test.score || quality.String() != test.issues {
+				t.Errorf("Scored %d (%s), want %d (%s)", quality.Score, quality, test.score, test.issues)
+			}
+		})
+	}
+}
+
+func TestLotQualityAdd(t *testing.T) {
+	var lot dealer.LotQuality
+	for _, vehicle := range []dealer.VehicleQuality{
+		{Score: 100, Issues: []dealer.QualityIssue{}},
+		{Score: 70, Issues: []dealer.QualityIssue{dealer.IssueMissingVIN}},
+		{Score: 40, Issues: []dealer.QualityIssue{dealer.IssueMissingVIN, dealer.IssueMissingPrice, dealer.IssueZeroOdometer}},
+	} {
+		lot.Add(vehicle)
+	}
+	if lot.Vehicles != 3 || lot.Score != 70 {
+		t.Errorf("Lot scored %g over %d vehicles, want 70 over 3", lot.Score, lot.Vehicles)
+	}
+	for issue, want := range map[dealer.QualityIssue]int{dealer.IssueMissingVIN: 2, dealer.IssueMissingPrice: 1, dealer.IssueZeroOdometer: 1, dealer.IssueNoDescription: 0} {
+		if got := lot.Count(issue); got != want {
+			t.Errorf("%d vehicles with %s, want %d", got, issue, want)
+		}
+	}
+}
+
+func TestLatestLotQuality(t *testing.T) {
+	db := importertest.OpenDB(t)
+	defer db.Close()
+	newLot, usedLot, otherLot := importertest.Lot(1001, dealer.TypeNew), importertest.Lot(1001, dealer.TypeUsed), importertest.Lot(1022, dealer.TypeNew)
+	seeded := importertest.Seed(t, db,
+		importertest.Vehicle(otherLot, "Z205", "3VW4S7AT3EM654568").Build(),
+		importertest.Vehicle(usedLot, "B105", "KM8SB12B02U162029").Build(),
+		importertest.Vehicle(newLot, "A124", "1GCEP22T1G3329139").Build(),
+	)
+	otherID, usedID, newID := seeded[0].LotID, seeded[1].LotID, seeded[2].LotID
+
+	for _, score := range []dealer.LotQuality{
+		{RunID: "r1", LotID: newID, Vehicles: 2, Score: 50, MissingVIN: 2},
+		{RunID: "r1", LotID: usedID, Vehicles: 1, Score: 60},
+		{RunID: "r2", LotID: newID, Vehicles: 1, Score: 70, MissingVIN: 1},
+		{RunID: "r2", LotID: otherID, Vehicles: 1, Score: 100},
+		// A feed that came back to the lot, so it was scored twice in the one run
+		{RunID: "r3", LotID: newID, Vehicles: 1, Score: 80, MissingVIN: 1},
+		{RunID: "r3", LotID: newID, Vehicles: 3, Score: 90, MissingVIN: 2},
+	} {
+		if err := db.Create(&score).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trends, err := dealer.LatestLotQuality(db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type trend struct {
+		lot                dealer.Lot
+		run                string
+		vehicles           int
+		score              float64
+		missingVIN         int
+		previousRun        string
+		previousScore      float64
+		previousMissingVIN int
+	}
+	got := []trend{}
+	for _, latest := range trends {
+		summary := trend{latest.Lot, latest.RunID, latest.Vehicles, latest.Score, latest.MissingVIN, "", 0, 0}
+		if latest.Previous != nil {
+			summary.previousRun, summary.previousScore, summary.previousMissingVIN = latest.Previous.RunID, latest.Previous.Score, latest.Previous.MissingVIN
+		}
+		got = append(got, summary)
+	}
+	// In order of dealer and lot type, and r1 is too long ago for the new lot to care about
+	want := []trend{
+		{newLot, "r3", 4, 87.5, 3, "r2", 70, 1},
+		{usedLot, "r1", 1, 60, 0, "", 0, 0},
+		{otherLot, "r2", 1, 100, 0, "", 0, 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Trends are\n%+v\nwant\n%+v", got, want)
+	}
+
+	trends, err = dealer.LatestLotQuality(db, 1022)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trends) != 1 || trends[0].Lot != otherLot {
+		t.Errorf("Dealer 1022's trends are %+v, want just its lot", trends)
+	}
+}
@@ -0,0 +1,79 @@
+package dealer
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Transfer records a vehicle moving from one lot to another--a dealer trade, or a NEW unit that
+// came back as USED.  The vehicle keeps its v_id and created_time; this is where the move is remembered
+type Transfer struct {
+	ID          int       `gorm:"column:transfer_id;primary_key"`
+	VehicleID   int       `gorm:"column:v_id;index"`
+	VIN         string    `gorm:"column:vin"`
+	FromLotID   int       `gorm:"column:from_lot_id"`
+	ToLotID     int       `gorm:"column:to_lot_id"`
+	Transferred time.Time `gorm:"column:transferred_time"`
+	TheGuilty   string    `gorm:"column:transferred_by"`
+}
+
+// TableName overrides the default table name "transfers" for the gorm library
+func (Transfer) TableName() string {
+	return "inventory_transfers"
+}
+
+// Transfers lists every move a vehicle has made, oldest first
+func Transfers(db *gorm.DB, vehicleID int) ([]Transfer, error) {
+	var transfers []Transfer
+	if err := db.Where("v_id = ?", vehicleID).Order("transferred_time, transfer_id").Find(&transfers).Error; err != nil {
+		return nil, fmt.Errorf("Finding transfers of vehicle %d: %w", vehicleID, err)
+	}
+	return transfers, nil
+}
+
+// VINClaim is a VIN more than one dealer has in inventory at once--at most one of them can be right
+type VINClaim struct {
+	VIN      string
+	Vehicles []Vehicle
+}
+
+// MultiDealerClaims finds every VIN in inventory on lots belonging to more than one dealer, with the
+// vehicles claiming it.  The Lot on each vehicle is filled in from the master tables.  VINs are compared
+// normalized, the same as an import compares them, which SQL can't do--so it's done here
+func MultiDealerClaims(db *gorm.DB) ([]VINClaim, error) {
+	lots, err := Lots(db)
+	if err != nil {
+		return nil, err
+	}
+	var vehicles []Vehicle
+	if err = db.Where("TRIM(vin) <> ''").Order("v_id").Find(&vehicles).Error; err != nil {
+		return nil, fmt.Errorf("Finding VINs claimed by more than one dealer: %w", err)
+	}
+
+	byVIN := map[string][]Vehicle{}
+	for _, vehicle := range vehicles {
+		lot, ok := lots[vehicle.LotID]
+		if !ok {
+			continue
+		}
+		vehicle.Lot = lot
+		if vin := NormalizeVIN(vehicle.VIN); vin != "" {
+			byVIN[vin] = append(byVIN[vin], vehicle)
+		}
+	}
+
+	var claims []VINClaim
+	for vin, claimants := range byVIN {
+		for _, claimant := range claimants[1:] {
+			if claimant.Lot.DealerID != claimants[0].Lot.DealerID {
+				claims = append(claims, VINClaim{VIN: vin, Vehicles: claimants})
+				break
+			}
+		}
+	}
+	sort.Slice(claims, func(i, j int) bool { return claims[i].VIN < claims[j].VIN })
+	return claims, nil
+}
@@ -0,0 +1,38 @@
+package dealer_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/seamuncle/dealer"
+	"github.com/seamuncle/dealer/importer/importertest"
+)
+
+func TestMultiDealerClaims(t *testing.T) {
+	db := importertest.OpenDB(t)
+	defer db.Close()
+	importertest.Seed(t, db,
+		importertest.Vehicle(importertest.Lot(1001, dealer.TypeNew), "A124", "1GCEP22T1G3329139").Build(),
+		importertest.Vehicle(importertest.Lot(1022, dealer.TypeUsed), "Z124", "1gcep22t1-g3329139").Build(),
+		// The same dealer twice over is a transfer waiting to happen, not a claim
+		importertest.Vehicle(importertest.Lot(1001, dealer.TypeNew), "B105", "KM8SB12B02U162029").Build(),
+		importertest.Vehicle(importertest.Lot(1001, dealer.TypeUsed), "U105", "KM8SB12B02 U162029").Build(),
+		importertest.Vehicle(importertest.Lot(1022, dealer.TypeNew), "Z900", "").Build(),
+		importertest.Vehicle(importertest.Lot(1001, dealer.TypeNew), "N900", " ").Build(),
+	)
+
+	claims, err := dealer.MultiDealerClaims(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claims) != 1 || claims[0].VIN != "1GCEP22T1G3329139" {
+		t.Fatalf("Claims are %+v, want the one VIN however it's written", claims)
+	}
+	got := []string{}
+	for _, vehicle := range claims[0].Vehicles {
+		got = append(got, vehicle.Stock+" "+vehicle.Lot.DealerName)
+	}
+	if want := []string{"A124 Dealer 1001", "Z124 Dealer 1022"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Claimed by %v, want %v", got, want)
+	}
+}
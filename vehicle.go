@@ -1,7 +1,10 @@
 package dealer
 
 import (
+	"reflect"
+	"strings"
 	"time"
+	"unicode"
 )
 
 // VehicleState marks something about a vehicle's known relation to the database
@@ -16,6 +19,10 @@ const (
 	StateAltered
 	// StateUnaltered indicates there is no difference between a feed vehicle and its db counterpart
 	StateUnaltered
+	// StateHeld indicates a vehicle the feed couldn't be trusted with this time around--it's left as the database has it
+	StateHeld
+	// StateTransferred indicates a vehicle the feed found on another lot, which is moving to this one
+	StateTransferred
 )
 
 // VehicleKey is a reaonable way to uniquely identify a vehicle--given the high likelyhood
@@ -29,12 +36,15 @@ type VehicleKey struct {
 
 // Vehicle represents a composite representation of a vehicle on an import feed
 // and in the database
+// The Lot isn't stored with the vehicle--LotID refers to its master record in the lots table--but
+// it's what a feed gives us, and what everything downstream wants to see, so it goes along for the ride
 type Vehicle struct {
 	ID           int       `gorm:"column:v_id;primary_key"`
+	LotID        int       `gorm:"column:lot_id;index;not null"`
 	Created      time.Time `gorm:"column:created_time"`
 	TheGuilty    string    `gorm:"column:last_modified_by"`
 	LastModified time.Time `gorm:"column:last_modified_time"`
-	Lot          `gorm:"embedded"`
+	Lot          `gorm:"-"`
 	FeedVehicle  `gorm:"embedded"`
 	State        VehicleState `gorm:"-"`
 }
@@ -66,9 +76,105 @@ type FeedVehicle struct {
 	TransmissionSpeeds int     `gorm:"column:transmission_speeds"`
 	TransmissionDesc   string  `gorm:"column:transmission_description"`
 	Drive              string  `gorm:"column:drivetrain"`
-	Odometer           int     `gorm:"column:odometer"`
-	Price              float64 `gorm:"column:price"`
-	MSRP               float64 `gorm:"column:msrp"`
-	Description        string  `gorm:"column:description"`
-	Passengers         int     `gorm:"column:passengers"`
+	// BatteryCapacity is in kWh, and Range is how far it goes on a charge, in whatever Odometer is in--both 0
+	// for anything that doesn't plug in.  ChargePort is every port it has, comma separated (see ParseChargePort)
+	BatteryCapacity float64 `gorm:"column:battery_capacity"`
+	Range           int     `gorm:"column:electric_range"`
+	ChargePort      string  `gorm:"column:charge_port"`
+	Motors          int     `gorm:"column:motors"`
+	Odometer        int     `gorm:"column:odometer"`
+	Price           float64 `gorm:"column:price"`
+	MSRP            float64 `gorm:"column:msrp"`
+	Description     string  `gorm:"column:description"`
+	Passengers      int     `gorm:"column:passengers"`
+	// Certified is whether the vehicle is certified pre-owned, and CertificationProgram whose program certified it--
+	// a feed saying "Yes" leaves us knowing the one but not the other
+	Certified            bool   `gorm:"column:certified"`
+	CertificationProgram string `gorm:"column:certification_program"`
+	// InStock is the day the dealer says the vehicle arrived on the lot, which isn't the day we first saw it
+	InStock Date `gorm:"column:date_in_stock;type:date"`
+}
+
+// ParseCertified makes sense of a feed's certified column: yes/no and friends, or the name of the
+// certification program--which means yes.  "Certified" or "CPO" is kept as the program, since we can't tell
+// it from one, and whatever we write back out has to come back in the same
+func ParseCertified(value string) (certified bool, program string) {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(value) {
+	case "", "no", "n", "false", "f", "0", "none", "not certified":
+		return false, ""
+	case "yes", "y", "true", "t", "1":
+		return true, ""
+	}
+	return true, value
+}
+
+// NormalizeVIN upper-cases a VIN and drops anything that isn't a letter or digit--feeds space, dash and
+// lower-case them however they like, and a VIN is the same VIN regardless.  Anything comparing VINs goes through here
+func NormalizeVIN(vin string) string {
+	return strings.ToUpper(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, vin))
+}
+
+// FieldChange describes a single field that differs between two FeedVehicles
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Column string      `json:"column"`
+	Old    interface{} `json:"old"`
+	New    interface{} `json:"new"`
+}
+
+// Diff lists the fields of other that differ from vehicle, in struct order
+// Struct equivalency tells us *that* something changed; this tells us what
+func (vehicle FeedVehicle) Diff(other FeedVehicle) []FieldChange {
+	return diffFields(reflect.ValueOf(vehicle), reflect.ValueOf(other), nil)
+}
+
+// diffFields walks a pair of identically typed structs, descending into embedded ones
+// so VehicleKey's fields show up as VIN and Stock rather than VehicleKey
+func diffFields(old, new reflect.Value, changes []FieldChange) []FieldChange {
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			changes = diffFields(old.Field(i), new.Field(i), changes)
+			continue
+		}
+		if old.Field(i).Interface() != new.Field(i).Interface() {
+			changes = append(changes, FieldChange{
+				Field:  field.Name,
+				Column: columnName(field),
+				Old:    old.Field(i).Interface(),
+				New:    new.Field(i).Interface(),
+			})
+		}
+	}
+	return changes
+}
+
+// columnName digs the column out of a gorm tag, which is the name anything outside of Go knows a field by
+func columnName(field reflect.StructField) string {
+	for _, setting := range strings.Split(field.Tag.Get("gorm"), ";") {
+		if strings.HasPrefix(setting, "column:") {
+			return strings.TrimPrefix(setting, "column:")
+		}
+	}
+	return field.Name
+}
+
+// Updates are the columns an update of vehicle has to write, given changes to its FeedVehicle: exactly the columns
+// that changed, zero values included, plus who changed it and when.  A map, because that's the only way gorm
+// will write a zero value, and because it's what goes in the SET of a hand-built update too
+func (vehicle Vehicle) Updates(changes []FieldChange) map[string]interface{} {
+	columns := map[string]interface{}{
+		"last_modified_by":   vehicle.TheGuilty,
+		"last_modified_time": vehicle.LastModified,
+	}
+	for _, change := range changes {
+		columns[change.Column] = change.New
+	}
+	return columns
 }
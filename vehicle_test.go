@@ -0,0 +1,65 @@
+package dealer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	was := FeedVehicle{VehicleKey: VehicleKey{VIN: "1GCEP22T1G3329139", Stock: "A124"}, Year: 2018, Make: "Ford", Odometer: 14575, Price: 29999, Certified: true}
+	for _, test := range []struct {
+		name string
+		now  func(*FeedVehicle)
+		want []FieldChange
+	}{
+		{"nothing changed", func(now *FeedVehicle) {}, nil},
+		{"price", func(now *FeedVehicle) { now.Price = 28999 },
+			[]FieldChange{{Field: "Price", Column: "price", Old: 29999.0, New: 28999.0}}},
+		// Embedded structs are looked into, so it's the VIN that changed and not the VehicleKey
+		{"VIN", func(now *FeedVehicle) { now.VIN = "1GCEP22T1G3329130" },
+			[]FieldChange{{Field: "VIN", Column: "vin", Old: "1GCEP22T1G3329139", New: "1GCEP22T1G3329130"}}},
+		// Going to a zero value is a change like any other
+		{"to zero", func(now *FeedVehicle) { now.Odometer, now.Certified = 0, false }, []FieldChange{
+			{Field: "Odometer", Column: "odometer", Old: 14575, New: 0},
+			{Field: "Certified", Column: "certified", Old: true, New: false},
+		}},
+		{"in struct order", func(now *FeedVehicle) {
+			now.Price, now.Stock, now.InStock = 1, "A125", DateOf(time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC))
+		}, []FieldChange{
+			{Field: "Stock", Column: "stock_id", Old: "A124", New: "A125"},
+			{Field: "Price", Column: "price", Old: 29999.0, New: 1.0},
+			{Field: "InStock", Column: "date_in_stock", Old: Date{}, New: DateOf(time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC))},
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			now := was
+			test.now(&now)
+			if got := was.Diff(now); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Diff is %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUpdates(t *testing.T) {
+	modified := time.Date(2019, 12, 1, 12, 0, 0, 0, time.UTC)
+	vehicle := Vehicle{ID: 1, TheGuilty: "IMPORT", LastModified: modified, FeedVehicle: FeedVehicle{Odometer: 14575, Price: 29999}}
+	now := vehicle.FeedVehicle
+	now.Odometer, now.Price = 0, 28999
+
+	want := map[string]interface{}{
+		"odometer":           0,
+		"price":              28999.0,
+		"last_modified_by":   "IMPORT",
+		"last_modified_time": modified,
+	}
+	if got := vehicle.Updates(vehicle.Diff(now)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Updates are %v, want %v", got, want)
+	}
+
+	// Nothing changed is still somebody having looked at it
+	if got := vehicle.Updates(nil); len(got) != 2 {
+		t.Errorf("Updates with no changes are %v, want who and when", got)
+	}
+}